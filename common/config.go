@@ -10,41 +10,62 @@ import (
 
 // Config is representation of the configuration data
 type Config struct {
-	BaseURL          string
-	Decommission     Decommissioner
-	Destroy          Destroyer
-	Email            Emailer
-	Filter           map[string]string
-	Interval         string
-	Listen           string
-	LogLevel         string
-	Notify           Notifier
-	SearchEngine     map[string]string
-	UserDatasource   map[string]string
-	Tagging          Tagging
-	EncryptionSecret string
-	RedirectURL      string
-	SpinupURL        string
-	SpinupSiteURL    string
-	Token            string
-	EventReporters   map[string]map[string]string
-	Webhooks         []Webhook
+	BaseURL           string
+	Decommission      Decommissioner
+	Destroy           Destroyer
+	DryRun            bool
+	Email             Emailer
+	Filter            map[string]string
+	Filters           []Filter
+	Interval          string
+	Listen            string
+	LogLevel          string
+	Notify            Notifier
+	Policies          []Policy
+	RateLimit         RateLimiter
+	SearchEngine      map[string]string
+	UserDatasource    map[string]string
+	Tagging           Tagging
+	EncryptionSecret  string
+	EncryptionSecrets []string
+	RedirectURL       string
+	Renewal           Renewer
+	RenewalTokenTTL   string
+	SpinupURL         string
+	SpinupSiteURL     string
+	Token             string
+	EventReporters    map[string]map[string]string
+	Webhooks          []Webhook
 }
 
-// Emailer configures the email sending process
+// Emailer configures the email sending process.  TLS is the way the connection to the mail server is secured
+// (none, starttls or implicit), STARTTLS is used if the server offers it when it isn't set.  CACert is the path to
+// a PEM encoded CA certificate the mail server is verified with, in addition to the system roots.  Auth is the
+// authentication mechanism (none, plain, login or cram-md5), plain is used if a username and password are set.
+// Transport is how the email is delivered (smtp, sendmail, maildir, mbox or capture), smtp is the default.  Sendmail
+// is the path to the sendmail binary and Path is the maildir directory or mbox file.
 type Emailer struct {
+	Auth       string
+	CACert     string
 	From       string
 	Mailserver string
 	Password   string
+	Path       string
+	Sendmail   string
+	TLS        string
+	Transport  string
 	Username   string
 }
 
-// Notifier configures the notification process
+// Notifier configures the notification process.  Channels are the channels warnings are sent over keyed
+// by the channel type (email, http, slack or teams), only email is used if they aren't configured.  The
+// Endpoint, Token and EncryptToken configure the http channel.
 type Notifier struct {
 	Age          []string
 	Endpoint     string
 	Token        string
 	EncryptToken bool
+	Channels     map[string]map[string]string
 }
 
 // Destroyer configures the deletion process
@@ -63,6 +84,30 @@ type Decommissioner struct {
 	EncryptToken bool
 }
 
+// RateLimiter configures the rate limits of the public renewal endpoint.  Requests is the number of requests
+// allowed per client IP and ResourceRequests the number of requests allowed per resource within the Window.
+// Clients are locked out for the Lockout duration after MaxFailures invalid renewal tokens.  The client IP is
+// taken from the X-Forwarded-For header set by the proxy in front of the reaper if TrustForwardedFor is set.
+type RateLimiter struct {
+	Requests          int
+	ResourceRequests  int
+	Window            string
+	MaxFailures       int
+	Lockout           string
+	TrustForwardedFor bool
+}
+
+// Renewer configures the renewal extensions users can choose from.  The extension is how long after the
+// renewal the resource will be decommissioned, the full decommission age is used if one isn't chosen.
+// MaxRenewals limits how many times a resource can be renewed and MaxLifetime limits how long after its
+// creation a resource can be kept, neither is limited if they aren't set.
+type Renewer struct {
+	Extensions   []string
+	MaxExtension string
+	MaxRenewals  int
+	MaxLifetime  string
+}
+
 // Tagging configures the tag update process
 type Tagging struct {
 	Endpoint     string
@@ -70,6 +115,35 @@ type Tagging struct {
 	EncryptToken bool
 }
 
+// Policy configures the lifecycle of the resources matching its filters.  Policy filters are ANDed with
+// the global filters and ages that aren't set fall back to the global notify, decommission and destroy ages.
+type Policy struct {
+	Name            string
+	Filter          map[string]string
+	Filters         []Filter
+	NotifyAge       []string
+	DecommissionAge string
+	DestroyAge      string
+	Extensions      []string
+	MaxExtension    string
+	MaxRenewals     int
+	MaxLifetime     string
+}
+
+// Filter configures a filter clause on the searches for resources.  Op is one of term (the default),
+// terms, exists, missing, prefix, wildcard or range.  Setting Not excludes matching resources instead.
+type Filter struct {
+	Field  string
+	Op     string
+	Value  string
+	Values []string
+	Not    bool
+	Gt     string
+	Gte    string
+	Lt     string
+	Lte    string
+}
+
 // Webhook configures the webhook endpoints
 type Webhook struct {
 	Endpoint string
@@ -78,6 +152,70 @@ type Webhook struct {
 	Actions  []string
 }
 
+// LifecyclePolicies returns the configured policies with their ages defaulted from the global configuration.
+// If no policies are configured, a single policy named "default" using the global ages is returned.
+func (c *Config) LifecyclePolicies() []Policy {
+	if len(c.Policies) == 0 {
+		return []Policy{
+			{
+				Name:            "default",
+				NotifyAge:       c.Notify.Age,
+				DecommissionAge: c.Decommission.Age,
+				DestroyAge:      c.Destroy.Age,
+				Extensions:      c.Renewal.Extensions,
+				MaxExtension:    c.Renewal.MaxExtension,
+				MaxRenewals:     c.Renewal.MaxRenewals,
+				MaxLifetime:     c.Renewal.MaxLifetime,
+			},
+		}
+	}
+
+	policies := make([]Policy, len(c.Policies))
+	for i, p := range c.Policies {
+		if len(p.NotifyAge) == 0 {
+			p.NotifyAge = c.Notify.Age
+		}
+
+		if p.DecommissionAge == "" {
+			p.DecommissionAge = c.Decommission.Age
+		}
+
+		if p.DestroyAge == "" {
+			p.DestroyAge = c.Destroy.Age
+		}
+
+		if len(p.Extensions) == 0 {
+			p.Extensions = c.Renewal.Extensions
+		}
+
+		if p.MaxExtension == "" {
+			p.MaxExtension = c.Renewal.MaxExtension
+		}
+
+		if p.MaxRenewals == 0 {
+			p.MaxRenewals = c.Renewal.MaxRenewals
+		}
+
+		if p.MaxLifetime == "" {
+			p.MaxLifetime = c.Renewal.MaxLifetime
+		}
+
+		policies[i] = p
+	}
+
+	return policies
+}
+
+// RenewalSecrets returns the secrets used for renewal tokens.  The first secret signs new tokens and all of them
+// are accepted when validating.  If the list of secrets isn't configured, the single encryption secret is returned.
+func (c *Config) RenewalSecrets() []string {
+	if len(c.EncryptionSecrets) == 0 {
+		return []string{c.EncryptionSecret}
+	}
+
+	return append([]string{}, c.EncryptionSecrets...)
+}
+
 // ReadConfig decodes the configuration from an io Reader
 func ReadConfig(r io.Reader) (Config, error) {
 	var c Config
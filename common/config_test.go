@@ -20,6 +20,10 @@ var testConfig = []byte(
 		"filter": {
 		  "yale:subsidized": "true"
 		},
+		"filters": [
+		  { "field": "yale:org", "op": "terms", "values": ["dinosaurs", "lizards"], "not": true },
+		  { "field": "cpus", "op": "range", "gte": "2", "lt": "8" }
+		],
 		"email": {
 			"mailserver": "nofilter.thomas.hooker",
 			"from": "Nummy Nummy <nummy@stubborn.beauty>",
@@ -78,7 +82,11 @@ func TestReadConfig(t *testing.T) {
 			Username:   "CounterWeight",
 			Password:   "HeadWay",
 		},
-		Filter:   map[string]string{"yale:subsidized": "true"},
+		Filter: map[string]string{"yale:subsidized": "true"},
+		Filters: []Filter{
+			{Field: "yale:org", Op: "terms", Values: []string{"dinosaurs", "lizards"}, Not: true},
+			{Field: "cpus", Op: "range", Gte: "2", Lt: "8"},
+		},
 		Interval: "15s",
 		Listen:   "127.0.0.1:8080",
 		LogLevel: "debug",
@@ -109,3 +117,50 @@ func TestReadConfig(t *testing.T) {
 		t.Errorf("actual:   %+v", actualConfig)
 	}
 }
+
+func TestLifecyclePolicies(t *testing.T) {
+	config := Config{
+		Notify:       Notifier{Age: []string{"23d", "29d"}},
+		Decommission: Decommissioner{Age: "30d"},
+		Destroy:      Destroyer{Age: "44d"},
+		Renewal:      Renewer{Extensions: []string{"7d"}, MaxRenewals: 3, MaxLifetime: "365d"},
+	}
+
+	expected := []Policy{
+		{Name: "default", NotifyAge: []string{"23d", "29d"}, DecommissionAge: "30d", DestroyAge: "44d", Extensions: []string{"7d"}, MaxRenewals: 3, MaxLifetime: "365d"},
+	}
+
+	if actual := config.LifecyclePolicies(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected default policies %+v, got %+v", expected, actual)
+	}
+
+	config.Policies = []Policy{
+		{Name: "tryit", Filter: map[string]string{"yale:subsidized": "true"}},
+		{Name: "courses", NotifyAge: []string{"110d"}, DecommissionAge: "120d", DestroyAge: "134d", MaxExtension: "180d", MaxRenewals: 5},
+	}
+
+	expected = []Policy{
+		{Name: "tryit", Filter: map[string]string{"yale:subsidized": "true"}, NotifyAge: []string{"23d", "29d"}, DecommissionAge: "30d", DestroyAge: "44d", Extensions: []string{"7d"}, MaxRenewals: 3, MaxLifetime: "365d"},
+		{Name: "courses", NotifyAge: []string{"110d"}, DecommissionAge: "120d", DestroyAge: "134d", Extensions: []string{"7d"}, MaxExtension: "180d", MaxRenewals: 5, MaxLifetime: "365d"},
+	}
+
+	if actual := config.LifecyclePolicies(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected policies %+v, got %+v", expected, actual)
+	}
+
+	if config.Policies[0].DecommissionAge != "" {
+		t.Error("Expected LifecyclePolicies not to modify the configured policies")
+	}
+}
+
+func TestRenewalSecrets(t *testing.T) {
+	config := Config{EncryptionSecret: "54321"}
+	if secrets := config.RenewalSecrets(); !reflect.DeepEqual(secrets, []string{"54321"}) {
+		t.Errorf("Expected the encryption secret, got %v", secrets)
+	}
+
+	config.EncryptionSecrets = []string{"abcde", "54321"}
+	if secrets := config.RenewalSecrets(); !reflect.DeepEqual(secrets, []string{"abcde", "54321"}) {
+		t.Errorf("Expected the list of encryption secrets, got %v", secrets)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+const (
+	// csrfCookieName is the name of the cookie holding the CSRF token
+	csrfCookieName = "reaper_csrf"
+
+	// csrfFormField is the name of the form field holding the CSRF token
+	csrfFormField = "csrf_token"
+)
+
+// newCSRFToken generates a random CSRF token
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// setCSRFCookie sets the CSRF token cookie for the given path.  The same token is expected to be
+// submitted in the form, so a cross site request can't forge the form submission (double submit cookie).
+func setCSRFCookie(w http.ResponseWriter, path, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     path,
+		MaxAge:   3600,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(AppConfig.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// validCSRFToken checks that the CSRF token submitted in the form matches the CSRF token cookie
+func validCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	token := r.PostFormValue(csrfFormField)
+	if token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(token)) == 1
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNewCSRFToken(t *testing.T) {
+	a, err := newCSRFToken()
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	b, err := newCSRFToken()
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if a == "" || a == b {
+		t.Errorf("Expected unique non-empty tokens, got %s and %s", a, b)
+	}
+}
+
+func TestValidCSRFToken(t *testing.T) {
+	tests := []struct {
+		cookie string
+		form   string
+		valid  bool
+	}{
+		{"abc", "abc", true},
+		{"abc", "abd", false},
+		{"", "abc", false},
+		{"abc", "", false},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		form := url.Values{}
+		if test.form != "" {
+			form.Set(csrfFormField, test.form)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/reaper/renew/i-123", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if test.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: test.cookie})
+		}
+
+		if valid := validCSRFToken(req); valid != test.valid {
+			t.Errorf("Expected %t for cookie '%s' and form '%s', got %t", test.valid, test.cookie, test.form, valid)
+		}
+	}
+}
+
+func TestSetCSRFCookie(t *testing.T) {
+	withTestConfig(t, testPlanConfig)
+	AppConfig.BaseURL = "https://reaper.example.com/v1/reaper"
+
+	rr := httptest.NewRecorder()
+	setCSRFCookie(rr, "/v1/reaper/renew/i-123", "abc")
+
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Expected one cookie, got %d", len(cookies))
+	}
+
+	c := cookies[0]
+	if c.Name != csrfCookieName || c.Value != "abc" || c.Path != "/v1/reaper/renew/i-123" || !c.HttpOnly || !c.Secure {
+		t.Errorf("Unexpected CSRF cookie %+v", c)
+	}
+}
@@ -19,6 +19,9 @@ type Decommissioner struct {
 	ResourceID   string
 	Org          string
 	Client       HTTPClient
+
+	// User is the acting user sent to the decommission endpoint, it defaults to 'reaper'
+	User string
 }
 
 // NewDecommissioner creates a new decommissioning object
@@ -47,11 +50,20 @@ func NewDecommissioner(endpoint, token, id, org string, encryptToken bool) (Deco
 // SetStatus decommissions the instance by 'PUT'ing a new status to it
 func (d Decommissioner) SetStatus() error {
 	log.Debugf("Decomming with endpoint: %s, resource: %s, org: %s  ", d.Endpoint, d.ResourceID, d.Org)
+	return d.putStatus("decom")
+}
+
+// Restore restores a decommissioned instance by 'PUT'ing the created status back to it
+func (d Decommissioner) Restore() error {
+	log.Debugf("Restoring with endpoint: %s, resource: %s, org: %s  ", d.Endpoint, d.ResourceID, d.Org)
+	return d.putStatus("created")
+}
 
+func (d Decommissioner) putStatus(status string) error {
 	data, err := json.Marshal(struct {
 		Status string `json:"status"`
 	}{
-		Status: "decom",
+		Status: status,
 	})
 	if err != nil {
 		return err
@@ -60,14 +72,19 @@ func (d Decommissioner) SetStatus() error {
 	log.Debugf("Marshalled JSON body %s, creating new HTTP request", string(data))
 
 	url := fmt.Sprintf("%s/%s/%s/status", d.Endpoint, d.Org, d.ResourceID)
-	log.Debugf("Generated URL for decom status request: %s", url)
+	log.Debugf("Generated URL for %s status request: %s", status, url)
 
 	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
 
-	req.Header.Set("X-Forwarded-User", "reaper")
+	user := d.User
+	if user == "" {
+		user = "reaper"
+	}
+
+	req.Header.Set("X-Forwarded-User", user)
 	req.Header.Set("X-Auth-token", d.Token)
 	req.Header.Set("Content-Type", "application/json")
 	res, err := d.Client.Do(req)
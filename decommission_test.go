@@ -2,8 +2,15 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
 )
 
 var (
@@ -62,3 +69,99 @@ func TestSetStatus(t *testing.T) {
 		t.Error("Expected 500 error from http client to cause an error, got success")
 	}
 }
+
+func TestRestore(t *testing.T) {
+	decom, err := NewDecommissioner(testDecomEndpoint, testDecomToken, testDecomResourceID, testDecomOrg, testDecomEncryptToken)
+	if err != nil {
+		t.Errorf("Expected nil error for new decommissioner, got %s", err)
+	}
+	decom.User = "bob"
+
+	successClient := NewMockClient([]byte("ok"), 200)
+	successClient.Method = http.MethodPut
+	successClient.HeaderMap = map[string]string{
+		"X-Forwarded-User": "bob",
+		"X-Auth-token":     testDecomToken,
+		"Content-Type":     "application/json",
+	}
+
+	decom.Client = successClient
+	err = decom.Restore()
+	if err != nil {
+		t.Error("Expected successful restore, got", err)
+	}
+
+	errorClient := NewMockClient([]byte("fail"), 500)
+	decom.Client = errorClient
+	err = decom.Restore()
+	if err == nil {
+		t.Error("Expected 500 error from http client to cause an error, got success")
+	}
+}
+
+func TestDecommissionRestoreLink(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/users/") {
+			w.Write([]byte(`{"First": "Bob", "Email": "bob@example.com"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	withTestConfig(t, common.Config{
+		BaseURL:          "https://reaper.example.com/v1/reaper",
+		EncryptionSecret: "sekret",
+		Notify:           common.Notifier{Age: []string{"23d"}},
+		Decommission:     common.Decommissioner{Age: "30d", Endpoint: server.URL + "/v1/servers"},
+		Destroy:          common.Destroyer{Age: "44d"},
+		Email:            common.Emailer{Transport: "capture", From: "spinup@example.com"},
+		UserDatasource:   map[string]string{"type": "rest", "endpoint": server.URL + "/users", "token": "sekret"},
+	})
+
+	origOutbox := Outbox
+	Outbox = NewCaptureTransport(10)
+	defer func() { Outbox = origOutbox }()
+	withTestTransport(t, AppConfig.Email)
+
+	renewedAt := time.Now().Add(-35 * 24 * time.Hour).Format("2006/01/02 15:04:05")
+	source := search.NewMemorySource([]*search.Document{
+		{ID: "i-expired", Source: map[string]interface{}{"yale:org": "fts", "status": "created", "yale:renewed_at": renewedAt, "yale:fqdn": "expired.yale.edu", "SupportDepartmentContact": "abc123"}},
+	})
+
+	for _, policy := range policies() {
+		decommission(source, policy)
+	}
+
+	messages := Outbox.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("Expected the decom email, got %d messages", len(messages))
+	}
+
+	m := messages[0]
+	if m.Subject != "Your Spinup TryIT server has been stopped" || !strings.Contains(m.HTML, "It can still be restored until") {
+		t.Fatalf("Expected the restore message, got %s: %s", m.Subject, m.HTML)
+	}
+
+	start := strings.Index(m.HTML, "https://reaper.example.com/v1/reaper/renew/i-expired?token=")
+	if start < 0 {
+		t.Fatalf("Expected a renewal link, got %s", m.HTML)
+	}
+
+	link, err := url.Parse(m.HTML[start : start+strings.Index(m.HTML[start:], `"`)])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the token is valid for the unchanged renewed_at date until the resource is destroyed
+	secret := &RenewalSecret{ResourceID: "i-expired", Action: RenewAction, RenewedAt: renewedAt, Secrets: []string{"sekret"}}
+	claims, err := secret.validate(link.Query().Get("token"))
+	if err != nil {
+		t.Fatalf("Expected a valid renewal token, got %s", err)
+	}
+
+	destroyAt, _ := time.Parse("2006/01/02 15:04:05", renewedAt)
+	if expiresAt := time.Unix(claims.ExpiresAt, 0); expiresAt.Sub(destroyAt.Add(44*24*time.Hour)).Abs() > time.Minute {
+		t.Errorf("Expected the token to expire at the destroy date, got %s", expiresAt)
+	}
+}
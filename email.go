@@ -4,9 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
-	"net/smtp"
-	"strings"
+	"net/mail"
 
+	"github.com/YaleSpinup/reaper/common"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -19,10 +19,18 @@ var warningTemplate = `
       Hello {{if .FirstName}}{{ .FirstName }}{{else}}{{ .NetID }}{{end}},
     </p>
     <p>
-      Your Spinup TryIT server {{.FQDN}} will expire on {{.ExpireOn}}. If you would like to keep it, please renew it from the Spinup interface or by clicking the following link (this e-mail's link is one-time use):
+      Your Spinup TryIT server {{.FQDN}} will expire on {{.ExpireOn}}.
+      {{- if .RenewalLimit}} {{.RenewalLimit}} Please back up any data you would like to keep before it expires.
+      {{- else}} If you would like to keep it, please renew it from the Spinup interface or by clicking the following link (this e-mail's link is one-time use):
       <br />
       <br />
       <a href="{{.RenewalLink}}">{{.RenewalLink}}</a>
+      {{- if .RemainingRenewals}}
+      <br />
+      <br />
+      It can be renewed {{.RemainingRenewals}} more time(s).
+      {{- end}}
+      {{- end}}
     </p>
     <p>
       Cheers,<br />
@@ -43,7 +51,16 @@ var decomTemplate = `
       Hello {{if .FirstName}}{{ .FirstName }}{{else}}{{ .NetID }}{{end}},
     </p>
     <p>
-      Your Spinup TryIT server {{.FQDN}} expired on {{.ExpireOn}} and has been deleted.  Thank you for using Spinup TryIT!
+      Your Spinup TryIT server {{.FQDN}} expired on {{.ExpireOn}} and has been stopped.
+      {{- if .RenewalLink}} It can still be restored until {{.DestroyOn}} by renewing it from the Spinup interface or by clicking the following link (this e-mail's link is one-time use):
+      <br />
+      <br />
+      <a href="{{.RenewalLink}}">{{.RenewalLink}}</a>
+      <br />
+      <br />
+      After that it will be deleted.
+      {{- else}} It will be deleted on {{.DestroyOn}}.
+      {{- end}}  Thank you for using Spinup TryIT!
     </p>
     <p>
       Cheers,<br />
@@ -76,59 +93,181 @@ var renewalTemplate = `
 </html>
 `
 
-// SendMail sends an email with plain auth
-func SendMail(address, body, from, password, subject, to, username string) error {
-	if len(strings.Split(address, ":")) != 2 {
-		return fmt.Errorf("The given mail server value (%s) seems invalid, it should be the form foo.bar.com:25", address)
-	}
+var restoredTemplate = `
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">
+<html>
+  <head></head>
+    <body>
+      <p>
+        Hello {{if .FirstName}}{{ .FirstName }}{{else}}{{ .NetID }}{{end}},
+      </p>
+      <p>
+        Your Spinup TryIT server {{.FQDN}} has been restored and renewed, it will expire on {{.ExpireOn}}.  It may take a few minutes before it's available again.  Thank you for using Spinup TryIT!
+      </p>
+      <p>
+        Cheers,<br />
+				Spinup Team<br />
+				<a href="{{.SpinupURL}}">{{.SpinupURL}}</a><br />
+				<a href="{{.SpinupSiteURL}}">{{.SpinupSiteURL}}</a>
+      </p>
+    </body>
+</html>
+`
+
+var digestTemplate = `
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">
+<html>
+  <head></head>
+  <body>
+    <p>
+      Hello {{if .FirstName}}{{ .FirstName }}{{else}}{{ .NetID }}{{end}},
+    </p>
+    <p>
+      The following Spinup TryIT servers will expire soon.  If you would like to keep them, please renew them from the Spinup interface or by clicking their links (this e-mail's links are one-time use).  Please back up any data you would like to keep from the servers that can't be renewed before they expire.
+    </p>
+    <ul>
+      {{- range .Items}}
+      <li>
+        {{.Resource.FQDN}} will expire on {{.ExpireOn}}.
+        {{- if .RenewalLimit}} {{.RenewalLimit}}
+        {{- else}}
+        {{- if .RemainingRenewals}} It can be renewed {{.RemainingRenewals}} more time(s).{{end}}
+        <br />
+        <a href="{{.Link}}">{{.Link}}</a>
+        {{- end}}
+      </li>
+      {{- end}}
+    </ul>
+    <p>
+      Cheers,<br />
+			Spinup Team<br />
+			<a href="{{.SpinupURL}}">{{.SpinupURL}}</a><br />
+			<a href="{{.SpinupSiteURL}}">{{.SpinupSiteURL}}</a>
+    </p>
+  </body>
+</html>
+`
 
-	if len(strings.Split(from, "@")) != 2 {
-		return fmt.Errorf("The given email from address (%s) seems invalid, it should be the form foo@bar.com", from)
+// SendMail sends an html email to a single recipient with the configured MailTransport.  The body is sent as a MIME
+// message along with a plain text alternative.
+func SendMail(config common.Emailer, to, subject, body string) error {
+	sender, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return fmt.Errorf("The given email from address (%s) seems invalid, it should be the form foo@bar.com", config.From)
 	}
 
-	// message := fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s\r\n", to, subject, body)
-	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-	message := "From: " + from + "\nTo: " + to + "\nSubject: " + subject + "\n" + mime + "\n" + body
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("The given email recipient (%s) seems invalid: %s", to, err)
+	}
 
-	log.Debugf("Sending mail to %s from %s via %s with body\n%s", to, from, address, message)
+	t := MailTransport
+	if t == nil {
+		return fmt.Errorf("no email transport is configured")
+	}
 
-	var auth smtp.Auth
-	if username == "" || password == "" {
-		log.Info("Using nil auth for SMTP")
-		auth = nil
-	} else {
-		server := strings.Split(address, ":")
-		auth = smtp.PlainAuth("", username, password, server[0])
+	message, err := NewMessage(config.From, to, subject, body).Bytes()
+	if err != nil {
+		return err
 	}
 
-	// Note that I'm being lazy here and only allowing one recipient #quickwins
-	return smtp.SendMail(address, auth, from, []string{to}, []byte(message))
+	log.Debugf("Sending mail to %s from %s with the %s transport and body\n%s", rcpt.Address, sender.Address, t.Name(), message)
+	return t.Send(sender.Address, rcpt.Address, message)
 }
 
-// ParseWarningTemplate takes a map of parameters and parses the warning template, returning the parsed string
-func ParseWarningTemplate(params map[string]string) (string, error) {
+// ParseWarningTemplate takes a map of parameters and parses the warning template, returning the parsed string.
+// The resource tags are available in the template as .Tags, ie. {{index .Tags "yale:project"}}
+func ParseWarningTemplate(params, tags map[string]string) (string, error) {
 	tmpl, err := template.New("warningTemplate").Parse(warningTemplate)
 	if err != nil {
 		return "", err
 	}
 
+	buffer := new(bytes.Buffer)
+	err = tmpl.Execute(buffer, struct {
+		ExpireOn          string
+		FirstName         string
+		NetID             string
+		FQDN              string
+		RenewalLink       string
+		RemainingRenewals string
+		RenewalLimit      string
+		SpinupURL         string
+		SpinupSiteURL     string
+		Tags              map[string]string
+	}{
+		ExpireOn:          params["expire_on"],
+		FirstName:         params["first"],
+		NetID:             params["netid"],
+		FQDN:              params["fqdn"],
+		RenewalLink:       params["link"],
+		RemainingRenewals: params["remaining_renewals"],
+		RenewalLimit:      params["renewal_limit"],
+		SpinupURL:         params["spinupURL"],
+		SpinupSiteURL:     params["spinupSiteURL"],
+		Tags:              tags,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
+
+// ParseDigestTemplate takes a map of parameters and the resources due for a warning and parses the digest template,
+// returning the parsed string.  Each resource is available in the template as an item of .Items.
+func ParseDigestTemplate(params map[string]string, items []*NotificationItem) (string, error) {
+	tmpl, err := template.New("digestTemplate").Parse(digestTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	buffer := new(bytes.Buffer)
+	err = tmpl.Execute(buffer, struct {
+		FirstName     string
+		NetID         string
+		Items         []*NotificationItem
+		SpinupURL     string
+		SpinupSiteURL string
+	}{
+		FirstName:     params["first"],
+		NetID:         params["netid"],
+		Items:         items,
+		SpinupURL:     params["spinupURL"],
+		SpinupSiteURL: params["spinupSiteURL"],
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
+
+// ParseRenewalTemplate takes a map of parameters and parses the renewal template, returning the parsed string.
+// The resource tags are available in the template as .Tags
+func ParseRenewalTemplate(params, tags map[string]string) (string, error) {
+	tmpl, err := template.New("renewalTemplate").Parse(renewalTemplate)
+	if err != nil {
+		return "", err
+	}
+
 	buffer := new(bytes.Buffer)
 	err = tmpl.Execute(buffer, struct {
 		ExpireOn      string
 		FirstName     string
 		NetID         string
 		FQDN          string
-		RenewalLink   string
 		SpinupURL     string
 		SpinupSiteURL string
+		Tags          map[string]string
 	}{
 		ExpireOn:      params["expire_on"],
 		FirstName:     params["first"],
 		NetID:         params["netid"],
 		FQDN:          params["fqdn"],
-		RenewalLink:   params["link"],
 		SpinupURL:     params["spinupURL"],
 		SpinupSiteURL: params["spinupSiteURL"],
+		Tags:          tags,
 	})
 	if err != nil {
 		return "", err
@@ -137,9 +276,10 @@ func ParseWarningTemplate(params map[string]string) (string, error) {
 	return buffer.String(), nil
 }
 
-// ParseRenewalTemplate takes a map of parameters and parses the renewal template, returning the parsed string
-func ParseRenewalTemplate(params map[string]string) (string, error) {
-	tmpl, err := template.New("renewalTemplate").Parse(renewalTemplate)
+// ParseDecomTemplate takes a map of parameters and parses the decom template, returning the parsed string.
+// The resource tags are available in the template as .Tags
+func ParseDecomTemplate(params, tags map[string]string) (string, error) {
+	tmpl, err := template.New("decomTemplate").Parse(decomTemplate)
 	if err != nil {
 		return "", err
 	}
@@ -147,18 +287,24 @@ func ParseRenewalTemplate(params map[string]string) (string, error) {
 	buffer := new(bytes.Buffer)
 	err = tmpl.Execute(buffer, struct {
 		ExpireOn      string
+		DestroyOn     string
 		FirstName     string
 		NetID         string
 		FQDN          string
+		RenewalLink   string
 		SpinupURL     string
 		SpinupSiteURL string
+		Tags          map[string]string
 	}{
 		ExpireOn:      params["expire_on"],
+		DestroyOn:     params["destroy_on"],
 		FirstName:     params["first"],
 		NetID:         params["netid"],
 		FQDN:          params["fqdn"],
+		RenewalLink:   params["link"],
 		SpinupURL:     params["spinupURL"],
 		SpinupSiteURL: params["spinupSiteURL"],
+		Tags:          tags,
 	})
 	if err != nil {
 		return "", err
@@ -167,9 +313,10 @@ func ParseRenewalTemplate(params map[string]string) (string, error) {
 	return buffer.String(), nil
 }
 
-// ParseDecomTemplate takes a map of parameters and parses the decom template, returning the parsed string
-func ParseDecomTemplate(params map[string]string) (string, error) {
-	tmpl, err := template.New("decomTemplate").Parse(decomTemplate)
+// ParseRestoredTemplate takes a map of parameters and parses the restored template, returning the parsed string.
+// The resource tags are available in the template as .Tags, ie. {{index .Tags "yale:project"}}
+func ParseRestoredTemplate(params, tags map[string]string) (string, error) {
+	tmpl, err := template.New("restoredTemplate").Parse(restoredTemplate)
 	if err != nil {
 		return "", err
 	}
@@ -182,6 +329,7 @@ func ParseDecomTemplate(params map[string]string) (string, error) {
 		FQDN          string
 		SpinupURL     string
 		SpinupSiteURL string
+		Tags          map[string]string
 	}{
 		ExpireOn:      params["expire_on"],
 		FirstName:     params["first"],
@@ -189,6 +337,7 @@ func ParseDecomTemplate(params map[string]string) (string, error) {
 		FQDN:          params["fqdn"],
 		SpinupURL:     params["spinupURL"],
 		SpinupSiteURL: params["spinupSiteURL"],
+		Tags:          tags,
 	})
 	if err != nil {
 		return "", err
@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 var testEamilParams = map[string]string{
 	"first":         "bob",
@@ -12,8 +15,13 @@ var testEamilParams = map[string]string{
 	"spinupSiteURL": "http://127.0.0.1:8888/spinup",
 }
 
+var testEmailTags = map[string]string{
+	"instance_type": "t3.small",
+	"yale:project":  "moonshot",
+}
+
 func TestParseWarningTemplate(t *testing.T) {
-	out, err := ParseWarningTemplate(testEamilParams)
+	out, err := ParseWarningTemplate(testEamilParams, testEmailTags)
 	if err != nil {
 		t.Error("Failed to parse warning temapte", err)
 	}
@@ -21,8 +29,34 @@ func TestParseWarningTemplate(t *testing.T) {
 	t.Logf("Got parsed warning template: %s\n", out)
 }
 
+func TestParseWarningTemplateRenewalLimits(t *testing.T) {
+	params := map[string]string{"remaining_renewals": "2"}
+	for k, v := range testEamilParams {
+		params[k] = v
+	}
+
+	out, err := ParseWarningTemplate(params, testEmailTags)
+	if err != nil {
+		t.Fatal("Failed to parse warning template", err)
+	}
+
+	if !strings.Contains(out, "renewed 2 more time(s)") || !strings.Contains(out, params["link"]) {
+		t.Errorf("Expected the remaining renewals and the renewal link, got %s", out)
+	}
+
+	params["renewal_limit"] = "It has reached the maximum lifetime allowed."
+	out, err = ParseWarningTemplate(params, testEmailTags)
+	if err != nil {
+		t.Fatal("Failed to parse warning template", err)
+	}
+
+	if !strings.Contains(out, params["renewal_limit"]) || strings.Contains(out, params["link"]) {
+		t.Errorf("Expected the renewal limit without the renewal link, got %s", out)
+	}
+}
+
 func TestParseRenewalTemplate(t *testing.T) {
-	out, err := ParseRenewalTemplate(testEamilParams)
+	out, err := ParseRenewalTemplate(testEamilParams, testEmailTags)
 	if err != nil {
 		t.Error("Failed to parse warning temapte", err)
 	}
@@ -31,10 +65,51 @@ func TestParseRenewalTemplate(t *testing.T) {
 }
 
 func TestParseDecomTemplate(t *testing.T) {
-	out, err := ParseDecomTemplate(testEamilParams)
+	params := map[string]string{"destroy_on": "2018/01/28 15:32:24"}
+	for k, v := range testEamilParams {
+		params[k] = v
+	}
+
+	out, err := ParseDecomTemplate(params, testEmailTags)
 	if err != nil {
-		t.Error("Failed to parse warning temapte", err)
+		t.Fatal("Failed to parse decom template", err)
 	}
 
-	t.Logf("Got parsed warning template: %s\n", out)
+	if !strings.Contains(out, "has been stopped") || !strings.Contains(out, "restored until 2018/01/28 15:32:24") || !strings.Contains(out, params["link"]) {
+		t.Errorf("Expected the restore link and destroy date, got %s", out)
+	}
+
+	delete(params, "link")
+	out, err = ParseDecomTemplate(params, testEmailTags)
+	if err != nil {
+		t.Fatal("Failed to parse decom template", err)
+	}
+
+	if strings.Contains(out, "restored") || !strings.Contains(out, "It will be deleted on 2018/01/28 15:32:24") {
+		t.Errorf("Expected the destroy date without a restore link, got %s", out)
+	}
+}
+
+func TestParseRestoredTemplate(t *testing.T) {
+	out, err := ParseRestoredTemplate(testEamilParams, testEmailTags)
+	if err != nil {
+		t.Error("Failed to parse restored template", err)
+	}
+
+	if !strings.Contains(out, "foo.bar.yale.edu has been restored") {
+		t.Errorf("Expected the restored message, got %s", out)
+	}
+}
+
+func TestParseDigestTemplate(t *testing.T) {
+	out, err := ParseDigestTemplate(testEamilParams, testDigest.Items)
+	if err != nil {
+		t.Fatal("Failed to parse digest template", err)
+	}
+
+	for _, s := range []string{"foo.bar.yale.edu will expire on 2019/01/31 00:00:00 EST", testDigest.Items[0].Link, "baz.bar.yale.edu will expire on 2019/02/01 00:00:00 EST", testDigest.Items[1].RenewalLimit} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Expected the digest to contain %s, got %s", s, out)
+		}
+	}
 }
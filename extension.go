@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+)
+
+// RenewalExtensionTag is the tag holding the extension chosen when the resource was last renewed
+const RenewalExtensionTag = "yale:renewal_extension"
+
+// maxExtension returns the longest extension allowed by the policy, the decommission age if it's not configured
+func maxExtension(policy common.Policy) (time.Duration, error) {
+	if policy.MaxExtension == "" {
+		return parseDuration(policy.DecommissionAge)
+	}
+	return parseDuration(policy.MaxExtension)
+}
+
+// extensionOptions returns the extensions offered by the policy, shortest first.  The full decommission age is
+// always offered unless it's longer than the maximum extension, in which case the maximum extension is offered.
+func extensionOptions(policy common.Policy) ([]string, error) {
+	max, err := maxExtension(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	decomAge, err := parseDuration(policy.DecommissionAge)
+	if err != nil {
+		return nil, err
+	}
+
+	full := policy.DecommissionAge
+	if decomAge > max {
+		full = policy.MaxExtension
+	}
+
+	seen := map[time.Duration]bool{}
+	options := []string{}
+	for _, e := range append([]string{full}, policy.Extensions...) {
+		d, err := parseDuration(e)
+		if err != nil {
+			return nil, err
+		}
+
+		if d <= 0 || d > max || seen[d] {
+			continue
+		}
+		seen[d] = true
+		options = append(options, e)
+	}
+
+	if len(options) == 0 {
+		return nil, fmt.Errorf("no valid extensions for policy %s", policy.Name)
+	}
+
+	sort.Sort(BySchedule(options))
+	return options, nil
+}
+
+// requestedExtension validates an extension requested for a renewal against the policy.  Extensions longer than
+// the maximum are capped and extensions shorter than the shortest option are refused.  The returned extension is
+// the value to tag, it's empty if the resource gets the full decommission age.
+func requestedExtension(policy common.Policy, requested string) (string, error) {
+	options, err := extensionOptions(policy)
+	if err != nil {
+		return "", err
+	}
+
+	if requested == "" {
+		requested = policy.DecommissionAge
+	}
+
+	d, err := parseDuration(requested)
+	if err != nil {
+		return "", fmt.Errorf("invalid extension %s: %s", requested, err)
+	}
+
+	min, _ := parseDuration(options[0])
+	if d < min {
+		return "", fmt.Errorf("extension %s is shorter than the minimum extension %s", requested, options[0])
+	}
+
+	max, err := maxExtension(policy)
+	if err != nil {
+		return "", err
+	}
+
+	if d > max {
+		requested, d = policy.MaxExtension, max
+	}
+
+	if decomAge, err := parseDuration(policy.DecommissionAge); err == nil && d == decomAge {
+		return "", nil
+	}
+
+	return requested, nil
+}
+
+// extensionOffset returns how far the lifecycle of the resource is shifted by the extension it was renewed with
+func extensionOffset(resource *search.Resource, policy common.Policy) (time.Duration, error) {
+	extension := resource.Tag(RenewalExtensionTag)
+	if extension == "" {
+		return 0, nil
+	}
+
+	e, err := parseDuration(extension)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't parse %s (%s) as a duration: %s", RenewalExtensionTag, extension, err)
+	}
+
+	decomAge, err := parseDuration(policy.DecommissionAge)
+	if err != nil {
+		return 0, err
+	}
+
+	return e - decomAge, nil
+}
+
+// lifecycleStart returns the time the lifecycle of the resource started.  That's the renewed_at date, shifted by the
+// difference between the extension it was renewed with and the decommission age, so that all of the ages are honored
+// relative to the chosen extension.
+func lifecycleStart(resource *search.Resource, policy common.Policy) (time.Time, error) {
+	renewedAt, err := time.Parse("2006/01/02 15:04:05", resource.RenewedAt)
+	if err != nil {
+		return renewedAt, fmt.Errorf("couldn't parse renewed_at (%s) as a time value: %s", resource.RenewedAt, err)
+	}
+
+	offset, err := extensionOffset(resource, policy)
+	if err != nil {
+		return renewedAt, err
+	}
+
+	return renewedAt.Add(offset), nil
+}
+
+// resourceDecomAt returns the decommission date of the resource, honoring its renewal extension
+func resourceDecomAt(resource *search.Resource, policy common.Policy) (time.Time, error) {
+	start, err := lifecycleStart(resource, policy)
+	if err != nil {
+		return start, err
+	}
+
+	decomAge, err := parseDuration(policy.DecommissionAge)
+	if err != nil {
+		return start, err
+	}
+
+	return start.Add(decomAge), nil
+}
+
+// resourceDestroyAt returns the destroy date of the resource, honoring its renewal extension
+func resourceDestroyAt(resource *search.Resource, policy common.Policy) (time.Time, error) {
+	start, err := lifecycleStart(resource, policy)
+	if err != nil {
+		return start, err
+	}
+
+	destroyAge, err := parseDuration(policy.DestroyAge)
+	if err != nil {
+		return start, err
+	}
+
+	return start.Add(destroyAge), nil
+}
+
+// crossedAge returns true if the resource has crossed the age threshold, honoring its renewal extension
+func crossedAge(resource *search.Resource, policy common.Policy, age string, now time.Time) (bool, error) {
+	start, err := lifecycleStart(resource, policy)
+	if err != nil {
+		return false, err
+	}
+
+	d, err := parseDuration(age)
+	if err != nil {
+		return false, err
+	}
+
+	return !start.Add(d).After(now), nil
+}
+
+// extensionWindow returns how much earlier resources renewed with the shortest extension offered by the policy
+// cross each age threshold.  The lifecycle queries are widened by the window and the results are checked with
+// crossedAge.
+func extensionWindow(policy common.Policy) (time.Duration, error) {
+	options, err := extensionOptions(policy)
+	if err != nil {
+		return 0, err
+	}
+
+	min, _ := parseDuration(options[0])
+	decomAge, err := parseDuration(policy.DecommissionAge)
+	if err != nil {
+		return 0, err
+	}
+
+	if min >= decomAge {
+		return 0, nil
+	}
+	return decomAge - min, nil
+}
+
+// renewedResource returns a copy of the resource as it will be after being renewed at renewedAt with the extension
+func renewedResource(resource *search.Resource, renewedAt, extension string) *search.Resource {
+	renewed := *resource
+	renewed.RenewedAt = renewedAt
+	renewed.Tags = map[string]string{}
+	for k, v := range resource.Tags {
+		renewed.Tags[k] = v
+	}
+	renewed.Tags[RenewalExtensionTag] = extension
+	return &renewed
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+)
+
+var testExtensionPolicy = common.Policy{
+	Name:            "tryit",
+	NotifyAge:       []string{"23d", "29d"},
+	DecommissionAge: "30d",
+	DestroyAge:      "44d",
+	Extensions:      []string{"14d", "7d", "90d"},
+	MaxExtension:    "60d",
+}
+
+func TestExtensionOptions(t *testing.T) {
+	options, err := extensionOptions(testExtensionPolicy)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if expected := []string{"7d", "14d", "30d"}; !reflect.DeepEqual(options, expected) {
+		t.Errorf("Expected options %v, got %v", expected, options)
+	}
+
+	// the full period is capped by the max extension
+	policy := testExtensionPolicy
+	policy.MaxExtension = "10d"
+	options, err = extensionOptions(policy)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if expected := []string{"7d", "10d"}; !reflect.DeepEqual(options, expected) {
+		t.Errorf("Expected options %v, got %v", expected, options)
+	}
+
+	// without extensions, only the full period is offered
+	options, err = extensionOptions(common.Policy{DecommissionAge: "30d"})
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if expected := []string{"30d"}; !reflect.DeepEqual(options, expected) {
+		t.Errorf("Expected options %v, got %v", expected, options)
+	}
+}
+
+func TestRequestedExtension(t *testing.T) {
+	tests := []struct {
+		requested string
+		expected  string
+		err       bool
+	}{
+		{"", "", false},
+		{"30d", "", false},
+		{"7d", "7d", false},
+		{"10d", "10d", false},
+		{"45d", "45d", false},
+		{"120d", "60d", false},
+		{"3d", "", true},
+		{"a week", "", true},
+	}
+
+	for _, test := range tests {
+		extension, err := requestedExtension(testExtensionPolicy, test.requested)
+		if test.err != (err != nil) {
+			t.Errorf("Expected error %t for %s, got %v", test.err, test.requested, err)
+		}
+
+		if extension != test.expected {
+			t.Errorf("Expected extension %s for %s, got %s", test.expected, test.requested, extension)
+		}
+	}
+}
+
+func TestLifecycleStart(t *testing.T) {
+	resource := &search.Resource{RenewedAt: "2019/01/01 00:00:00"}
+	start, err := lifecycleStart(resource, testExtensionPolicy)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if !start.Equal(time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the lifecycle to start at renewed_at without an extension, got %s", start)
+	}
+
+	resource.Tags = map[string]string{RenewalExtensionTag: "7d"}
+	decomAt, err := resourceDecomAt(resource, testExtensionPolicy)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if !decomAt.Equal(time.Date(2019, time.January, 8, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected decommission 7 days after renewal, got %s", decomAt)
+	}
+
+	crossed, err := crossedAge(resource, testExtensionPolicy, "29d", time.Date(2019, time.January, 7, 0, 0, 0, 0, time.UTC))
+	if err != nil || !crossed {
+		t.Errorf("Expected the last notification age to be crossed a day before decommission, got %t (%v)", crossed, err)
+	}
+
+	resource.Tags[RenewalExtensionTag] = "45d"
+	decomAt, err = resourceDecomAt(resource, testExtensionPolicy)
+	if err != nil || !decomAt.Equal(time.Date(2019, time.February, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected decommission 45 days after renewal, got %s (%v)", decomAt, err)
+	}
+
+	resource.Tags[RenewalExtensionTag] = "a week"
+	if _, err := lifecycleStart(resource, testExtensionPolicy); err == nil {
+		t.Error("Expected error for bad extension tag, got nil")
+	}
+}
+
+func TestLifecycleQueryExtensionWindow(t *testing.T) {
+	withTestConfig(t, common.Config{})
+
+	drq, err := decommissionQuery(common.Policy{DecommissionAge: "30d", DestroyAge: "44d"})
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if drq.Lte != "now-30d" {
+		t.Errorf("Expected unwidened query without extensions, got %s", drq.Lte)
+	}
+
+	drq, err = decommissionQuery(testExtensionPolicy)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if drq.Lte != "now-604800s" {
+		t.Errorf("Expected query widened to 7 days, got %s", drq.Lte)
+	}
+
+	_, drq, err = notifyQuery(common.Policy{NotifyAge: []string{"5d"}, DecommissionAge: "30d", Extensions: []string{"1d"}})
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if drq.Lte != "now+2073600s" {
+		t.Errorf("Expected query widened into the future, got %s", drq.Lte)
+	}
+}
+
+func TestRenewedResource(t *testing.T) {
+	resource := &search.Resource{ID: "i-123", RenewedAt: "2019/01/01 00:00:00", Tags: map[string]string{"foo": "bar"}}
+	renewed := renewedResource(resource, "2019/02/01 00:00:00", "7d")
+
+	if renewed.RenewedAt != "2019/02/01 00:00:00" || renewed.Tag(RenewalExtensionTag) != "7d" || renewed.Tag("foo") != "bar" {
+		t.Errorf("Unexpected renewed resource %+v", renewed)
+	}
+
+	if resource.RenewedAt != "2019/01/01 00:00:00" || resource.Tag(RenewalExtensionTag) != "" {
+		t.Errorf("Expected the original resource not to be modified, got %+v", resource)
+	}
+}
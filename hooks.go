@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/YaleSpinup/reaper/common"
@@ -16,8 +17,10 @@ import (
 
 // Event is the data for an event
 type Event struct {
-	Action string `json:"action"`
-	ID     string `json:"id"`
+	Action string            `json:"action"`
+	ID     string            `json:"id"`
+	Policy string            `json:"policy,omitempty"`
+	Tags   map[string]string `json:"tags,omitempty"`
 }
 
 // Webhook is the configuration for a webhook
@@ -44,14 +47,21 @@ func NewWebhook(wh common.Webhook) (Webhook, error) {
 
 // Send sends a webhook. If the hook is configured as a GET, or HEAD, the hook
 // data will be sent as URL parameters.  If the hook is configured as a POST, PUT or PATCH, the data
-// will be JSON encoded and sent in the body.
+// will be JSON encoded and sent in the body.  The resource tags are only sent in the body.
 func (wh Webhook) Send(ctx context.Context, event *Event) error {
 	log.Infof("sending webhook event %+v with %+v", event, wh)
 
 	switch wh.Method {
 	case http.MethodGet, http.MethodHead, http.MethodDelete, http.MethodOptions:
-		url := fmt.Sprintf("%s?id=%s&action=%s", wh.Endpoint, event.ID, event.Action)
-		req, err := http.NewRequestWithContext(ctx, wh.Method, url, nil)
+		params := url.Values{}
+		params.Set("id", event.ID)
+		params.Set("action", event.Action)
+		if event.Policy != "" {
+			params.Set("policy", event.Policy)
+		}
+		hookURL := wh.Endpoint + "?" + params.Encode()
+
+		req, err := http.NewRequestWithContext(ctx, wh.Method, hookURL, nil)
 		if err != nil {
 			return err
 		}
@@ -71,7 +81,7 @@ func (wh Webhook) Send(ctx context.Context, event *Event) error {
 
 		if res.StatusCode > 299 {
 			resBody, _ := ioutil.ReadAll(res.Body)
-			msg := fmt.Sprintf("Received non-success from webhook (%s) %s(%d %s", url, res.Status, res.StatusCode, resBody)
+			msg := fmt.Sprintf("Received non-success from webhook (%s) %s(%d %s", hookURL, res.Status, res.StatusCode, resBody)
 			return errors.New(msg)
 		}
 	case http.MethodPost, http.MethodPut, http.MethodPatch:
@@ -52,6 +52,7 @@ func TestSendWebhook(t *testing.T) {
 			q := r.URL.Query()
 			event.ID = q.Get("id")
 			event.Action = q.Get("action")
+			event.Policy = q.Get("policy")
 		case http.MethodPost, http.MethodPut, http.MethodPatch:
 			err := json.NewDecoder(r.Body).Decode(&event)
 			if err != nil {
@@ -79,6 +80,12 @@ func TestSendWebhook(t *testing.T) {
 			return
 		}
 
+		if event.Policy != "tryit" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("bad policy: " + event.Policy + ", expected 'tryit'"))
+			return
+		}
+
 	}))
 	defer server.Close()
 
@@ -97,6 +104,7 @@ func TestSendWebhook(t *testing.T) {
 	if err := wh.Send(context.TODO(), &Event{
 		Action: "dance",
 		ID:     "i-123456789",
+		Policy: "tryit",
 	}); err != nil {
 		t.Errorf("expected error for bad method, got nil")
 	}
@@ -107,6 +115,7 @@ func TestSendWebhook(t *testing.T) {
 		if err := wh.Send(context.TODO(), &Event{
 			Action: "party",
 			ID:     "i-123456789",
+			Policy: "tryit",
 		}); err != nil {
 			t.Errorf("expected nil error for valid hook, got %s", err)
 		}
@@ -118,6 +127,7 @@ func TestSendWebhook(t *testing.T) {
 		if err := wh.Send(context.TODO(), &Event{
 			Action: "party",
 			ID:     "i-123456789",
+			Policy: "tryit",
 		}); err != nil {
 			t.Errorf("expected nil error for valid hook, got %s", err)
 		}
@@ -134,3 +144,30 @@ func TestSendWebhook(t *testing.T) {
 		}
 	}
 }
+
+func TestSendWebhookEscapesQuery(t *testing.T) {
+	var query map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+	}))
+	defer server.Close()
+
+	wh := Webhook{
+		Endpoint: server.URL,
+		Method:   http.MethodGet,
+		Client:   server.Client(),
+	}
+
+	if err := wh.Send(context.TODO(), &Event{
+		Action: "notify",
+		ID:     "i-123456789",
+		Policy: "try it & keep=#1",
+	}); err != nil {
+		t.Fatalf("expected nil error for valid hook, got %s", err)
+	}
+
+	expected := map[string][]string{"id": {"i-123456789"}, "action": {"notify"}, "policy": {"try it & keep=#1"}}
+	if !reflect.DeepEqual(expected, query) {
+		t.Errorf("expected the hook query %+v, got %+v", expected, query)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+	log "github.com/sirupsen/logrus"
+)
+
+// RenewalCountTag is the tag counting how many times the resource has been renewed
+const RenewalCountTag = "yale:renewal_count"
+
+var (
+	// ErrRenewalLimitReached is returned when a resource has been renewed the maximum number of times
+	ErrRenewalLimitReached = errors.New("resource has reached the maximum number of renewals")
+
+	// ErrLifetimeReached is returned when a resource has reached the maximum lifetime since it was created
+	ErrLifetimeReached = errors.New("resource has reached its maximum lifetime")
+)
+
+// renewalCount returns how many times the resource has been renewed
+func renewalCount(resource *search.Resource) (int, error) {
+	count := resource.Tag(RenewalCountTag)
+	if count == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't parse %s (%s) as a number: %s", RenewalCountTag, count, err)
+	}
+	return n, nil
+}
+
+// remainingRenewals returns how many more times the resource can be renewed, or -1 if the renewals aren't limited.
+// The renewal count is checked even if the renewals aren't limited, since renewing the resource increments it.
+func remainingRenewals(resource *search.Resource, policy common.Policy) (int, error) {
+	count, err := renewalCount(resource)
+	if err != nil {
+		return 0, err
+	}
+
+	if policy.MaxRenewals <= 0 {
+		return -1, nil
+	}
+
+	if count >= policy.MaxRenewals {
+		return 0, nil
+	}
+	return policy.MaxRenewals - count, nil
+}
+
+// lifetimeEnd returns the time the resource reaches the maximum lifetime of the policy.  False is returned if the
+// lifetime isn't limited or the creation time of the resource isn't known.
+func lifetimeEnd(resource *search.Resource, policy common.Policy) (time.Time, bool, error) {
+	if policy.MaxLifetime == "" {
+		return time.Time{}, false, nil
+	}
+
+	if resource.CreatedAt == "" {
+		log.Warnf("%s doesn't have a creation time, not limiting its lifetime", resource.ID)
+		return time.Time{}, false, nil
+	}
+
+	createdAt, err := time.Parse("2006/01/02 15:04:05", resource.CreatedAt)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("couldn't parse created_at (%s) as a time value: %s", resource.CreatedAt, err)
+	}
+
+	lifetime, err := parseDuration(policy.MaxLifetime)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	return createdAt.Add(lifetime), true, nil
+}
+
+// renewalPolicy checks the renewal limits of the policy for the resource and returns the policy the renewal is
+// bound by.  ErrRenewalLimitReached or ErrLifetimeReached is returned if the resource can't be renewed.  When the
+// lifetime is limited, the maximum extension is shortened to the time left so the resource doesn't outlive it.
+func renewalPolicy(resource *search.Resource, policy common.Policy, now time.Time) (common.Policy, error) {
+	remaining, err := remainingRenewals(resource, policy)
+	if err != nil {
+		return policy, err
+	}
+
+	if remaining == 0 {
+		return policy, ErrRenewalLimitReached
+	}
+
+	end, limited, err := lifetimeEnd(resource, policy)
+	if err != nil || !limited {
+		return policy, err
+	}
+
+	left := end.Sub(now).Truncate(time.Second)
+	if left <= 0 {
+		return policy, ErrLifetimeReached
+	}
+
+	max, err := maxExtension(policy)
+	if err != nil {
+		return policy, err
+	}
+
+	// the time left is kept in days when it's a whole number of them, since it's shown to the owner
+	if left < max {
+		policy.MaxExtension = left.String()
+		if left%(24*time.Hour) == 0 {
+			policy.MaxExtension = fmt.Sprintf("%dd", left/(24*time.Hour))
+		}
+	}
+
+	// the lifetime may not leave room for any of the configured extensions
+	if _, err := extensionOptions(policy); err != nil {
+		return policy, ErrLifetimeReached
+	}
+
+	return policy, nil
+}
+
+// renewalLimitMessage returns a message for the user explaining why their resource can't be renewed
+func renewalLimitMessage(err error) string {
+	switch {
+	case errors.Is(err, ErrRenewalLimitReached):
+		return "It has already been renewed the maximum number of times."
+	case errors.Is(err, ErrLifetimeReached):
+		return "It has reached the maximum lifetime allowed."
+	case errors.Is(err, ErrNotRestorable):
+		return "It has already been deleted."
+	default:
+		return "It can't be renewed."
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+)
+
+func TestRemainingRenewals(t *testing.T) {
+	tests := []struct {
+		count       string
+		maxRenewals int
+		expected    int
+	}{
+		{"", 0, -1},
+		{"5", 0, -1},
+		{"", 3, 3},
+		{"2", 3, 1},
+		{"3", 3, 0},
+		{"4", 3, 0},
+	}
+
+	for _, test := range tests {
+		resource := &search.Resource{Tags: map[string]string{RenewalCountTag: test.count}}
+		remaining, err := remainingRenewals(resource, common.Policy{MaxRenewals: test.maxRenewals})
+		if err != nil {
+			t.Errorf("Expected nil error for %+v, got %s", test, err)
+		}
+
+		if remaining != test.expected {
+			t.Errorf("Expected %d remaining renewals for %+v, got %d", test.expected, test, remaining)
+		}
+	}
+
+	resource := &search.Resource{Tags: map[string]string{RenewalCountTag: "lots"}}
+	for _, maxRenewals := range []int{0, 3} {
+		if _, err := remainingRenewals(resource, common.Policy{MaxRenewals: maxRenewals}); err == nil {
+			t.Errorf("Expected error for bad renewal count with %d max renewals, got nil", maxRenewals)
+		}
+	}
+}
+
+func TestRenewalPolicy(t *testing.T) {
+	now := time.Date(2019, time.March, 1, 12, 0, 0, 0, time.UTC)
+	policy := common.Policy{DecommissionAge: "30d", Extensions: []string{"7d"}, MaxRenewals: 3, MaxLifetime: "90d"}
+
+	// plenty of lifetime left, the policy is unchanged
+	resource := &search.Resource{ID: "i-123", CreatedAt: "2019/02/01 00:00:00", Tags: map[string]string{RenewalCountTag: "1"}}
+	renewal, err := renewalPolicy(resource, policy, now)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if renewal.MaxExtension != "" {
+		t.Errorf("Expected the max extension not to change, got %s", renewal.MaxExtension)
+	}
+
+	// the last renewal is shortened so the resource doesn't outlive the max lifetime
+	resource.CreatedAt = "2018/12/15 00:00:00"
+	renewal, err = renewalPolicy(resource, policy, now)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if renewal.MaxExtension != "324h0m0s" {
+		t.Errorf("Expected the max extension to be shortened to the 13.5 days left, got %s", renewal.MaxExtension)
+	}
+
+	if extension, err := requestedExtension(renewal, ""); err != nil || extension != "324h0m0s" {
+		t.Errorf("Expected the default extension to be capped at 324h0m0s, got %s (%v)", extension, err)
+	}
+
+	// less than a day left can still be renewed for the rest of the lifetime
+	resource.CreatedAt = "2018/12/02 06:00:00"
+	renewal, err = renewalPolicy(resource, policy, now)
+	if err != nil {
+		t.Fatalf("Expected nil error with less than a day left, got %s", err)
+	}
+
+	if extension, err := requestedExtension(renewal, ""); err != nil || extension != "18h0m0s" {
+		t.Errorf("Expected the default extension to be capped at 18h0m0s, got %s (%v)", extension, err)
+	}
+
+	resource.CreatedAt = "2018/12/01 00:00:00"
+	if _, err := renewalPolicy(resource, policy, now); !errors.Is(err, ErrLifetimeReached) {
+		t.Errorf("Expected ErrLifetimeReached, got %v", err)
+	}
+
+	resource.CreatedAt = "2019/02/01 00:00:00"
+	resource.Tags[RenewalCountTag] = "3"
+	if _, err := renewalPolicy(resource, policy, now); !errors.Is(err, ErrRenewalLimitReached) {
+		t.Errorf("Expected ErrRenewalLimitReached, got %v", err)
+	}
+
+	// resources without a creation time aren't limited by the lifetime
+	resource = &search.Resource{ID: "i-123"}
+	if _, err := renewalPolicy(resource, common.Policy{DecommissionAge: "30d", MaxLifetime: "1d"}, now); err != nil {
+		t.Errorf("Expected nil error without a creation time, got %s", err)
+	}
+
+	resource.CreatedAt = "yesterday"
+	if _, err := renewalPolicy(resource, common.Policy{DecommissionAge: "30d", MaxLifetime: "1d"}, now); err == nil {
+		t.Error("Expected error for bad creation time, got nil")
+	}
+}
+
+func TestRenewResourceBadCount(t *testing.T) {
+	tagged := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tagged++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	withTestConfig(t, common.Config{Tagging: common.Tagging{Endpoint: server.URL + "/v1/servers"}})
+
+	resource := &search.Resource{ID: "i-123", Org: "fts", Tags: map[string]string{RenewalCountTag: "lots"}}
+	if _, err := renewResource(resource, "", ""); err == nil {
+		t.Error("Expected error renewing with a bad renewal count, got nil")
+	}
+
+	if tagged != 0 {
+		t.Errorf("Expected the resource not to be tagged, got %d tagging requests", tagged)
+	}
+}
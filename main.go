@@ -9,10 +9,13 @@ import (
 	"flag"
 	"fmt"
 	"html/template"
+	"mime"
 	"net/http"
 	_ "net/http/pprof"
+	"net/mail"
 	"os"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -52,10 +55,17 @@ var (
 	// Webhooks is a slice of webhook providers
 	Webhooks []Webhook
 
+	// Notifiers are the channels warnings are sent over, only email is used if none are configured
+	Notifiers []Notifier
+
+	// Finder is the resource source shared by the batch routine and the http handlers
+	Finder search.ResourceSource
+
 	globalWg sync.WaitGroup
 
 	configFileName = flag.String("config", "config/config.json", "Configuration file.")
 	version        = flag.Bool("version", false, "Display version information and exit.")
+	dryRun         = flag.Bool("dry-run", false, "Compute the actions without tagging, decommissioning, destroying or notifying.")
 )
 
 // RenewalTemplate is the html template for the renewal endpoint
@@ -70,6 +80,64 @@ Success! Redirecting to the <a href="{{.RedirectURL}}">spinup portal</a>.
 </body>
 </html>`
 
+// renewalExtensionOption is an extension offered on the renewal confirmation page
+type renewalExtensionOption struct {
+	Value    string
+	ExpireOn string
+	Selected bool
+}
+
+// RenewalConfirmationTemplate is the html template for confirming a renewal from the renewal link
+const RenewalConfirmationTemplate = `
+<html>
+<head>
+<title>Renew {{.FQDN}}</title>
+</head>
+<body>
+{{- if .Restore}}
+<p>Restore <b>{{.FQDN}}</b> ({{.ID}})?</p>
+<p>It expired on {{.ExpireOn}} and will be deleted on {{.DestroyOn}}.</p>
+{{- else}}
+<p>Renew <b>{{.FQDN}}</b> ({{.ID}})?</p>
+<p>It's currently scheduled to expire on {{.ExpireOn}}.</p>
+{{- end}}
+<form method="POST" action="{{.Action}}">
+{{- if eq (len .Extensions) 1}}
+{{- range .Extensions}}
+<p>If you {{if $.Restore}}restore{{else}}renew{{end}} it now, it will expire on {{.ExpireOn}}.</p>
+<input type="hidden" name="extension" value="{{.Value}}" />
+{{- end}}
+{{- else}}
+<p>Renew it for
+<select name="extension">
+{{- range .Extensions}}
+<option value="{{.Value}}"{{if .Selected}} selected{{end}}>{{.Value}}, until {{.ExpireOn}}</option>
+{{- end}}
+</select>
+</p>
+{{- end}}
+<input type="hidden" name="token" value="{{.Token}}" />
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}" />
+<input type="submit" value="{{if .Restore}}Restore{{else}}Renew{{end}}" />
+</form>
+</body>
+</html>`
+
+// RenewalLimitTemplate is the html template explaining that a resource can't be renewed anymore
+const RenewalLimitTemplate = `
+<html>
+<head>
+<title>Unable to renew {{.FQDN}}</title>
+</head>
+<body>
+<p>Sorry, <b>{{.FQDN}}</b> ({{.ID}}) can't be renewed.  {{.Reason}}</p>
+{{- if .ExpireOn}}
+<p>It will expire on {{.ExpireOn}}, please back up any data you would like to keep before then.</p>
+{{- end}}
+<p>Return to the <a href="{{.RedirectURL}}">spinup portal</a>.</p>
+</body>
+</html>`
+
 func main() {
 	flag.Parse()
 	if *version {
@@ -90,6 +158,10 @@ func main() {
 	}
 	AppConfig = config
 
+	if *dryRun {
+		AppConfig.DryRun = true
+	}
+
 	// Set the loglevel, info if it's unset
 	switch AppConfig.LogLevel {
 	case "error":
@@ -109,18 +181,80 @@ func main() {
 
 	log.Debugf("Loaded Config: %+v", config)
 
+	// the plan subcommand prints the lifecycle plan and exits
+	if flag.Arg(0) == "plan" {
+		if err := validatePolicies(); err != nil {
+			log.Fatalln("Invalid policy configuration", err)
+		}
+
+		if err := planCommand(search.NewSharedSource(&AppConfig), flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatalln("Couldn't compute the lifecycle plan", err)
+		}
+		os.Exit(0)
+	}
+
 	err = configureEventReporters()
 	if err != nil {
 		log.Fatalln("Couldn't initialize event reporters", err)
 	}
 
-	reportEvent(fmt.Sprintf("Starting reaper %s%s (%s)", Version, VersionPrerelease, AppConfig.BaseURL), report.INFO)
+	if AppConfig.DryRun {
+		log.Warn("Running in dry run mode, no resources will be tagged, decommissioned or destroyed and no notifications will be sent")
+		reportEvent(fmt.Sprintf("Starting reaper %s%s (%s) in dry run mode", Version, VersionPrerelease, AppConfig.BaseURL), report.INFO)
+	} else {
+		reportEvent(fmt.Sprintf("Starting reaper %s%s (%s)", Version, VersionPrerelease, AppConfig.BaseURL), report.INFO)
+	}
 
 	err = configureWebhooks()
 	if err != nil {
 		log.Fatalln("Couldn't initialize web hooks", err)
 	}
 
+	if AppConfig.Email.Mailserver != "" || AppConfig.Email.Transport != "" {
+		if MailTransport, err = NewTransport(AppConfig.Email); err != nil {
+			log.Fatalln("Invalid email configuration", err)
+		}
+
+		if _, err = mail.ParseAddress(AppConfig.Email.From); err != nil {
+			log.Fatalln("Invalid email from address", err)
+		}
+	}
+
+	err = configureNotifiers()
+	if err != nil {
+		log.Fatalln("Couldn't initialize notifiers", err)
+	}
+
+	if err := validatePolicies(); err != nil {
+		log.Fatalln("Invalid policy configuration", err)
+	}
+
+	for i, secret := range AppConfig.RenewalSecrets() {
+		if secret == "" {
+			log.Fatalf("Encryption secret %d is empty", i)
+		}
+		log.Infof("Renewal token key version %d has fingerprint %s", i, secretFingerprint(secret))
+	}
+
+	if AppConfig.RenewalTokenTTL != "" {
+		if _, err := parseDuration(AppConfig.RenewalTokenTTL); err != nil {
+			log.Fatalln("Invalid renewal token ttl", err)
+		}
+	}
+
+	renewalLimiter, err = newRateLimiter(AppConfig.RateLimit)
+	if err != nil {
+		log.Fatalln("Invalid rate limit configuration", err)
+	}
+
+	// Setup the shared resource source, connecting up front so problems show up in the logs early.  If the
+	// search engine isn't available yet, the connection is retried when it's used.
+	finder := search.NewSharedSource(&AppConfig)
+	if _, err := finder.Connect(); err != nil {
+		log.Warnf("Couldn't connect to the search engine on startup, will retry: %s", err)
+	}
+	Finder = finder
+
 	// Setup context to allow goroutines to be cancelled
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -183,6 +317,47 @@ func configureWebhooks() error {
 	return nil
 }
 
+// configureNotifiers creates the notification channels configured in AppConfig.Notify.Channels, in order of their
+// names so the channels are always tried in the same order
+func configureNotifiers() error {
+	names := make([]string, 0, len(AppConfig.Notify.Channels))
+	for name := range AppConfig.Notify.Channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		notifier, err := NewNotifier(name, AppConfig.Notify.Channels[name])
+		if err != nil {
+			return err
+		}
+
+		log.Debugf("Configured %s notifier", name)
+		Notifiers = append(Notifiers, notifier)
+	}
+
+	// the renewal links are only sent to the owner, so a shared channel can't be the only one
+	if len(Notifiers) > 0 {
+		for _, n := range Notifiers {
+			if !isShared(n) {
+				return nil
+			}
+		}
+		return fmt.Errorf("at least one notifier that reaches the owner (email, http or slack) is required")
+	}
+
+	return nil
+}
+
+// notifiers returns the configured notification channels, or the email channel if none are configured
+func notifiers() []Notifier {
+	if len(Notifiers) == 0 {
+		n, _ := NewNotifier("email", nil)
+		return []Notifier{n}
+	}
+	return Notifiers
+}
+
 // startHTTPServer registers the api endpoints and starts the webserver listening
 func startHTTPServer(cancel func()) *http.Server {
 	router := mux.NewRouter()
@@ -236,23 +411,21 @@ func startHTTPServer(cancel func()) *http.Server {
 	api.HandleFunc("/reaper/shutdown", func(w http.ResponseWriter, r *http.Request) {
 		log.Infoln("Received shutdown request, cancelling goroutines.")
 
-		log.Debugf("Authenticating token for protected URL '%s'", r.URL)
-
-		htoken := r.Header.Get("X-Auth-Token")
-		if err := bcrypt.CompareHashAndPassword([]byte(htoken), []byte(AppConfig.Token)); err != nil {
-			log.Warnf("Unable to authenticate session for '%s' with '%s'", r.URL, htoken)
+		if !authenticateRequest(r) {
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
 
-		log.Infof("Successfully authenticated token for URL '%s'", r.URL)
-
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
 		cancel()
 	})
 
+	api.HandleFunc("/reaper/plan", PlanHandler)
+	api.HandleFunc("/reaper/dev/outbox", OutboxHandler)
 	api.HandleFunc("/reaper/renew/{id:[A-Za-z0-9-]+}", RenewalHander)
+	api.HandleFunc("/reaper/resources/{id:[A-Za-z0-9-]+}/renew", ResourceRenewalHandler)
+	api.HandleFunc("/reaper/resources/{id:[A-Za-z0-9-]+}/schedule", ScheduleHandler)
 
 	srv := &http.Server{
 		Handler:      handlers.LoggingHandler(os.Stdout, router),
@@ -272,25 +445,42 @@ func startHTTPServer(cancel func()) *http.Server {
 	return srv
 }
 
+// authenticateRequest checks the X-Auth-Token header of a request for a protected URL against the API token
+func authenticateRequest(r *http.Request) bool {
+	log.Debugf("Authenticating token for protected URL '%s'", r.URL)
+
+	htoken := r.Header.Get("X-Auth-Token")
+	if err := bcrypt.CompareHashAndPassword([]byte(htoken), []byte(AppConfig.Token)); err != nil {
+		log.Warnf("Unable to authenticate session for '%s' with '%s'", r.URL, htoken)
+		return false
+	}
+
+	log.Infof("Successfully authenticated token for URL '%s'", r.URL)
+	return true
+}
+
 // RenewalHander handles resource renewal
-// - The request method is checked, it should be GET
-// - Query parameter 'token' is retrieved from the request
+// - The request method is checked, it should be GET or POST
+// - Requests are rate limited per client IP and per resource
+// - Parameter 'token' is retrieved from the request query or form
 // - The subject resource id is retrieved from the URL variable
+// - Token signature is verified, clients are locked out after repeated invalid tokens
 // - Resource with the id 'id' is fetched from elasticsearch
 // - Token is validated against the information pulled from the resource
-// - If everything is good, the renewed_at tag is updated
+// - The renewal limits of the policy are checked, a page explaining why is rendered if the resource can't be renewed
+// - On GET, a confirmation page is rendered so link scanners can't renew the resource
+// - On POST, the CSRF token is validated and if everything is good, the renewed_at tag is updated
+// - Decommissioned resources that haven't crossed the destroy age are restored before they're renewed
 func RenewalHander(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte{})
 		return
 	}
 
-	// Look for the token in the query
-	tokens, ok := r.URL.Query()["token"]
-	if !ok || len(tokens) != 1 {
-		log.Warnf("Token parameter is missing or of bad format for request: %s", r.URL)
-		w.WriteHeader(http.StatusForbidden)
+	if err := r.ParseForm(); err != nil {
+		log.Warnf("Failed to parse renewal request %s: %s", r.URL, err)
+		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte{})
 		return
 	}
@@ -303,52 +493,114 @@ func RenewalHander(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	finder, err := search.NewFinder(&AppConfig)
-	if err != nil {
-		log.Errorln("Couldn't configure a new finder", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Failed connecting to elasticsearch"))
+	// the endpoint is public, limit the requests before doing any work
+	client := renewalLimiter.clientIP(r)
+	if wait, ok := renewalLimiter.allow(client, id, time.Now()); !ok {
+		log.Warnf("Rate limited renewal request from %s for %s", client, id)
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds()+1)))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("Too many requests, please try again later."))
 		return
 	}
 
-	resource, err := finder.DoGet("resources", "server", id)
-	if err != nil {
-		log.Errorf("Couldn't get the %s resource from elasticsearch, %s", id, err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Failed getting details about resource"))
+	// Look for the token in the query or the form
+	tokens, ok := r.Form["token"]
+	if !ok || len(tokens) != 1 {
+		log.Warnf("Token parameter is missing or of bad format for request: %s", r.URL)
+		renewalLimiter.fail(client, id, time.Now())
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte{})
 		return
 	}
 
-	log.Debugf("Got resource %+v back from elasticsearch", resource)
+	// verify the token before fetching the resource, so bad tokens are cheap to refuse
+	renewalSecret := &RenewalSecret{
+		ResourceID: id,
+		Action:     RenewAction,
+		Secrets:    AppConfig.RenewalSecrets(),
+	}
+	if err := renewalSecret.VerifyRenewalToken(tokens[0]); err != nil {
+		log.Warnf("Failed to verify token string %s from %s, %s", tokens[0], client, err.Error())
+		if errors.Is(err, ErrTokenInvalid) || errors.Is(err, ErrTokenWrongResource) {
+			renewalLimiter.fail(client, id, time.Now())
+		}
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(renewalTokenErrorMessage(err)))
+		return
+	}
+
+	resource, policy, ok := getRenewalResource(w, id)
+	if !ok {
+		return
+	}
 
-	renewalSecret := &RenewalSecret{RenewedAt: resource.RenewedAt, Secret: AppConfig.EncryptionSecret}
-	if err = renewalSecret.ValidateRenewalToken(tokens[0]); err != nil {
+	renewalSecret.RenewedAt = resource.RenewedAt
+	if err := renewalSecret.ValidateRenewalToken(tokens[0]); err != nil {
 		log.Warnf("Failed to validate token string %s, %s", tokens[0], err.Error())
 		w.WriteHeader(http.StatusForbidden)
-		w.Write([]byte{})
+		w.Write([]byte(renewalTokenErrorMessage(err)))
+		return
+	}
+
+	// decommissioned resources are restored until they're destroyed, the renewal is bound by the renewal limits of the policy
+	renewal, restore, err := checkRenewal(resource, policy, time.Now())
+	if renewalRefused(err) {
+		log.Warnf("Refusing to renew %s, %s", resource.ID, err)
+		renderRenewalLimit(w, resource, policy, err)
 		return
 	}
 
-	tagger, err := NewTagger(AppConfig.Tagging.Endpoint, AppConfig.Tagging.Token, id, resource.Org, AppConfig.Tagging.EncryptToken)
 	if err != nil {
-		log.Errorf("Failed to renew resource %s, %s", id, err.Error())
+		log.Errorf("Unable to check the renewal limits for %s: %s", resource.ID, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Unable to process renewal, please try again later."))
 		return
 	}
 
-	newRenewedAt := time.Now().Format("2006/01/02 15:04:05")
-	if err = tagger.Tag(map[string]string{"yale:renewed_at": newRenewedAt}); err != nil {
-		log.Errorf("Failed to renew resource %s, %s", id, err.Error())
+	if r.Method == http.MethodGet {
+		renderRenewalConfirmation(w, r, resource, renewal, restore, tokens[0])
+		return
+	}
+
+	if !validCSRFToken(r) {
+		log.Warnf("Failed to validate the CSRF token for renewal request %s", r.URL)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte{})
+		return
+	}
+
+	extension, err := requestedExtension(renewal, r.PostFormValue("extension"))
+	if err != nil {
+		log.Warnf("Invalid extension for renewal request %s: %s", r.URL, err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("The requested extension is not valid."))
+		return
+	}
+
+	// mark the token as used before renewing so it can't be replayed, releasing it if the renewal fails
+	release, err := renewalSecret.UseRenewalToken(tokens[0])
+	if err != nil {
+		log.Warnf("Failed to use token string %s, %s", tokens[0], err.Error())
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(renewalTokenErrorMessage(err)))
+		return
+	}
+	renewalSecret.logKeyVersion(tokens[0])
+
+	var renewed *search.Resource
+	if restore {
+		renewed, err = restoreResource(resource, policy, "", extension)
+	} else {
+		renewed, err = renewResource(resource, "", extension)
+	}
+
+	if err != nil {
+		release()
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Unable to process renewal, please try again later."))
 		return
 	}
 
-	msg := fmt.Sprintf("Renewed %s (%s) created by %s", resource.FQDN, id, resource.SupportDepartmentContact)
-	log.Info(msg)
-	reportEvent(msg, report.INFO)
-
 	buffer := new(bytes.Buffer)
 	tmpl, err := template.New("renewalTemplate").Parse(RenewalTemplate)
 	if err != nil {
@@ -365,6 +617,383 @@ func RenewalHander(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if restore {
+		sendRestoredEmail(renewed, policy)
+		return
+	}
+	sendRenewalEmail(renewed, policy)
+}
+
+// renewalTokenErrorMessage returns a message for the user explaining why their renewal token wasn't accepted
+func renewalTokenErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, ErrTokenExpired):
+		return "This renewal link has expired."
+	case errors.Is(err, ErrTokenUsed), errors.Is(err, ErrTokenStale):
+		return "This renewal link has already been used."
+	default:
+		return "This renewal link is not valid."
+	}
+}
+
+// renderRenewalLimit renders the page explaining why the resource can't be renewed
+func renderRenewalLimit(w http.ResponseWriter, resource *search.Resource, policy common.Policy, reason error) {
+	expireOn, err := resourceDecomAt(resource, policy)
+	if err != nil {
+		log.Errorf("Unable to get the decomAt date for %s: %s", resource.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to process renewal, please try again later."))
+		return
+	}
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		loc = time.FixedZone("UTC", 0)
+	}
+
+	// resources that have already expired don't get an expiry date
+	expires := ""
+	if expireOn.After(time.Now()) {
+		expires = expireOn.In(loc).Format("2006/01/02 15:04:05 MST")
+	}
+
+	buffer := new(bytes.Buffer)
+	tmpl, err := template.New("renewalLimitTemplate").Parse(RenewalLimitTemplate)
+	if err == nil {
+		err = tmpl.Execute(buffer, struct {
+			ID          string
+			FQDN        string
+			Reason      string
+			ExpireOn    string
+			RedirectURL string
+		}{
+			ID:          resource.ID,
+			FQDN:        resource.FQDN,
+			Reason:      renewalLimitMessage(reason),
+			ExpireOn:    expires,
+			RedirectURL: AppConfig.RedirectURL,
+		})
+	}
+
+	if err != nil {
+		log.Errorf("Failed to render the renewal limit template: %s", err)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(renewalLimitMessage(reason)))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(buffer.Bytes())
+}
+
+// renderRenewalConfirmation renders the renewal confirmation page, with a form to POST the renewal token
+// along with a CSRF token.  Decommissioned resources are offered to be restored instead.
+func renderRenewalConfirmation(w http.ResponseWriter, r *http.Request, resource *search.Resource, policy common.Policy, restore bool, token string) {
+	expireOn, err := resourceDecomAt(resource, policy)
+	if err != nil {
+		log.Errorf("Unable to get the decomAt date for %s: %s", resource.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to process renewal, please try again later."))
+		return
+	}
+
+	destroyOn, err := resourceDestroyAt(resource, policy)
+	if err != nil {
+		log.Errorf("Unable to get the destroyAt date for %s: %s", resource.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to process renewal, please try again later."))
+		return
+	}
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		loc = time.FixedZone("UTC", 0)
+	}
+
+	options, err := extensionOptions(policy)
+	if err != nil {
+		log.Errorf("Unable to get the renewal extensions for %s: %s", resource.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to process renewal, please try again later."))
+		return
+	}
+
+	// the default extension is selected, each option shows the date the resource will expire on with that extension
+	defaultExtension, _ := requestedExtension(policy, "")
+	now := time.Now().Format("2006/01/02 15:04:05")
+	extensions := make([]renewalExtensionOption, len(options))
+	for i, o := range options {
+		e, _ := requestedExtension(policy, o)
+		decomAt, err := resourceDecomAt(renewedResource(resource, now, e), policy)
+		if err != nil {
+			log.Errorf("Unable to get the decomAt date for %s: %s", resource.ID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Unable to process renewal, please try again later."))
+			return
+		}
+
+		extensions[i] = renewalExtensionOption{
+			Value:    o,
+			ExpireOn: decomAt.In(loc).Format("2006/01/02 15:04:05 MST"),
+			Selected: e == defaultExtension,
+		}
+	}
+
+	csrfToken, err := newCSRFToken()
+	if err != nil {
+		log.Errorf("Failed to generate CSRF token: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to process renewal, please try again later."))
+		return
+	}
+
+	buffer := new(bytes.Buffer)
+	tmpl, err := template.New("renewalConfirmationTemplate").Parse(RenewalConfirmationTemplate)
+	if err == nil {
+		err = tmpl.Execute(buffer, struct {
+			ID         string
+			FQDN       string
+			ExpireOn   string
+			DestroyOn  string
+			Restore    bool
+			Extensions []renewalExtensionOption
+			Action     string
+			Token      string
+			CSRFToken  string
+		}{
+			ID:         resource.ID,
+			FQDN:       resource.FQDN,
+			ExpireOn:   expireOn.In(loc).Format("2006/01/02 15:04:05 MST"),
+			DestroyOn:  destroyOn.In(loc).Format("2006/01/02 15:04:05 MST"),
+			Restore:    restore,
+			Extensions: extensions,
+			Action:     r.URL.Path,
+			Token:      token,
+			CSRFToken:  csrfToken,
+		})
+	}
+
+	if err != nil {
+		log.Errorf("Failed to render the renewal confirmation template: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to process renewal, please try again later."))
+		return
+	}
+
+	setCSRFCookie(w, r.URL.Path, csrfToken)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buffer.Bytes())
+}
+
+// ResourceRenewalHandler handles resource renewal through the API
+// - The request method is checked, it should be POST
+// - The API token is authenticated from the 'X-Auth-Token' header
+// - The acting user is retrieved from the 'X-Forwarded-User' header
+// - Resource with the id 'id' is fetched from elasticsearch
+// - The renewal limits of the policy are checked
+// - If everything is good, the renewed_at tag is updated and the new schedule is returned
+// - Decommissioned resources that haven't crossed the destroy age are restored before they're renewed
+func ResourceRenewalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte{})
+		return
+	}
+
+	if !authenticateRequest(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	actor := r.Header.Get("X-Forwarded-User")
+	if actor == "" {
+		log.Warnf("Acting user header is missing for request: %s", r.URL)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("X-Forwarded-User header is required"))
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte{})
+		return
+	}
+
+	// the extension can be requested in a JSON body or as a form value
+	var req struct {
+		Extension string `json:"extension"`
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Warnf("Failed to decode renewal request %s: %s", r.URL, err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Failed to decode the renewal request"))
+			return
+		}
+	} else {
+		req.Extension = r.FormValue("extension")
+	}
+
+	resource, policy, ok := getRenewalResource(w, id)
+	if !ok {
+		return
+	}
+
+	renewal, restore, err := checkRenewal(resource, policy, time.Now())
+	if renewalRefused(err) {
+		log.Warnf("Refusing to renew %s, %s", resource.ID, err)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if err != nil {
+		log.Errorf("Unable to check the renewal limits for %s: %s", resource.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to process renewal, please try again later."))
+		return
+	}
+
+	extension, err := requestedExtension(renewal, req.Extension)
+	if err != nil {
+		log.Warnf("Invalid extension for renewal request %s: %s", r.URL, err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var renewed *search.Resource
+	if restore {
+		renewed, err = restoreResource(resource, policy, actor, extension)
+	} else {
+		renewed, err = renewResource(resource, actor, extension)
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to process renewal, please try again later."))
+		return
+	}
+
+	schedule, err := computeSchedule(renewed, policy)
+	if err != nil {
+		log.Errorf("Couldn't compute the schedule for %s, %s", id, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed computing the resource schedule"))
+		return
+	}
+
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte{})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+
+	if restore {
+		sendRestoredEmail(renewed, policy)
+		return
+	}
+	sendRenewalEmail(renewed, policy)
+}
+
+// getRenewalResource gets the resource to be renewed and the policy it belongs to, writing the error response if it fails
+func getRenewalResource(w http.ResponseWriter, id string) (*search.Resource, common.Policy, bool) {
+	resource, err := Finder.DoGet(id)
+	if errors.Is(err, search.ErrResourceNotFound) {
+		log.Warnf("Couldn't find the %s resource to renew", id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Resource not found"))
+		return nil, common.Policy{}, false
+	}
+
+	if errors.Is(err, search.ErrSourceUnavailable) {
+		log.Errorf("Couldn't get the %s resource, %s", id, err.Error())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Failed connecting to elasticsearch"))
+		return nil, common.Policy{}, false
+	}
+
+	if err != nil {
+		log.Errorf("Couldn't get the %s resource from elasticsearch, %s", id, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed getting details about resource"))
+		return nil, common.Policy{}, false
+	}
+
+	log.Debugf("Got resource %+v back from elasticsearch", resource)
+
+	policy, ok := policyFor(resource)
+	if !ok {
+		log.Warnf("%s doesn't match any policy, using the %s policy", id, policy.Name)
+	}
+
+	return resource, policy, true
+}
+
+// renewResource updates the renewed_at, renewal extension and renewal count tags of the resource and reports the renewal.  If the
+// renewal was made through the API, actor is the user that renewed the resource.  An empty extension renews the
+// resource for the full decommission age.  The resource as it is after the renewal is returned.
+func renewResource(resource *search.Resource, actor, extension string) (*search.Resource, error) {
+	tagger, err := NewTagger(AppConfig.Tagging.Endpoint, AppConfig.Tagging.Token, resource.ID, resource.Org, AppConfig.Tagging.EncryptToken)
+	if err != nil {
+		log.Errorf("Failed to renew resource %s, %s", resource.ID, err.Error())
+		return nil, err
+	}
+	tagger.User = actor
+
+	// a bad renewal count isn't reset, that would let the resource get around the renewal limit
+	count, err := renewalCount(resource)
+	if err != nil {
+		log.Errorf("Failed to renew resource %s, %s", resource.ID, err.Error())
+		return nil, err
+	}
+
+	newRenewedAt := time.Now().Format("2006/01/02 15:04:05")
+	if err = tagger.Tag(map[string]string{
+		searchFields().RenewedAt: newRenewedAt,
+		RenewalExtensionTag:      extension,
+		RenewalCountTag:          strconv.Itoa(count + 1),
+	}); err != nil {
+		log.Errorf("Failed to renew resource %s, %s", resource.ID, err.Error())
+		return nil, err
+	}
+
+	msg := fmt.Sprintf("Renewed %s (%s) created by %s", resource.FQDN, resource.ID, resource.SupportDepartmentContact)
+	if extension != "" {
+		msg = fmt.Sprintf("%s for %s", msg, extension)
+	}
+	if actor != "" {
+		msg = fmt.Sprintf("%s on behalf of %s", msg, actor)
+	}
+	log.Info(msg)
+	reportEvent(msg, report.INFO)
+
+	renewed := renewedResource(resource, newRenewedAt, extension)
+	renewed.Tags[RenewalCountTag] = strconv.Itoa(count + 1)
+	return renewed, nil
+}
+
+// sendRenewalEmail sends the renewal confirmation email to the owner of the renewed resource
+func sendRenewalEmail(resource *search.Resource, policy common.Policy) {
+	sendOwnerEmail(resource, policy, "Your Spinup TryIT server renewal", ParseRenewalTemplate)
+}
+
+// sendRestoredEmail lets the owner of a restored resource know it has been restored
+func sendRestoredEmail(resource *search.Resource, policy common.Policy) {
+	sendOwnerEmail(resource, policy, "Your Spinup TryIT server has been restored", ParseRestoredTemplate)
+}
+
+// sendOwnerEmail sends an email generated from the template parser to the owner of the resource
+func sendOwnerEmail(resource *search.Resource, policy common.Policy, subject string, parse func(params, tags map[string]string) (string, error)) {
 	f, err := NewUserFetcher(AppConfig.UserDatasource)
 	if err != nil {
 		log.Errorf("Unable to configure user datasource for %s: %s", resource.SupportDepartmentContact, err)
@@ -376,7 +1005,7 @@ func RenewalHander(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	expireOn, err := GetDecomAt(newRenewedAt, AppConfig.Decommission.Age)
+	expireOn, err := resourceDecomAt(resource, policy)
 	if err != nil {
 		log.Errorf("Unable to get the decomAt date for %s: %s", resource.ID, err)
 		return
@@ -387,8 +1016,7 @@ func RenewalHander(w http.ResponseWriter, r *http.Request) {
 		loc = time.FixedZone("UTC", 0)
 	}
 
-	// generate the renewal confirmation email
-	body, err := ParseRenewalTemplate(map[string]string{
+	body, err := parse(map[string]string{
 		"first":         user.First,
 		"email":         user.Email,
 		"netid":         resource.SupportDepartmentContact,
@@ -396,18 +1024,17 @@ func RenewalHander(w http.ResponseWriter, r *http.Request) {
 		"expire_on":     expireOn.In(loc).Format("2006/01/02 15:04:05 MST"),
 		"spinupURL":     AppConfig.SpinupURL,
 		"spinupSiteURL": AppConfig.SpinupSiteURL,
-	})
+	}, resource.Tags)
 
 	if err != nil {
-		log.Errorf("Unable to get the parse the renewal template for %s: %s", resource.ID, err)
+		log.Errorf("Unable to get the parse the email template for %s: %s", resource.ID, err)
 		return
 	}
 
-	err = SendMail(AppConfig.Email.Mailserver, body, AppConfig.Email.From, AppConfig.Email.Password,
-		"Your Spinup TryIT server renewal", user.Email, AppConfig.Email.Username)
+	err = SendMail(AppConfig.Email, user.Email, subject, body)
 
 	if err != nil {
-		log.Errorf("Failed sending the renewal confirmation email: %s", err)
+		log.Errorf("Failed sending the '%s' email: %s", subject, err)
 	}
 }
 
@@ -421,28 +1048,21 @@ func Start(ctx context.Context) error {
 	}
 	ticker := time.NewTicker(interval)
 
-	// sort notifier schedule
-	sort.Sort(BySchedule(AppConfig.Notify.Age))
-
 	globalWg.Add(1)
 	// launch a goroutine to run our batch on a schedule
 	go func() {
 		defer globalWg.Done()
 
-		finder, err := search.NewFinder(&AppConfig)
-		if err != nil {
-			log.Errorln("Couldn't configure a new finder", err)
-			return
-		}
-
 		log.Infof("Initializing the batching routine loop.")
 		for {
 			select {
 			case <-ticker.C:
 				log.Infoln("Batch routine running...")
-				destroy(*finder)
-				decommission(*finder)
-				notify(*finder)
+				for _, policy := range policies() {
+					destroy(Finder, policy)
+					decommission(Finder, policy)
+					notify(Finder, policy)
+				}
 				log.Infoln("Batch routine sleeping...")
 			case <-ctx.Done():
 				log.Infoln("Shutdown the batch routine")
@@ -456,285 +1076,224 @@ func Start(ctx context.Context) error {
 
 // notify runs the routine to search for resources with renewed_at dates within a given range configured for notification.
 // If the age-based notification threshold is crossed and a notification hasn't been sent:
-// - Update the notified_at tag on the instance
-// - Bail on this resource and continue to the next if tagging fails
-// - Notify with a Notifier
-// - Rollback tag if the notification fails
-func notify(finder search.Finder) {
-	log.Infoln("Launching Notifier...")
-
-	ages := AppConfig.Notify.Age
-	lte := fmt.Sprintf("now-%s", ages[0])
-	log.Debugf("%s >= renewed_at", lte)
-
-	// Query for anything older than the oldest age with the configured filters and status created
-	termfilter := append(search.NewTermQueryList(AppConfig.Filter), search.TermQuery{Term: "status", Value: "created"})
-	resources, err := finder.DoDateRangeQuery("resources", "server", &search.DateRangeQuery{
-		Field:      "yale:renewed_at",
-		Format:     "YYYY/MM/dd HH:mm:ss",
-		Lte:        lte,
-		TermFilter: termfilter,
-	})
+// - Group the due resources by owner
+// - Update the notified_at tag on each of the owner's instances
+// - Leave a resource out of the warning if tagging fails
+// - Notify the owner with a single digest listing all of their due resources
+// - Rollback the tags if the notification fails
+func notify(finder search.ResourceSource, policy common.Policy) {
+	logger := log.WithField("policy", policy.Name)
+	logger.Infoln("Launching Notifier...")
+
+	ages, drq, err := notifyQuery(policy)
+	if err != nil {
+		logger.Errorln("Invalid filter configuration", err)
+		return
+	}
+	logger.Debugf("%s >= renewed_at", drq.Lte)
+
+	ageDurations := make([]time.Duration, len(ages))
+	for i, age := range ages {
+		if ageDurations[i], err = parseDuration(age); err != nil {
+			logger.Errorf("Couldn't parse %s as a duration. %s", age, err.Error())
+			return
+		}
+	}
 
+	resources, err := findResources(finder, drq)
 	if err != nil {
-		log.Errorln("Failed to execute date range query", err)
+		logger.Errorln("Failed to execute date range query", err)
 		return
 	}
 
-	// loop over the returned resources
+	// loop over the returned resources, collecting the resources that are due for a warning
+	var pending []*dueResource
 	for _, resource := range resources {
-		log.Debugf("Checking returned resource: %+v", resource)
+		logger.Debugf("Checking returned resource: %+v", resource)
+
+		if !inPolicy(resource, policy) {
+			continue
+		}
 
 		if resource.Org == "" {
-			log.Errorf("Cannot operate on a resource without an org.  ID: %s", resource.ID)
+			logger.Errorf("Cannot operate on a resource without an org.  ID: %s", resource.ID)
 			continue
 		}
 
 		// time of the last renewal
 		renewedAt, err := time.Parse("2006/01/02 15:04:05", resource.RenewedAt)
 		if err != nil {
-			log.Errorf("%s Couldn't parse renewed_at (%s) as a time value. %s", resource.ID, resource.RenewedAt, err.Error())
+			logger.Errorf("%s Couldn't parse renewed_at (%s) as a time value. %s", resource.ID, resource.RenewedAt, err.Error())
+			continue
+		}
+		logger.Infof("%s last renewed at %s", resource.ID, renewedAt.String())
+
+		// start of the lifecycle, shifted by the renewal extension
+		start, err := lifecycleStart(resource, policy)
+		if err != nil {
+			logger.Errorf("%s Couldn't get the start of the lifecycle. %s", resource.ID, err.Error())
 			continue
 		}
-		log.Infof("%s last renewed at %s", resource.ID, renewedAt.String())
 
-		renewalSecret := &RenewalSecret{
-			RenewedAt: resource.RenewedAt,
-			Secret:    AppConfig.EncryptionSecret,
+		if !start.Add(ageDurations[0]).Before(time.Now()) {
+			logger.Debugf("%s hasn't crossed the %s age threshold with its renewal extension, skipping", resource.ID, ages[0])
+			continue
 		}
-		token, err := renewalSecret.GenerateRenewalToken()
+
+		renewalLink, err := newRenewalLink(resource, renewalTokenTTL())
 		if err != nil {
-			log.Errorf("Failed to generate renewal token, %s", err.Error())
+			logger.Errorf("Failed to generate renewal token, %s", err.Error())
 			continue
 		}
-		renewalLink := fmt.Sprintf("%s/renew/%s?token=%s", AppConfig.BaseURL, resource.ID, token)
-		log.Debugf("Generated renewal link: %s", renewalLink)
+		logger.Debugf("Generated renewal link: %s", renewalLink)
 
 		if resource.NotifiedAt == "" {
-			log.Infof("%s Notified At is not set, Notifying on age threshold %s", resource.ID, ages[0])
-			err := sendNotification(resource, renewalLink, renewedAt)
-			if err != nil {
-				log.Errorf("Failed to notify. %s", err.Error())
+			logger.Infof("%s Notified At is not set, Notifying on age threshold %s", resource.ID, ages[0])
+			if reportDryRun(policy, "notify %s for %s (%s)", resource.SupportDepartmentContact, resource.FQDN, resource.ID) {
 				continue
 			}
 
-			sendWebhooks(&Event{
-				ID:     resource.ID,
-				Action: "notify",
-			})
+			pending = append(pending, &dueResource{Resource: resource, Link: renewalLink, RenewedAt: renewedAt})
 		} else {
 			// time of the last notification
 			notifiedAt, err := time.Parse("2006/01/02 15:04:05", resource.NotifiedAt)
 			if err != nil {
-				log.Errorf("%s Couldn't parse notified_at (%s) as a time value. %s", resource.ID, resource.NotifiedAt, err.Error())
+				logger.Errorf("%s Couldn't parse notified_at (%s) as a time value. %s", resource.ID, resource.NotifiedAt, err.Error())
 				continue
 			}
-			log.Infof("%s last notified at %s", resource.ID, notifiedAt.String())
+			logger.Infof("%s last notified at %s", resource.ID, notifiedAt.String())
 
-			// range over the ages and check if we've notified since the age threshold was crossed
-			for _, age := range ages {
-				log.Debugf("Checking for notification on age %s", age)
-
-				ageDuration, err := parseDuration(age)
-				if err != nil {
-					log.Errorf("%s Couldn't parse %s as a duration. %s", resource.ID, age, err.Error())
-					// If we can't parse the age, stop trying and move on
-					break
-				}
-
-				// time the age threshold was crossed
-				ageThresholdAt := renewedAt.Add(ageDuration)
-				log.Debugf("%s %s age threshold: %s", resource.ID, age, ageThresholdAt.String())
-
-				if ageThresholdAt.Before(time.Now()) && notifiedAt.Before(ageThresholdAt) {
-					log.Infof("%s notified (%s) before age threshold (%s) was crossed (%s). Notifying", resource.ID, notifiedAt.String(), age, ageThresholdAt.String())
-
-					err := sendNotification(resource, renewalLink, renewedAt)
-					if err != nil {
-						log.Errorf("Failed to notify. %s", err.Error())
-					}
-
-					sendWebhooks(&Event{
-						ID:     resource.ID,
-						Action: "notify",
-					})
-
-					// stop notifying if we matched
-					break
-				}
-
-				log.Debugf("%s has been notified (%s) since crossing the %s age threshold (%s)", resource.ID, notifiedAt.String(), age, ageThresholdAt.String())
+			// check if we've notified since the age threshold was crossed
+			age, ageThresholdAt, due, err := notifyThreshold(start, notifiedAt, ages, time.Now())
+			if err != nil {
+				logger.Errorf("%s Couldn't check the notification age thresholds. %s", resource.ID, err.Error())
+				continue
 			}
-		}
-	}
-}
-
-func sendNotification(resource *search.Resource, renewalLink string, renewedAt time.Time) error {
-	// try to get details about the user before we do _anything_ since it's the lightest touch
-	f, err := NewUserFetcher(AppConfig.UserDatasource)
-	if err != nil {
-		msg := fmt.Sprintf("Unable to configure user datasource for %s", resource.SupportDepartmentContact)
-		log.Error(msg+": "+err.Error(), resource.SupportDepartmentContact, err)
-		reportEvent("FAILED "+msg, report.ERROR)
-		return err
-	}
-	user, err := GetUserByID(f, resource.SupportDepartmentContact)
-	if err != nil {
-		msg := fmt.Sprintf("Unable to get details about user %s", resource.SupportDepartmentContact)
-		log.Error(msg + ": " + err.Error())
-		reportEvent("FAILED "+msg, report.ERROR)
-		return err
-	}
 
-	// tag the instance with the new notification date
-	tagger, err := NewTagger(AppConfig.Tagging.Endpoint, AppConfig.Tagging.Token, resource.ID, resource.Org, AppConfig.Tagging.EncryptToken)
-	if err != nil {
-		msg := fmt.Sprintf("Unable to update tag for %s (%s)", resource.FQDN, resource.ID)
-		log.Error(msg + ": " + err.Error())
-		reportEvent("FAILED"+msg, report.ERROR)
-		return err
-	}
-
-	err = tagger.Tag(map[string]string{
-		"yale:notified_at": time.Now().Format("2006/01/02 15:04:05"),
-	})
-
-	// if we can't tag, then bail all together, I just can't go on....
-	if err != nil {
-		msg := fmt.Sprintf("Unable to update tag for %s (%s)", resource.FQDN, resource.ID)
-		log.Error(msg + ": " + err.Error())
-		reportEvent("FAILED"+msg, report.ERROR)
-		return err
-	}
+			if !due {
+				logger.Debugf("%s has been notified (%s) since crossing the last age threshold", resource.ID, notifiedAt.String())
+				continue
+			}
 
-	// create a function for rolling back the tag if something fails
-	rollBackTag := func() {
-		err = tagger.Tag(map[string]string{
-			"yale:notified_at": resource.NotifiedAt,
-		})
+			logger.Infof("%s notified (%s) before age threshold (%s) was crossed (%s). Notifying", resource.ID, notifiedAt.String(), age, ageThresholdAt.String())
+			if reportDryRun(policy, "notify %s for %s (%s)", resource.SupportDepartmentContact, resource.FQDN, resource.ID) {
+				continue
+			}
 
-		if err != nil {
-			msg := fmt.Sprintf("Unable to roll back tag for %s (%s)", resource.FQDN, resource.ID)
-			reportEvent("FAILED "+msg, report.ERROR)
-			log.Errorf(msg + ": " + err.Error())
+			pending = append(pending, &dueResource{Resource: resource, Link: renewalLink, RenewedAt: renewedAt})
 		}
 	}
 
-	reportEvent(fmt.Sprintf("Notifying %s for %s (%s)", resource.SupportDepartmentContact, resource.FQDN, resource.ID), report.INFO)
-
-	// get the date that the instance will expire
-	expireOn, err := GetDecomAt(resource.RenewedAt, AppConfig.Decommission.Age)
-	if err != nil {
-		log.Errorf("Unable to get the decomAt date for %s: %s", resource.ID, err)
-		return err
-	}
-
-	loc, err := time.LoadLocation("America/New_York")
-	if err != nil {
-		loc = time.FixedZone("UTC", 0)
-	}
-
-	// generate the warning email from the warning template
-	body, err := ParseWarningTemplate(map[string]string{
-		"first":      user.First,
-		"email":      user.Email,
-		"netid":      resource.SupportDepartmentContact,
-		"link":       renewalLink,
-		"expire_on":  expireOn.In(loc).Format("2006/01/02 15:04:05 MST"),
-		"renewed_at": renewedAt.In(loc).Format("2006/01/02 15:04:05 MST"),
-		"fqdn":       resource.FQDN,
-		"spinupURL":  AppConfig.RedirectURL,
-	})
+	// owners get a single warning listing all of their due resources
+	notifyOwners(pending, policy)
+}
 
-	// rollback the tag and bail if we're unable to parse the template with the given data
-	if err != nil {
-		reportEvent(fmt.Sprintf("FAILED to parse template for %s (%s), not sending email", resource.FQDN, resource.ID), report.ERROR)
-		log.Errorf("FAILED to parse template.  Rolling back notified_at tag to ''. %s", err.Error())
-		rollBackTag()
-		return err
+// newRenewalLink generates a renewal link for the resource with a token valid for the ttl
+func newRenewalLink(resource *search.Resource, ttl time.Duration) (string, error) {
+	renewalSecret := &RenewalSecret{
+		ResourceID: resource.ID,
+		Action:     RenewAction,
+		RenewedAt:  resource.RenewedAt,
+		Secrets:    AppConfig.RenewalSecrets(),
+		TTL:        ttl,
 	}
 
-	// send the mail to the user notifying them that their instance will expire
-	err = SendMail(AppConfig.Email.Mailserver, body, AppConfig.Email.From, AppConfig.Email.Password,
-		"Please renew your Spinup TryIT server", user.Email, AppConfig.Email.Username)
-
-	// rollback the tag if we fail to send the email
+	token, err := renewalSecret.GenerateRenewalToken()
 	if err != nil {
-		reportEvent(fmt.Sprintf("FAILED to send notification for %s (%s)", resource.FQDN, resource.ID), report.ERROR)
-		log.Errorf("Failed to notify.  Rolling back notified_at tag to ''. %s", err.Error())
-		rollBackTag()
+		return "", err
 	}
 
-	return err
+	return fmt.Sprintf("%s/renew/%s?token=%s", AppConfig.BaseURL, resource.ID, token), nil
 }
 
 // decommission runs the routine to search for resources with renewed_at dates within the decommission age and the destroy age
-func decommission(finder search.Finder) {
-	log.Infoln("Launching Decommissioner...")
+func decommission(finder search.ResourceSource, policy common.Policy) {
+	logger := log.WithField("policy", policy.Name)
+	logger.Infoln("Launching Decommissioner...")
 
-	// Query for anything older than the decommission age with the configured filters and status created
-	termfilter := append(search.NewTermQueryList(AppConfig.Filter), search.TermQuery{Term: "status", Value: "created"})
-	resources, err := finder.DoDateRangeQuery("resources", "server", &search.DateRangeQuery{
-		Field:      "yale:renewed_at",
-		Format:     "YYYY/MM/dd HH:mm:ss",
-		Lte:        fmt.Sprintf("now-%s", AppConfig.Decommission.Age),
-		TermFilter: termfilter,
-	})
+	drq, err := decommissionQuery(policy)
+	if err != nil {
+		logger.Errorln("Invalid filter configuration", err)
+		return
+	}
 
+	resources, err := findResources(finder, drq)
 	if err != nil {
-		log.Errorln("Failed to execute date range query", err)
+		logger.Errorln("Failed to execute date range query", err)
 		return
 	}
 
 	// loop over the returned resources
 	for _, resource := range resources {
-		log.Debugf("Checking returned resource: %+v", resource)
+		logger.Debugf("Checking returned resource: %+v", resource)
+
+		if !inPolicy(resource, policy) {
+			continue
+		}
 
 		if resource.Org == "" {
-			log.Errorf("Cannot operate on a resource without an org.  ID: %s", resource.ID)
+			logger.Errorf("Cannot operate on a resource without an org.  ID: %s", resource.ID)
 			continue
 		}
 
 		// time of the last renewal
 		renewedAt, err := time.Parse("2006/01/02 15:04:05", resource.RenewedAt)
 		if err != nil {
-			log.Errorf("%s Couldn't parse renewed_at (%s) as a time value. %s", resource.ID, resource.RenewedAt, err.Error())
+			logger.Errorf("%s Couldn't parse renewed_at (%s) as a time value. %s", resource.ID, resource.RenewedAt, err.Error())
+			continue
+		}
+		logger.Infof("%s last renewed at %s", resource.ID, renewedAt.String())
+
+		if crossed, err := crossedAge(resource, policy, policy.DecommissionAge, time.Now()); err != nil || !crossed {
+			logger.Debugf("%s hasn't crossed the decommission threshold with its renewal extension, skipping (%v)", resource.ID, err)
+			continue
+		}
+
+		// start of the lifecycle, shifted by the renewal extension
+		start, err := lifecycleStart(resource, policy)
+		if err != nil {
+			logger.Errorf("%s Couldn't get the start of the lifecycle. %s", resource.ID, err.Error())
 			continue
 		}
-		log.Infof("%s last renewed at %s", resource.ID, renewedAt.String())
 
-		destroyAge, err := parseDuration(AppConfig.Destroy.Age)
+		destroyAge, err := parseDuration(policy.DestroyAge)
 		if err != nil {
-			log.Errorf("%s Couldn't parse %s as a duration. %s", resource.ID, AppConfig.Destroy.Age, err.Error())
+			logger.Errorf("%s Couldn't parse %s as a duration. %s", resource.ID, policy.DestroyAge, err.Error())
 			return
 		}
-		// Add the destroy age to the renewed_at date to get the destroy_at date
-		destroyAt := renewedAt.Add(destroyAge)
+		// Add the destroy age to the start of the lifecycle to get the destroy_at date
+		destroyAt := start.Add(destroyAge)
 
 		if destroyAt.Before(time.Now()) {
-			log.Warnf("%s has crossed the destroy threshold but hasn't been decommissioned (Destruction scheduled: %s)", resource.ID, destroyAt.String())
+			logger.Warnf("%s has crossed the destroy threshold but hasn't been decommissioned (Destruction scheduled: %s)", resource.ID, destroyAt.String())
 		}
 
-		log.Infof("%s has crossed the decommision threshold. (Destruction scheduled: %s)", resource.ID, destroyAt.String())
+		logger.Infof("%s has crossed the decommision threshold. (Destruction scheduled: %s)", resource.ID, destroyAt.String())
 
-		reportEvent(fmt.Sprintf("Decommissioning for %s (%s)", resource.FQDN, resource.ID), report.INFO)
+		if reportDryRun(policy, "decommission %s (%s)", resource.FQDN, resource.ID) {
+			continue
+		}
+
+		reportPolicyEvent(policy, fmt.Sprintf("Decommissioning for %s (%s)", resource.FQDN, resource.ID), report.INFO)
 
 		decommer, err := NewDecommissioner(AppConfig.Decommission.Endpoint, AppConfig.Decommission.Token, resource.ID, resource.Org, AppConfig.Decommission.EncryptToken)
 		if err != nil {
-			reportEvent(fmt.Sprintf("FAILED to decommission for %s (%s)", resource.FQDN, resource.ID), report.ERROR)
-			log.Errorf("Unable to decommission %s, %s", resource.ID, err.Error())
+			reportPolicyEvent(policy, fmt.Sprintf("FAILED to decommission for %s (%s)", resource.FQDN, resource.ID), report.ERROR)
+			logger.Errorf("Unable to decommission %s, %s", resource.ID, err.Error())
 			continue
 		}
 
-		decommer.SetStatus()
+		err = decommer.SetStatus()
 		if err != nil {
-			reportEvent(fmt.Sprintf("FAILED to decommission for %s (%s)", resource.FQDN, resource.ID), report.ERROR)
-			log.Errorf("Unable to decommission %s, %s", resource.ID, err.Error())
+			reportPolicyEvent(policy, fmt.Sprintf("FAILED to decommission for %s (%s)", resource.FQDN, resource.ID), report.ERROR)
+			logger.Errorf("Unable to decommission %s, %s", resource.ID, err.Error())
 			continue
 		}
 
 		sendWebhooks(&Event{
 			ID:     resource.ID,
+			Policy: policy.Name,
+			Tags:   resource.Tags,
 			Action: "decommission",
 		})
 
@@ -743,19 +1302,19 @@ func decommission(finder search.Finder) {
 		// if we can't send the email.
 		f, err := NewUserFetcher(AppConfig.UserDatasource)
 		if err != nil {
-			log.Errorf("Unable to configure user datasource for %s: %s", resource.SupportDepartmentContact, err)
+			logger.Errorf("Unable to configure user datasource for %s: %s", resource.SupportDepartmentContact, err)
 			continue
 		}
 		user, err := GetUserByID(f, resource.SupportDepartmentContact)
 		if err != nil {
-			log.Errorf("Unable to get details about user %s: %s", resource.SupportDepartmentContact, err)
+			logger.Errorf("Unable to get details about user %s: %s", resource.SupportDepartmentContact, err)
 			continue
 		}
 
 		// get the date that the instance will expire
-		expireOn, err := GetDecomAt(resource.RenewedAt, AppConfig.Decommission.Age)
+		expireOn, err := resourceDecomAt(resource, policy)
 		if err != nil {
-			log.Errorf("Unable to get the decomAt date for %s: %s", resource.ID, err)
+			logger.Errorf("Unable to get the decomAt date for %s: %s", resource.ID, err)
 			continue
 		}
 
@@ -764,88 +1323,247 @@ func decommission(finder search.Finder) {
 			loc = time.FixedZone("UTC", 0)
 		}
 
+		// the owner can restore the resource with the link until it's destroyed, the decommission leaves renewed_at
+		// unchanged so the token stays valid.  There's no link if the resource can't be renewed again.
+		var restoreLink string
+		if _, err := renewalPolicy(resource, policy, time.Now()); err != nil {
+			logger.Infof("%s can't be restored, not sending a restore link: %s", resource.ID, err)
+		} else if ttl := time.Until(destroyAt); ttl > 0 {
+			if restoreLink, err = newRenewalLink(resource, ttl); err != nil {
+				logger.Errorf("Failed to generate restore token for %s, %s", resource.ID, err)
+			}
+		}
+
 		// generate the decom email
 		body, err := ParseDecomTemplate(map[string]string{
-			"first":     user.First,
-			"email":     user.Email,
-			"netid":     resource.SupportDepartmentContact,
-			"fqdn":      resource.FQDN,
-			"expire_on": expireOn.In(loc).Format("2006/01/02 15:04:05 MST"),
-			"spinupURL": AppConfig.RedirectURL,
-		})
+			"first":      user.First,
+			"email":      user.Email,
+			"netid":      resource.SupportDepartmentContact,
+			"fqdn":       resource.FQDN,
+			"expire_on":  expireOn.In(loc).Format("2006/01/02 15:04:05 MST"),
+			"destroy_on": destroyAt.In(loc).Format("2006/01/02 15:04:05 MST"),
+			"link":       restoreLink,
+			"spinupURL":  AppConfig.RedirectURL,
+		}, resource.Tags)
 
 		if err != nil {
-			log.Errorf("Unable to get the parse the decom template for %s: %s", resource.ID, err)
+			logger.Errorf("Unable to get the parse the decom template for %s: %s", resource.ID, err)
 			continue
 		}
 
-		err = SendMail(AppConfig.Email.Mailserver, body, AppConfig.Email.From, AppConfig.Email.Password,
-			"Your Spinup TryIT server has been deleted", user.Email, AppConfig.Email.Username)
+		err = SendMail(AppConfig.Email, user.Email, "Your Spinup TryIT server has been stopped", body)
 		if err != nil {
-			log.Errorf("Failed sending the decom email: %s", err)
+			logger.Errorf("Failed sending the decom email: %s", err)
 		}
 	}
 }
 
 // destroy runs the routine to search for resources with renewed_at dates beyond the destroy age
-func destroy(finder search.Finder) {
-	log.Infoln("Launching Destroyer...")
+func destroy(finder search.ResourceSource, policy common.Policy) {
+	logger := log.WithField("policy", policy.Name)
+	logger.Infoln("Launching Destroyer...")
 
-	// Query for anything older than the destroy age with the configured filters and status decom
-	termfilter := append(search.NewTermQueryList(AppConfig.Filter), search.TermQuery{Term: "status", Value: "decom"})
-	resources, err := finder.DoDateRangeQuery("resources", "server", &search.DateRangeQuery{
-		Field:      "yale:renewed_at",
-		Format:     "YYYY/MM/dd HH:mm:ss",
-		Lte:        fmt.Sprintf("now-%s", AppConfig.Destroy.Age),
-		TermFilter: termfilter,
-	})
+	drq, err := destroyQuery(policy)
+	if err != nil {
+		logger.Errorln("Invalid filter configuration", err)
+		return
+	}
 
+	resources, err := findResources(finder, drq)
 	if err != nil {
-		log.Errorln("Failed to execute date range query", err)
+		logger.Errorln("Failed to execute date range query", err)
 		return
 	}
 
 	// loop over the returned resources
 	for _, resource := range resources {
-		log.Debugf("Checking returned resource: %+v", resource)
+		logger.Debugf("Checking returned resource: %+v", resource)
+
+		if !inPolicy(resource, policy) {
+			continue
+		}
 
 		if resource.Org == "" {
-			log.Errorf("Cannot operate on a resource without an org.  ID: %s", resource.ID)
+			logger.Errorf("Cannot operate on a resource without an org.  ID: %s", resource.ID)
 			continue
 		}
 
 		// time of the last renewal
 		renewedAt, err := time.Parse("2006/01/02 15:04:05", resource.RenewedAt)
 		if err != nil {
-			log.Errorf("%s Couldn't parse renewed_at (%s) as a time value. %s", resource.ID, resource.RenewedAt, err.Error())
+			logger.Errorf("%s Couldn't parse renewed_at (%s) as a time value. %s", resource.ID, resource.RenewedAt, err.Error())
+			continue
+		}
+
+		logger.Infof("%s last renewed at %s", resource.ID, renewedAt.String())
+
+		if crossed, err := crossedAge(resource, policy, policy.DestroyAge, time.Now()); err != nil || !crossed {
+			logger.Debugf("%s hasn't crossed the destruction threshold with its renewal extension, skipping (%v)", resource.ID, err)
 			continue
 		}
 
-		log.Infof("%s last renewed at %s", resource.ID, renewedAt.String())
-		log.Infof("%s has crossed the destruction threshold.", resource.ID)
+		logger.Infof("%s has crossed the destruction threshold.", resource.ID)
+
+		if reportDryRun(policy, "destroy %s (%s)", resource.FQDN, resource.ID) {
+			continue
+		}
 
-		reportEvent(fmt.Sprintf("Destroying for %s (%s)", resource.FQDN, resource.ID), report.INFO)
+		reportPolicyEvent(policy, fmt.Sprintf("Destroying for %s (%s)", resource.FQDN, resource.ID), report.INFO)
 
 		destroyer, err := NewDestroyer(AppConfig.Destroy.Endpoint, AppConfig.Destroy.Token, resource.ID, resource.Org, AppConfig.Destroy.EncryptToken)
 		if err != nil {
-			reportEvent(fmt.Sprintf("FAILED to destroy for %s (%s)", resource.FQDN, resource.ID), report.ERROR)
-			log.Errorf("Unable to destroy %s, %s", resource.ID, err.Error())
+			reportPolicyEvent(policy, fmt.Sprintf("FAILED to destroy for %s (%s)", resource.FQDN, resource.ID), report.ERROR)
+			logger.Errorf("Unable to destroy %s, %s", resource.ID, err.Error())
 			continue
 		}
 
 		err = destroyer.Destroy()
 		if err != nil {
-			reportEvent(fmt.Sprintf("FAILED to destroy for %s (%s)", resource.FQDN, resource.ID), report.ERROR)
-			log.Errorf("Unable to destroy %s, %s", resource.ID, err.Error())
+			reportPolicyEvent(policy, fmt.Sprintf("FAILED to destroy for %s (%s)", resource.FQDN, resource.ID), report.ERROR)
+			logger.Errorf("Unable to destroy %s, %s", resource.ID, err.Error())
 		}
 
 		sendWebhooks(&Event{
 			ID:     resource.ID,
+			Policy: policy.Name,
+			Tags:   resource.Tags,
 			Action: "destroy",
 		})
 	}
 }
 
+// notifyQuery returns the sorted notification ages of the policy and the query for anything older than the
+// youngest age with the configured filters and status created
+func notifyQuery(policy common.Policy) ([]string, *search.DateRangeQuery, error) {
+	// sort notifier schedule
+	ages := append([]string{}, policy.NotifyAge...)
+	sort.Sort(BySchedule(ages))
+
+	drq, err := lifecycleQuery(policy, "created", ages[0])
+	return ages, drq, err
+}
+
+// decommissionQuery returns the query for anything older than the decommission age with the configured filters and status created
+func decommissionQuery(policy common.Policy) (*search.DateRangeQuery, error) {
+	return lifecycleQuery(policy, "created", policy.DecommissionAge)
+}
+
+// destroyQuery returns the query for anything older than the destroy age with the configured filters and status decom
+func destroyQuery(policy common.Policy) (*search.DateRangeQuery, error) {
+	return lifecycleQuery(policy, "decom", policy.DestroyAge)
+}
+
+// lifecycleQuery returns a date range query for resources in the given status that were renewed longer than age ago.
+// If the policy offers renewal extensions shorter than the decommission age, the query is widened to include the
+// resources that cross the age threshold early because of their extension.
+func lifecycleQuery(policy common.Policy, status, age string) (*search.DateRangeQuery, error) {
+	fields := searchFields()
+	termfilter, err := termFilters(policy, search.TermQuery{Term: fields.Status, Value: status})
+	if err != nil {
+		return nil, err
+	}
+
+	window, err := extensionWindow(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	lte := fmt.Sprintf("now-%s", age)
+	if window > 0 {
+		d, err := parseDuration(age)
+		if err != nil {
+			return nil, err
+		}
+
+		if d -= window; d >= 0 {
+			lte = fmt.Sprintf("now-%ds", int64(d.Seconds()))
+		} else {
+			lte = fmt.Sprintf("now+%ds", int64(-d.Seconds()))
+		}
+	}
+
+	return &search.DateRangeQuery{
+		Field:      fields.RenewedAt,
+		Format:     "YYYY/MM/dd HH:mm:ss",
+		Lte:        lte,
+		TermFilter: termfilter,
+	}, nil
+}
+
+// notifyThreshold ranges over the sorted notification ages and returns the first age threshold that was crossed
+// after the resource was last notified.  If all of the crossed thresholds have been notified, due is false.  The
+// thresholds are relative to the start of the lifecycle, which is the renewed_at date shifted by the renewal extension.
+func notifyThreshold(start, notifiedAt time.Time, ages []string, now time.Time) (age string, thresholdAt time.Time, due bool, err error) {
+	for _, age := range ages {
+		ageDuration, err := parseDuration(age)
+		if err != nil {
+			return "", thresholdAt, false, err
+		}
+
+		// time the age threshold was crossed
+		thresholdAt = start.Add(ageDuration)
+		if thresholdAt.Before(now) && notifiedAt.Before(thresholdAt) {
+			return age, thresholdAt, true, nil
+		}
+	}
+
+	return "", thresholdAt, false, nil
+}
+
+// findResources runs the date range query, paging through the whole result set, and reports
+// an event if fewer resources were processed than the query matched
+func findResources(finder search.ResourceSource, drq *search.DateRangeQuery) ([]*search.Resource, error) {
+	var resources []*search.Resource
+	stats, err := finder.ForEachDateRangeQuery(func(r *search.Resource) error {
+		resources = append(resources, r)
+		return nil
+	}, drq)
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.Processed < stats.TotalHits {
+		msg := fmt.Sprintf("Only processed %d of %d resources matching the query on %s", stats.Processed, stats.TotalHits, drq.Field)
+		log.Warn(msg)
+		reportEvent(msg, report.ERROR)
+	}
+
+	return resources, nil
+}
+
+// reportDryRun logs and reports the action that would have been taken when running in dry run mode.  It
+// returns true if the action should be skipped.
+func reportDryRun(policy common.Policy, format string, args ...interface{}) bool {
+	if !AppConfig.DryRun {
+		return false
+	}
+
+	msg := "DRY RUN would " + fmt.Sprintf(format, args...)
+	log.WithField("policy", policy.Name).Info(msg)
+	reportPolicyEvent(policy, msg, report.INFO)
+	return true
+}
+
+// renewalTokenTTL returns the configured lifetime of renewal tokens
+func renewalTokenTTL() time.Duration {
+	if AppConfig.RenewalTokenTTL == "" {
+		return DefaultRenewalTokenTTL
+	}
+
+	ttl, err := parseDuration(AppConfig.RenewalTokenTTL)
+	if err != nil {
+		log.Errorf("Couldn't parse renewal token ttl %s, using the default. %s", AppConfig.RenewalTokenTTL, err)
+		return DefaultRenewalTokenTTL
+	}
+
+	return ttl
+}
+
+// searchFields returns the configured names of the search document fields
+func searchFields() search.Fields {
+	return search.NewFields(AppConfig.SearchEngine)
+}
+
 // reportEvent loops over all of the configured event reporters and sends the event to those reporters
 func reportEvent(msg string, level report.Level) {
 	e := report.Event{
@@ -877,22 +1595,3 @@ func sendWebhooks(e *Event) {
 		}
 	}
 }
-
-// GetDecomAt centralizes the calculation of a decommission date
-func GetDecomAt(renewedAtString, decomAgeString string) (time.Time, error) {
-	var decomAt time.Time
-
-	// time of the last renewal
-	renewedAt, err := time.Parse("2006/01/02 15:04:05", renewedAtString)
-	if err != nil {
-		return decomAt, err
-	}
-
-	decomAge, err := parseDuration(decomAgeString)
-	if err != nil {
-		return decomAt, err
-	}
-
-	decomAt = renewedAt.Add(decomAge)
-	return decomAt, nil
-}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	report "github.com/YaleSpinup/eventreporter"
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testSource = search.NewMemorySource([]*search.Document{
+	{ID: "i-expired", Source: map[string]interface{}{"yale:org": "fts", "status": "created", "yale:renewed_at": "2019/01/01 00:00:00"}},
+	{ID: "i-fresh", Source: map[string]interface{}{"yale:org": "fts", "status": "created", "yale:renewed_at": "2999/01/01 00:00:00"}},
+	{ID: "i-decom", Source: map[string]interface{}{"yale:org": "fts", "status": "decom", "yale:renewed_at": "2019/01/01 00:00:00"}},
+})
+
+func TestFindResources(t *testing.T) {
+	resources, err := findResources(testSource, &search.DateRangeQuery{
+		Field:      "yale:renewed_at",
+		Format:     "YYYY/MM/dd HH:mm:ss",
+		Lte:        "now-30d",
+		TermFilter: []search.TermQuery{{Term: "status", Value: "created"}},
+	})
+	if err != nil {
+		t.Fatalf("Expected nil error finding resources, got %s", err)
+	}
+
+	if len(resources) != 1 || resources[0].ID != "i-expired" {
+		t.Errorf("Expected to find only i-expired, got %+v", resources)
+	}
+}
+
+type testReporter struct {
+	events []report.Event
+}
+
+func (r *testReporter) Report(e report.Event) error {
+	r.events = append(r.events, e)
+	return nil
+}
+
+func TestDryRun(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	withTestConfig(t, common.Config{
+		DryRun:       true,
+		Notify:       common.Notifier{Age: []string{"23d"}},
+		Decommission: common.Decommissioner{Age: "30d", Endpoint: server.URL + "/decom"},
+		Destroy:      common.Destroyer{Age: "44d", Endpoint: server.URL + "/destroy"},
+		Tagging:      common.Tagging{Endpoint: server.URL + "/tag"},
+		Webhooks:     []common.Webhook{{Endpoint: server.URL + "/hook", Method: http.MethodPost, Actions: []string{"notify", "decommission", "destroy"}}},
+	})
+
+	reporter := &testReporter{}
+	origReporters, origWebhooks := EventReporters, Webhooks
+	EventReporters = []report.Reporter{reporter}
+	Webhooks = nil
+	defer func() { EventReporters, Webhooks = origReporters, origWebhooks }()
+
+	if err := configureWebhooks(); err != nil {
+		t.Fatal(err)
+	}
+
+	source := search.NewMemorySource([]*search.Document{
+		{ID: "i-expired", Source: map[string]interface{}{"yale:org": "fts", "status": "created", "yale:renewed_at": "2019/01/01 00:00:00", "yale:fqdn": "expired.yale.edu", "SupportDepartmentContact": "abc123"}},
+		{ID: "i-decom", Source: map[string]interface{}{"yale:org": "fts", "status": "decom", "yale:renewed_at": "2019/01/01 00:00:00", "yale:fqdn": "decom.yale.edu"}},
+	})
+
+	for _, policy := range policies() {
+		destroy(source, policy)
+		decommission(source, policy)
+		notify(source, policy)
+	}
+
+	if len(calls) > 0 {
+		t.Errorf("Expected no calls in dry run mode, got %v", calls)
+	}
+
+	expected := []string{
+		"[default] DRY RUN would destroy decom.yale.edu (i-decom)",
+		"[default] DRY RUN would decommission expired.yale.edu (i-expired)",
+		"[default] DRY RUN would notify abc123 for expired.yale.edu (i-expired)",
+	}
+
+	var messages []string
+	for _, e := range reporter.events {
+		messages = append(messages, e.Message)
+	}
+
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Expected reported events %v, got %v", expected, messages)
+	}
+}
+
+func TestResourceRenewalHandler(t *testing.T) {
+	var tags map[string]map[string]string
+	var user string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/v1/servers/fts/i-expired/tags" {
+			t.Errorf("Unexpected tagging request %s %s", r.Method, r.URL.Path)
+		}
+
+		user = r.Header.Get("X-Forwarded-User")
+		if err := json.NewDecoder(r.Body).Decode(&tags); err != nil {
+			t.Errorf("Failed to decode tagging request: %s", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	withTestConfig(t, common.Config{
+		Notify:       common.Notifier{Age: []string{"23d"}},
+		Decommission: common.Decommissioner{Age: "30d"},
+		Destroy:      common.Destroyer{Age: "44d"},
+		Tagging:      common.Tagging{Endpoint: server.URL + "/v1/servers"},
+		Token:        "sekret",
+	})
+
+	orig := Finder
+	Finder = testSource
+	defer func() { Finder = orig }()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/reaper/resources/{id}/renew", ResourceRenewalHandler)
+
+	token, err := bcrypt.GenerateFromPassword([]byte("sekret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		id     string
+		token  string
+		user   string
+		code   int
+	}{
+		{http.MethodGet, "i-expired", string(token), "bob", http.StatusBadRequest},
+		{http.MethodPost, "i-expired", "", "bob", http.StatusForbidden},
+		{http.MethodPost, "i-expired", string(token), "", http.StatusBadRequest},
+		{http.MethodPost, "i-missing", string(token), "bob", http.StatusNotFound},
+		{http.MethodPost, "i-expired", string(token), "bob", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/v1/reaper/resources/"+test.id+"/renew", nil)
+		req.Header.Set("X-Auth-Token", test.token)
+		req.Header.Set("X-Forwarded-User", test.user)
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != test.code {
+			t.Errorf("Expected %d for %+v, got %d", test.code, test, rr.Code)
+		}
+	}
+
+	if user != "bob" {
+		t.Errorf("Expected the acting user to be sent to the tagging endpoint, got %s", user)
+	}
+
+	renewedAt, ok := tags["tags"]["yale:renewed_at"]
+	if !ok {
+		t.Fatalf("Expected renewed_at to be tagged, got %+v", tags)
+	}
+
+	if _, err := time.Parse("2006/01/02 15:04:05", renewedAt); err != nil {
+		t.Errorf("Expected renewed_at to be a valid time, got %s", renewedAt)
+	}
+
+	if count := tags["tags"][RenewalCountTag]; count != "1" {
+		t.Errorf("Expected the renewal count to be tagged, got %s", count)
+	}
+
+	// renewing with a shorter extension tags the extension and returns the shortened schedule
+	AppConfig.Renewal = common.Renewer{Extensions: []string{"7d"}}
+	for _, test := range []struct {
+		contentType string
+		body        string
+		code        int
+	}{
+		{"application/json", `{"extension": "3d"}`, http.StatusBadRequest},
+		{"application/json", `{"extension": "7d"}`, http.StatusOK},
+		{"application/json; charset=utf-8", `{"extension": "7d"}`, http.StatusOK},
+	} {
+		body, code := test.body, test.code
+		tags = nil
+		req := httptest.NewRequest(http.MethodPost, "/v1/reaper/resources/i-expired/renew", strings.NewReader(body))
+		req.Header.Set("Content-Type", test.contentType)
+		req.Header.Set("X-Auth-Token", string(token))
+		req.Header.Set("X-Forwarded-User", "bob")
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != code {
+			t.Fatalf("Expected %d renewing with %s (%s), got %d", code, body, test.contentType, rr.Code)
+		}
+
+		if code != http.StatusOK {
+			continue
+		}
+
+		if tags["tags"][RenewalExtensionTag] != "7d" {
+			t.Errorf("Expected the extension to be tagged with %s, got %+v", test.contentType, tags)
+		}
+
+		schedule := Schedule{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &schedule); err != nil {
+			t.Fatalf("Failed to unmarshal schedule: %s", err)
+		}
+
+		if schedule.Extension != "7d" || !schedule.DecommissionAt.Equal(schedule.RenewedAt.Add(7*24*time.Hour)) {
+			t.Errorf("Expected decommission 7 days after renewal, got %+v", schedule)
+		}
+	}
+}
+
+func TestRenewalHander(t *testing.T) {
+	tagged := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tagged++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	withTestConfig(t, common.Config{
+		Notify:           common.Notifier{Age: []string{"23d"}},
+		Decommission:     common.Decommissioner{Age: "30d"},
+		Destroy:          common.Destroyer{Age: "44d"},
+		Tagging:          common.Tagging{Endpoint: server.URL + "/v1/servers"},
+		EncryptionSecret: "sekret",
+	})
+
+	orig := Finder
+	Finder = testSource
+	defer func() { Finder = orig }()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/reaper/renew/{id}", RenewalHander)
+
+	secret := &RenewalSecret{ResourceID: "i-expired", Action: RenewAction, RenewedAt: "2019/01/01 00:00:00", Secrets: []string{"sekret"}}
+	token, err := secret.GenerateRenewalToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the link renders the confirmation page without renewing
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/reaper/renew/i-expired?token="+url.QueryEscape(token), nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected OK for the confirmation page, got %d", rr.Code)
+	}
+
+	if tagged != 0 {
+		t.Errorf("Expected the confirmation page not to renew, got %d tagging requests", tagged)
+	}
+
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName {
+		t.Fatalf("Expected the CSRF cookie to be set, got %+v", cookies)
+	}
+
+	if !strings.Contains(rr.Body.String(), cookies[0].Value) || !strings.Contains(rr.Body.String(), `method="POST"`) {
+		t.Errorf("Expected a form with the CSRF token, got %s", rr.Body.String())
+	}
+
+	post := func(form url.Values, cookie *http.Cookie) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/reaper/renew/i-expired", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := post(url.Values{"token": {token}, csrfFormField: {cookies[0].Value}}, nil); code != http.StatusForbidden {
+		t.Errorf("Expected forbidden without the CSRF cookie, got %d", code)
+	}
+
+	if code := post(url.Values{"token": {"foo"}, csrfFormField: {cookies[0].Value}}, cookies[0]); code != http.StatusForbidden {
+		t.Errorf("Expected forbidden with a bad renewal token, got %d", code)
+	}
+
+	if tagged != 0 {
+		t.Errorf("Expected forbidden requests not to renew, got %d tagging requests", tagged)
+	}
+
+	hook := logtest.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+
+	if code := post(url.Values{"token": {token}, csrfFormField: {cookies[0].Value}}, cookies[0]); code != http.StatusOK {
+		t.Errorf("Expected OK renewing, got %d", code)
+	}
+
+	if tagged != 1 {
+		t.Errorf("Expected one tagging request, got %d", tagged)
+	}
+
+	keyVersionLogs := 0
+	for _, e := range hook.AllEntries() {
+		if strings.Contains(e.Message, "signed with key version 0") {
+			keyVersionLogs++
+		}
+	}
+
+	if keyVersionLogs != 1 {
+		t.Errorf("Expected the key version to be logged once, got %d", keyVersionLogs)
+	}
+
+	if code := post(url.Values{"token": {token}, csrfFormField: {cookies[0].Value}}, cookies[0]); code != http.StatusForbidden {
+		t.Errorf("Expected forbidden replaying the renewal token, got %d", code)
+	}
+
+	if tagged != 1 {
+		t.Errorf("Expected the replayed token not to renew, got %d tagging requests", tagged)
+	}
+}
+
+func TestRenewalLimits(t *testing.T) {
+	tagged := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tagged++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	withTestConfig(t, common.Config{
+		Notify:           common.Notifier{Age: []string{"23d"}},
+		Decommission:     common.Decommissioner{Age: "30d"},
+		Destroy:          common.Destroyer{Age: "44d"},
+		Renewal:          common.Renewer{MaxRenewals: 3},
+		Tagging:          common.Tagging{Endpoint: server.URL + "/v1/servers"},
+		EncryptionSecret: "sekret",
+		Token:            "sekret",
+	})
+
+	orig := Finder
+	Finder = search.NewMemorySource([]*search.Document{
+		{ID: "i-renewed", Source: map[string]interface{}{"yale:org": "fts", "status": "created", "yale:renewed_at": "2019/01/01 00:00:00", "yale:renewal_count": "3"}},
+	})
+	defer func() { Finder = orig }()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/reaper/renew/{id}", RenewalHander)
+	router.HandleFunc("/v1/reaper/resources/{id}/renew", ResourceRenewalHandler)
+
+	secret := &RenewalSecret{ResourceID: "i-renewed", Action: RenewAction, RenewedAt: "2019/01/01 00:00:00", Secrets: []string{"sekret"}}
+	token, err := secret.GenerateRenewalToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/reaper/renew/i-renewed?token="+url.QueryEscape(token), nil))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected forbidden renewing past the renewal limit, got %d", rr.Code)
+	}
+
+	if !strings.Contains(rr.Body.String(), "maximum number of times") || strings.Contains(rr.Body.String(), "<form") {
+		t.Errorf("Expected a page explaining the renewal limit, got %s", rr.Body.String())
+	}
+
+	apiToken, err := bcrypt.GenerateFromPassword([]byte("sekret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/reaper/resources/i-renewed/renew", nil)
+	req.Header.Set("X-Auth-Token", string(apiToken))
+	req.Header.Set("X-Forwarded-User", "bob")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected forbidden renewing past the renewal limit through the API, got %d", rr.Code)
+	}
+
+	if tagged != 0 {
+		t.Errorf("Expected no tagging requests, got %d", tagged)
+	}
+}
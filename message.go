@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Message is an html email message with a generated plain text alternative
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	HTML    string
+
+	// Text is the plain text alternative of the html body, it's generated from the html if empty
+	Text string
+
+	// Date and MessageID default to the time the message is built and a random id at the domain of the sender
+	Date      time.Time
+	MessageID string
+}
+
+// NewMessage creates a new message with an html body
+func NewMessage(from, to, subject, body string) *Message {
+	return &Message{
+		From:    from,
+		To:      to,
+		Subject: subject,
+		HTML:    body,
+	}
+}
+
+// Bytes builds the message as a multipart/alternative MIME message with CRLF line endings, encoding the subject and
+// the bodies so they survive non-ASCII text
+func (m *Message) Bytes() ([]byte, error) {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address %s: %s", m.From, err)
+	}
+
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to address %s: %s", m.To, err)
+	}
+
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	messageID := m.MessageID
+	if messageID == "" {
+		if messageID, err = newMessageID(from.Address); err != nil {
+			return nil, err
+		}
+	}
+
+	text := m.Text
+	if text == "" {
+		text = htmlToText(m.HTML)
+	}
+
+	buffer := new(bytes.Buffer)
+	w := multipart.NewWriter(buffer)
+
+	for _, header := range []struct{ key, value string }{
+		{"From", from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("UTF-8", headerValue(m.Subject))},
+		{"Date", date.Format(time.RFC1123Z)},
+		{"Message-ID", messageID},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", w.Boundary())},
+	} {
+		fmt.Fprintf(buffer, "%s: %s\r\n", header.key, header.value)
+	}
+	buffer.WriteString("\r\n")
+
+	// the last part is the preferred alternative
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", text},
+		{"text/html", m.HTML},
+	} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=UTF-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := qw.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+
+		if err := qw.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// newMessageID generates a random message id at the domain of the address, falling back to the hostname
+func newMessageID(address string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	domain := address[strings.LastIndex(address, "@")+1:]
+	if domain == "" || domain == address {
+		if domain, _ = os.Hostname(); domain == "" {
+			domain = "localhost"
+		}
+	}
+
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b), domain), nil
+}
+
+// headerValue strips line breaks from a header value so it can't inject headers
+func headerValue(v string) string {
+	return strings.Join(strings.Fields(strings.NewReplacer("\r", " ", "\n", " ").Replace(v)), " ")
+}
+
+var (
+	htmlHeadRe   = regexp.MustCompile(`(?is)<(head|style|script)[^>]*>.*?</(head|style|script)>`)
+	htmlLinkRe   = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	htmlBreakRe  = regexp.MustCompile(`(?i)<br\s*/?>`)
+	htmlBlockRe  = regexp.MustCompile(`(?i)</?(p|div|ul|ol|h[1-6]|table|tr)[^>]*>`)
+	htmlItemRe   = regexp.MustCompile(`(?i)<li[^>]*>`)
+	htmlTagRe    = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLinesRe = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText generates the plain text version of an html email body.  Links are written out after their text,
+// paragraphs are separated by blank lines and list items become bullets.
+func htmlToText(body string) string {
+	text := htmlHeadRe.ReplaceAllString(body, "")
+	text = strings.NewReplacer("\r", "", "\n", " ", "\t", " ").Replace(text)
+	text = htmlLinkRe.ReplaceAllStringFunc(text, func(a string) string {
+		m := htmlLinkRe.FindStringSubmatch(a)
+		href, label := m[1], strings.TrimSpace(htmlTagRe.ReplaceAllString(m[2], ""))
+		if label == "" || label == href {
+			return href
+		}
+		return fmt.Sprintf("%s (%s)", label, href)
+	})
+	text = htmlBreakRe.ReplaceAllString(text, "\n")
+	text = htmlItemRe.ReplaceAllString(text, "\n* ")
+	text = htmlBlockRe.ReplaceAllString(text, "\n\n")
+	text = html.UnescapeString(htmlTagRe.ReplaceAllString(text, ""))
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+
+	return strings.TrimSpace(blankLinesRe.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")) + "\n"
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageBytes(t *testing.T) {
+	m := NewMessage("reaper@example.com", "bob@example.com", "Renouvelez votre serveur ☃", "<p>Hello <b>bob</b> &amp; friends</p>")
+	m.Date = time.Date(2019, time.January, 14, 15, 32, 24, 0, time.UTC)
+
+	out, err := m.Bytes()
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if bytes.Contains(bytes.ReplaceAll(out, []byte("\r\n"), nil), []byte("\n")) {
+		t.Errorf("Expected CRLF line endings, got %q", out)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("Failed to read message: %s", err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != m.Subject {
+		t.Errorf("Expected subject %s, got %s (%v)", m.Subject, subject, err)
+	}
+
+	if strings.Contains(msg.Header.Get("Subject"), "☃") {
+		t.Errorf("Expected the subject to be encoded, got %s", msg.Header.Get("Subject"))
+	}
+
+	if date, err := msg.Header.Date(); err != nil || !date.Equal(m.Date) {
+		t.Errorf("Expected date %s, got %s (%v)", m.Date, date, err)
+	}
+
+	if id := msg.Header.Get("Message-ID"); !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("Expected a message id at example.com, got %s", id)
+	}
+
+	if msg.Header.Get("MIME-Version") != "1.0" || msg.Header.Get("From") != "<reaper@example.com>" || msg.Header.Get("To") != "<bob@example.com>" {
+		t.Errorf("Unexpected headers %+v", msg.Header)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Expected multipart/alternative, got %s (%v)", mediaType, err)
+	}
+
+	var parts []string
+	var bodies []string
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatalf("Failed to read part: %s", err)
+		}
+
+		body, _ := io.ReadAll(p)
+		parts = append(parts, p.Header.Get("Content-Type"))
+		bodies = append(bodies, string(body))
+	}
+
+	if len(parts) != 2 || parts[0] != "text/plain; charset=UTF-8" || parts[1] != "text/html; charset=UTF-8" {
+		t.Fatalf("Expected plain text and html parts, got %v", parts)
+	}
+
+	if bodies[0] != "Hello bob & friends\r\n" {
+		t.Errorf("Expected the generated plain text, got %q", bodies[0])
+	}
+
+	if bodies[1] != m.HTML {
+		t.Errorf("Expected the html body, got %q", bodies[1])
+	}
+}
+
+func TestMessageBytesHeaders(t *testing.T) {
+	m := NewMessage("reaper@example.com", "bob@example.com", "Renew\r\nBcc: eve@example.com", "<p>Hi</p>")
+	m.MessageID = "<123@example.com>"
+	m.Text = "Hi there"
+
+	out, err := m.Bytes()
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("Failed to read message: %s", err)
+	}
+
+	if msg.Header.Get("Bcc") != "" || msg.Header.Get("Subject") != "Renew Bcc: eve@example.com" {
+		t.Errorf("Expected the line breaks to be stripped from the subject, got %+v", msg.Header)
+	}
+
+	if msg.Header.Get("Message-ID") != "<123@example.com>" || !bytes.Contains(out, []byte("Hi there")) {
+		t.Errorf("Expected the given message id and text, got %s", out)
+	}
+
+	for _, m := range []*Message{
+		NewMessage("reaper", "bob@example.com", "", ""),
+		NewMessage("reaper@example.com", "bob", "", ""),
+	} {
+		if _, err := m.Bytes(); err == nil {
+			t.Errorf("Expected error for invalid address in %+v, got nil", m)
+		}
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	out, err := ParseWarningTemplate(testEamilParams, testEmailTags)
+	if err != nil {
+		t.Fatal("Failed to parse warning template", err)
+	}
+
+	expected := `Hello bob,
+
+Your Spinup TryIT server foo.bar.yale.edu will expire on 2018/01/14 15:32:24. If you would like to keep it, please renew it from the Spinup interface or by clicking the following link (this e-mail's link is one-time use):
+
+https://127.0.0.1:8888/v1/renew
+
+Cheers,
+Spinup Team
+http://127.0.0.1:8888/spinup
+http://127.0.0.1:8888/spinup
+`
+	if text := htmlToText(out); text != expected {
+		t.Errorf("Expected text:\n%s\ngot:\n%s", expected, text)
+	}
+
+	if text := htmlToText(`<ul><li>one <a href="https://example.com">renew</a></li><li>two</li></ul>`); text != "* one renew (https://example.com)\n* two\n" {
+		t.Errorf("Expected list items with links, got %q", text)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultSlackEndpoint is the slack web api endpoint
+const DefaultSlackEndpoint = "https://slack.com/api"
+
+// Notification is a message to the owner of one or more resources
+type Notification struct {
+	Action  string
+	Policy  string
+	Subject string
+	User    *User
+	Items   []*NotificationItem
+
+	// Body is the html body of the email, Text is the plain text message (without the links) sent over other channels
+	Body string
+	Text string
+}
+
+// NotificationItem is a resource listed in a notification
+type NotificationItem struct {
+	Resource          *search.Resource
+	Link              string
+	ExpireOn          string
+	RenewedAt         string
+	RemainingRenewals string
+	RenewalLimit      string
+}
+
+// Notifier sends notifications to the owner of a resource over a channel
+type Notifier interface {
+	Name() string
+	Notify(n *Notification) error
+}
+
+// sharedNotifier is implemented by the channels that post to a place other people can read.  They don't get the
+// renewal links and don't count towards notifying the owner.
+type sharedNotifier interface {
+	Shared() bool
+}
+
+// isShared returns true if the channel posts to a place other people can read
+func isShared(n Notifier) bool {
+	s, ok := n.(sharedNotifier)
+	return ok && s.Shared()
+}
+
+// NewNotifier creates a notification channel from its type and configuration
+func NewNotifier(name string, config map[string]string) (Notifier, error) {
+	log.Debugf("Creating a new %s notifier", name)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch name {
+	case "email":
+		return EmailNotifier{Config: AppConfig.Email}, nil
+	case "http":
+		endpoint, token := AppConfig.Notify.Endpoint, AppConfig.Notify.Token
+		if e, ok := config["endpoint"]; ok && e != "" {
+			endpoint = e
+		}
+
+		if t, ok := config["token"]; ok && t != "" {
+			token = t
+		}
+
+		if endpoint == "" {
+			return nil, fmt.Errorf("endpoint required and not found in the http notifier configuration")
+		}
+
+		return HTTPNotifier{
+			Endpoint:     endpoint,
+			Token:        token,
+			EncryptToken: AppConfig.Notify.EncryptToken || config["encryptToken"] == "true",
+			Client:       client,
+		}, nil
+	case "slack":
+		if config["token"] == "" {
+			return nil, fmt.Errorf("token required and not found in the slack notifier configuration")
+		}
+
+		endpoint := DefaultSlackEndpoint
+		if e, ok := config["endpoint"]; ok && e != "" {
+			endpoint = e
+		}
+
+		return SlackNotifier{Endpoint: endpoint, Token: config["token"], Client: client}, nil
+	case "teams":
+		if config["webhook"] == "" {
+			return nil, fmt.Errorf("webhook required and not found in the teams notifier configuration")
+		}
+
+		return TeamsNotifier{Webhook: config["webhook"], Client: client}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown notifier name, %s", name)
+}
+
+// EmailNotifier sends notifications by email
+type EmailNotifier struct {
+	Config common.Emailer
+}
+
+// Name returns the name of the channel
+func (e EmailNotifier) Name() string {
+	return "email"
+}
+
+// Notify sends the html body of the notification to the email address of the owner
+func (e EmailNotifier) Notify(n *Notification) error {
+	return SendMail(e.Config, n.User.Email, n.Subject, n.Body)
+}
+
+// HTTPNotifier sends notifications to an http endpoint
+type HTTPNotifier struct {
+	Endpoint     string
+	Token        string
+	EncryptToken bool
+	Client       HTTPClient
+}
+
+// Name returns the name of the channel
+func (h HTTPNotifier) Name() string {
+	return "http"
+}
+
+// Notify POSTs the notification as JSON to the endpoint
+func (h HTTPNotifier) Notify(n *Notification) error {
+	type resource struct {
+		ID       string            `json:"id"`
+		FQDN     string            `json:"fqdn"`
+		Link     string            `json:"link,omitempty"`
+		ExpireOn string            `json:"expire_on"`
+		Tags     map[string]string `json:"tags,omitempty"`
+	}
+
+	resources := make([]resource, 0, len(n.Items))
+	for _, item := range n.Items {
+		resources = append(resources, resource{
+			ID:       item.Resource.ID,
+			FQDN:     item.Resource.FQDN,
+			Link:     item.Link,
+			ExpireOn: item.ExpireOn,
+			Tags:     item.Resource.Tags,
+		})
+	}
+
+	data, err := json.Marshal(struct {
+		Action    string     `json:"action"`
+		Policy    string     `json:"policy,omitempty"`
+		NetID     string     `json:"netid"`
+		Email     string     `json:"email"`
+		Subject   string     `json:"subject"`
+		Text      string     `json:"text"`
+		Resources []resource `json:"resources"`
+	}{
+		Action:    n.Action,
+		Policy:    n.Policy,
+		NetID:     n.owner(),
+		Email:     n.User.Email,
+		Subject:   n.Subject,
+		Text:      n.Text,
+		Resources: resources,
+	})
+	if err != nil {
+		return err
+	}
+
+	token := h.Token
+	if h.EncryptToken {
+		t, err := bcrypt.GenerateFromPassword([]byte(h.Token), 4)
+		if err != nil {
+			return fmt.Errorf("failed to bcrypt from password: %s", err)
+		}
+		token = string(t)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("X-Forwarded-User", "reaper")
+	req.Header.Set("X-Auth-Token", token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode > 299 {
+		return fmt.Errorf("Got a non-success http response from http POST to %s, %d", h.Endpoint, res.StatusCode)
+	}
+
+	return nil
+}
+
+// SlackNotifier sends notifications as a direct message from a slack app, the owner is looked up by email address
+type SlackNotifier struct {
+	Endpoint string
+	Token    string
+	Client   HTTPClient
+}
+
+// slackResponse is the common part of the slack web api responses
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+	User  struct {
+		ID string `json:"id"`
+	} `json:"user"`
+}
+
+// Name returns the name of the channel
+func (s SlackNotifier) Name() string {
+	return "slack"
+}
+
+// Notify looks up the slack user by the email address of the owner and sends them the text of the notification
+// along with the renewal links
+func (s SlackNotifier) Notify(n *Notification) error {
+	req, err := http.NewRequest(http.MethodGet, s.Endpoint+"/users.lookupByEmail?email="+url.QueryEscape(n.User.Email), nil)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.call(req)
+	if err != nil {
+		return fmt.Errorf("failed to look up slack user %s: %s", n.User.Email, err)
+	}
+
+	text := n.Text
+	if len(n.Items) == 1 {
+		if link := n.Items[0].Link; link != "" {
+			text = fmt.Sprintf("%s\n<%s|Renew it>", text, link)
+		}
+	} else {
+		for _, item := range n.Items {
+			text = fmt.Sprintf("%s\n• %s", text, itemText(item))
+			if item.Link != "" {
+				text = fmt.Sprintf("%s <%s|Renew it>", text, item.Link)
+			}
+		}
+	}
+
+	data, err := json.Marshal(struct {
+		Channel string `json:"channel"`
+		Text    string `json:"text"`
+	}{
+		Channel: user.User.ID,
+		Text:    text,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err = http.NewRequest(http.MethodPost, s.Endpoint+"/chat.postMessage", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	if _, err := s.call(req); err != nil {
+		return fmt.Errorf("failed to send slack message to %s: %s", n.User.Email, err)
+	}
+
+	return nil
+}
+
+// call makes a slack web api request, slack responds with an ok field rather than an http error
+func (s SlackNotifier) call(req *http.Request) (*slackResponse, error) {
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode > 299 {
+		return nil, fmt.Errorf("Got a non-success http response from %s, %d", req.URL.Path, res.StatusCode)
+	}
+
+	out := &slackResponse{}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return nil, err
+	}
+
+	if !out.OK {
+		return nil, fmt.Errorf("slack error %s", out.Error)
+	}
+
+	return out, nil
+}
+
+// TeamsNotifier sends notifications to a Microsoft Teams incoming webhook
+type TeamsNotifier struct {
+	Webhook string
+	Client  HTTPClient
+}
+
+// Name returns the name of the channel
+func (t TeamsNotifier) Name() string {
+	return "teams"
+}
+
+// Shared returns true since the webhook posts to a channel other people can read
+func (t TeamsNotifier) Shared() bool {
+	return true
+}
+
+// Notify POSTs the notification as a message card to the webhook.  The webhook posts to a shared channel, so the card
+// leaves out the owner's email address and the one-time renewal links, which are only sent to the owner.
+func (t TeamsNotifier) Notify(n *Notification) error {
+	card := struct {
+		Type    string `json:"@type"`
+		Context string `json:"@context"`
+		Summary string `json:"summary"`
+		Title   string `json:"title"`
+		Text    string `json:"text"`
+	}{
+		Type:    "MessageCard",
+		Context: "https://schema.org/extensions",
+		Summary: n.Subject,
+		Title:   n.Subject,
+		Text:    n.Text,
+	}
+
+	if len(n.Items) > 1 {
+		for _, item := range n.Items {
+			card.Text = fmt.Sprintf("%s\n\n- %s", card.Text, itemText(item))
+		}
+	}
+
+	data, err := json.Marshal(card)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.Webhook, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := t.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode > 299 {
+		return fmt.Errorf("Got a non-success http response from the teams webhook, %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// owner returns the netid of the owner of the resources in the notification
+func (n *Notification) owner() string {
+	if len(n.Items) == 0 {
+		return ""
+	}
+	return n.Items[0].Resource.SupportDepartmentContact
+}
+
+// itemText returns the plain text line for a resource listed in a digest
+func itemText(item *NotificationItem) string {
+	text := fmt.Sprintf("%s will expire on %s.", item.Resource.FQDN, item.ExpireOn)
+	switch {
+	case item.RenewalLimit != "":
+		text = fmt.Sprintf("%s %s", text, item.RenewalLimit)
+	case item.RemainingRenewals != "":
+		text = fmt.Sprintf("%s It can be renewed %s more time(s).", text, item.RemainingRenewals)
+	}
+	return text
+}
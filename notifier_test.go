@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+)
+
+var testNotification = &Notification{
+	Action:  "notify",
+	Policy:  "tryit",
+	Subject: "Please renew your Spinup TryIT server",
+	User:    &User{First: "Bob", Email: "bob@example.com"},
+	Items: []*NotificationItem{
+		{
+			Resource: &search.Resource{ID: "i-123", FQDN: "foo.bar.yale.edu", SupportDepartmentContact: "abc123", Tags: map[string]string{"yale:project": "moonshot"}},
+			Link:     "https://reaper.example.com/v1/reaper/renew/i-123?token=abc",
+			ExpireOn: "2019/01/31 00:00:00 EST",
+		},
+	},
+	Body: "<html></html>",
+	Text: "Your Spinup TryIT server foo.bar.yale.edu will expire on 2019/01/31 00:00:00 EST.",
+}
+
+var testDigest = &Notification{
+	Action:  "notify",
+	Policy:  "tryit",
+	Subject: "Please renew your Spinup TryIT servers",
+	User:    &User{First: "Bob", Email: "bob@example.com"},
+	Items: []*NotificationItem{
+		testNotification.Items[0],
+		{
+			Resource:     &search.Resource{ID: "i-456", FQDN: "baz.bar.yale.edu", SupportDepartmentContact: "abc123"},
+			ExpireOn:     "2019/02/01 00:00:00 EST",
+			RenewalLimit: "It has already been renewed the maximum number of times.",
+		},
+	},
+	Body: "<html></html>",
+	Text: "You have 2 Spinup TryIT servers that will expire soon.",
+}
+
+func TestNewNotifier(t *testing.T) {
+	withTestConfig(t, common.Config{
+		Email:  common.Emailer{Mailserver: "mail.example.com:25", From: "reaper@example.com"},
+		Notify: common.Notifier{Endpoint: "http://127.0.0.1/notify", Token: "sekret"},
+	})
+
+	tests := []struct {
+		name   string
+		config map[string]string
+		err    bool
+	}{
+		{"email", nil, false},
+		{"http", nil, false},
+		{"slack", map[string]string{"token": "xoxb-123"}, false},
+		{"slack", nil, true},
+		{"teams", map[string]string{"webhook": "https://example.webhook.office.com/123"}, false},
+		{"teams", nil, true},
+		{"pigeon", nil, true},
+	}
+
+	for _, test := range tests {
+		n, err := NewNotifier(test.name, test.config)
+		if test.err != (err != nil) {
+			t.Errorf("Expected error %t for %s notifier, got %v", test.err, test.name, err)
+			continue
+		}
+
+		if err == nil && n.Name() != test.name {
+			t.Errorf("Expected %s notifier, got %s", test.name, n.Name())
+		}
+	}
+
+	n, err := NewNotifier("http", map[string]string{"endpoint": "http://127.0.0.1/other"})
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if h := n.(HTTPNotifier); h.Endpoint != "http://127.0.0.1/other" || h.Token != "sekret" {
+		t.Errorf("Expected the channel config to override the notify endpoint, got %+v", h)
+	}
+
+	AppConfig.Notify.Endpoint = ""
+	if _, err := NewNotifier("http", nil); err == nil {
+		t.Error("Expected error for http notifier without an endpoint, got nil")
+	}
+}
+
+func TestHTTPNotifier(t *testing.T) {
+	var body map[string]interface{}
+	var token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("X-Auth-Token")
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := HTTPNotifier{Endpoint: server.URL, Token: "sekret", Client: server.Client()}
+	if err := n.Notify(testNotification); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if token != "sekret" {
+		t.Errorf("Expected the token to be sent, got %s", token)
+	}
+
+	resources, _ := body["resources"].([]interface{})
+	if len(resources) != 1 || body["email"] != "bob@example.com" || body["netid"] != "abc123" || body["action"] != "notify" {
+		t.Fatalf("Unexpected notification body %+v", body)
+	}
+
+	if r := resources[0].(map[string]interface{}); r["id"] != "i-123" || r["link"] != testNotification.Items[0].Link {
+		t.Errorf("Unexpected notification resource %+v", r)
+	}
+
+	if err := n.Notify(testDigest); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if resources, _ := body["resources"].([]interface{}); len(resources) != 2 {
+		t.Errorf("Expected both resources in the digest, got %+v", body["resources"])
+	}
+
+	n.Endpoint = server.URL + "/missing"
+	n.Client = NewMockClient([]byte("fail"), 500)
+	if err := n.Notify(testNotification); err == nil {
+		t.Error("Expected error for failed notification, got nil")
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	var message map[string]string
+	lookupOK := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xoxb-123" {
+			t.Errorf("Expected the bot token, got %s", r.Header.Get("Authorization"))
+		}
+
+		switch r.URL.Path {
+		case "/users.lookupByEmail":
+			if r.URL.Query().Get("email") != "bob@example.com" {
+				t.Errorf("Expected the user to be looked up by email, got %s", r.URL.RawQuery)
+			}
+
+			if !lookupOK {
+				w.Write([]byte(`{"ok": false, "error": "users_not_found"}`))
+				return
+			}
+			w.Write([]byte(`{"ok": true, "user": {"id": "U123"}}`))
+		case "/chat.postMessage":
+			json.NewDecoder(r.Body).Decode(&message)
+			w.Write([]byte(`{"ok": true}`))
+		default:
+			t.Errorf("Unexpected slack request %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	n := SlackNotifier{Endpoint: server.URL, Token: "xoxb-123", Client: server.Client()}
+	if err := n.Notify(testNotification); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if message["channel"] != "U123" || !strings.Contains(message["text"], testNotification.Text) || !strings.Contains(message["text"], testNotification.Items[0].Link) {
+		t.Errorf("Expected a direct message with the text and link, got %+v", message)
+	}
+
+	if err := n.Notify(testDigest); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if text := message["text"]; !strings.Contains(text, "• foo.bar.yale.edu will expire on 2019/01/31 00:00:00 EST. <"+testNotification.Items[0].Link+"|Renew it>") ||
+		!strings.Contains(text, "• baz.bar.yale.edu will expire on 2019/02/01 00:00:00 EST. It has already been renewed the maximum number of times.") {
+		t.Errorf("Expected a line for each resource in the digest, got %s", text)
+	}
+
+	lookupOK = false
+	if err := n.Notify(testNotification); err == nil || !strings.Contains(err.Error(), "users_not_found") {
+		t.Errorf("Expected the slack error, got %v", err)
+	}
+}
+
+func TestTeamsNotifier(t *testing.T) {
+	var card map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&card)
+		w.Write([]byte("1"))
+	}))
+	defer server.Close()
+
+	n := TeamsNotifier{Webhook: server.URL, Client: server.Client()}
+	if err := n.Notify(testNotification); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	// the card goes to a shared channel, so it leaves out the owner and the renewal links
+	text, _ := card["text"].(string)
+	if card["@type"] != "MessageCard" || !strings.Contains(text, testNotification.Text) || strings.Contains(text, "bob@example.com") || strings.Contains(text, "token=") {
+		t.Errorf("Unexpected message card %+v", card)
+	}
+
+	if _, ok := card["potentialAction"]; ok {
+		t.Errorf("Expected no renew action, got %+v", card["potentialAction"])
+	}
+
+	card = nil
+	if err := n.Notify(testDigest); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	text, _ = card["text"].(string)
+	if !strings.Contains(text, "foo.bar.yale.edu") || !strings.Contains(text, "baz.bar.yale.edu") || strings.Contains(text, "token=") {
+		t.Errorf("Expected the digest to list each resource without its link, got %+v", card)
+	}
+}
+
+func TestSendNotificationChannels(t *testing.T) {
+	var notifiedAt []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/users/"):
+			w.Write([]byte(`{"First": "Bob", "Email": "bob@example.com"}`))
+		case strings.HasSuffix(r.URL.Path, "/tags"):
+			var tags map[string]map[string]string
+			json.NewDecoder(r.Body).Decode(&tags)
+			notifiedAt = append(notifiedAt, tags["tags"]["yale:notified_at"])
+		case r.URL.Path == "/notify", r.URL.Path == "/teams-ok":
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	withTestConfig(t, common.Config{
+		Notify: common.Notifier{
+			Age:      []string{"23d"},
+			Endpoint: server.URL + "/notify",
+			Channels: map[string]map[string]string{
+				"http":  {},
+				"teams": {"webhook": server.URL + "/teams"},
+			},
+		},
+		Decommission:   common.Decommissioner{Age: "30d"},
+		Tagging:        common.Tagging{Endpoint: server.URL + "/v1/servers"},
+		UserDatasource: map[string]string{"type": "rest", "endpoint": server.URL + "/users", "token": "sekret"},
+	})
+
+	origNotifiers := Notifiers
+	Notifiers = nil
+	defer func() { Notifiers = origNotifiers }()
+
+	if err := configureNotifiers(); err != nil {
+		t.Fatalf("Expected nil error configuring notifiers, got %s", err)
+	}
+
+	if len(Notifiers) != 2 || Notifiers[0].Name() != "http" || Notifiers[1].Name() != "teams" {
+		t.Fatalf("Expected the http and teams notifiers, got %+v", Notifiers)
+	}
+
+	resource := &search.Resource{ID: "i-123", Org: "fts", FQDN: "foo.bar.yale.edu", SupportDepartmentContact: "abc123", RenewedAt: "2019/01/01 00:00:00"}
+	policy := common.Policy{Name: "default", DecommissionAge: "30d"}
+	due := []*dueResource{{Resource: resource, Link: "https://reaper.example.com/renew", RenewedAt: time.Now()}}
+
+	// the owner is notified if any of the channels succeed
+	if _, err := sendNotification(due, policy); err != nil {
+		t.Errorf("Expected nil error when one channel succeeds, got %s", err)
+	}
+
+	if len(notifiedAt) != 1 {
+		t.Errorf("Expected notified_at to be tagged without a rollback, got %v", notifiedAt)
+	}
+
+	// the notified_at tag is rolled back if all of the channels fail
+	notifiedAt = nil
+	Notifiers = Notifiers[1:]
+	if _, err := sendNotification(due, policy); err == nil {
+		t.Error("Expected error when every channel fails, got nil")
+	}
+
+	if len(notifiedAt) != 2 || notifiedAt[1] != "" {
+		t.Errorf("Expected notified_at to be rolled back, got %v", notifiedAt)
+	}
+
+	// a shared channel doesn't reach the owner, so it doesn't count
+	notifiedAt = nil
+	Notifiers = []Notifier{TeamsNotifier{Webhook: server.URL + "/teams-ok", Client: server.Client()}}
+	if _, err := sendNotification(due, policy); err == nil {
+		t.Error("Expected error when only a shared channel succeeds, got nil")
+	}
+
+	if len(notifiedAt) != 2 || notifiedAt[1] != "" {
+		t.Errorf("Expected notified_at to be rolled back, got %v", notifiedAt)
+	}
+
+	// a shared channel can't be the only one configured
+	AppConfig.Notify.Channels = map[string]map[string]string{"teams": {"webhook": server.URL + "/teams"}}
+	if err := configureNotifiers(); err == nil {
+		t.Error("Expected error configuring only a shared channel, got nil")
+	}
+}
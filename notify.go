@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	report "github.com/YaleSpinup/eventreporter"
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+	log "github.com/sirupsen/logrus"
+)
+
+// dueResource is a resource that is due for a warning, along with its renewal link
+type dueResource struct {
+	Resource  *search.Resource
+	Link      string
+	RenewedAt time.Time
+}
+
+// groupByOwner groups the due resources by their owner, keeping the order the owners and resources were found in
+func groupByOwner(due []*dueResource) [][]*dueResource {
+	var groups [][]*dueResource
+	index := map[string]int{}
+	for _, d := range due {
+		owner := d.Resource.SupportDepartmentContact
+		i, ok := index[owner]
+		if !ok {
+			i = len(groups)
+			index[owner] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], d)
+	}
+	return groups
+}
+
+// notifyOwners sends each owner a single warning listing all of their due resources, then sends the notify webhook
+// for each resource the owner was notified about
+func notifyOwners(due []*dueResource, policy common.Policy) {
+	logger := log.WithField("policy", policy.Name)
+
+	for _, group := range groupByOwner(due) {
+		notified, err := sendNotification(group, policy)
+		if err != nil {
+			logger.Errorf("Failed to notify. %s", err.Error())
+		}
+
+		for _, resource := range notified {
+			sendWebhooks(&Event{
+				ID:     resource.ID,
+				Policy: policy.Name,
+				Tags:   resource.Tags,
+				Action: "notify",
+			})
+		}
+	}
+}
+
+// sendNotification warns the owner of the due resources that they will expire.  A single resource gets the warning
+// template, several get a digest listing each of them.  Each resource is tagged with the new notification date first,
+// resources that can't be tagged are left out of the warning, and the tags are rolled back if the warning can't be sent
+// over any channel.  The resources the owner was notified about are returned.
+func sendNotification(due []*dueResource, policy common.Policy) ([]*search.Resource, error) {
+	logger := log.WithField("policy", policy.Name)
+
+	if len(due) == 0 {
+		return nil, nil
+	}
+	owner := due[0].Resource.SupportDepartmentContact
+
+	// try to get details about the user before we do _anything_ since it's the lightest touch
+	f, err := NewUserFetcher(AppConfig.UserDatasource)
+	if err != nil {
+		msg := fmt.Sprintf("Unable to configure user datasource for %s", owner)
+		logger.Error(msg + ": " + err.Error())
+		reportPolicyEvent(policy, "FAILED "+msg, report.ERROR)
+		return nil, err
+	}
+	user, err := GetUserByID(f, owner)
+	if err != nil {
+		msg := fmt.Sprintf("Unable to get details about user %s", owner)
+		logger.Error(msg + ": " + err.Error())
+		reportPolicyEvent(policy, "FAILED "+msg, report.ERROR)
+		return nil, err
+	}
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		loc = time.FixedZone("UTC", 0)
+	}
+
+	var items []*NotificationItem
+	var resources []*search.Resource
+	var rollBacks []func()
+	for _, d := range due {
+		item, rollBack, err := tagNotified(d, policy, loc)
+		if err != nil {
+			continue
+		}
+
+		items = append(items, item)
+		resources = append(resources, d.Resource)
+		rollBacks = append(rollBacks, rollBack)
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("none of the resources owned by %s could be tagged, not notifying", owner)
+	}
+
+	// rolls back the tags of all of the resources in the warning
+	rollBackTags := func() {
+		for _, rollBack := range rollBacks {
+			rollBack()
+		}
+	}
+
+	params := map[string]string{
+		"first":         user.First,
+		"email":         user.Email,
+		"netid":         owner,
+		"spinupURL":     AppConfig.RedirectURL,
+		"spinupSiteURL": AppConfig.SpinupSiteURL,
+	}
+
+	notification := &Notification{
+		Action: "notify",
+		Policy: policy.Name,
+		User:   user,
+		Items:  items,
+	}
+
+	var body string
+	if len(items) == 1 {
+		item := items[0]
+		reportPolicyEvent(policy, fmt.Sprintf("Notifying %s for %s (%s)", owner, item.Resource.FQDN, item.Resource.ID), report.INFO)
+
+		params["link"] = item.Link
+		params["expire_on"] = item.ExpireOn
+		params["renewed_at"] = item.RenewedAt
+		params["fqdn"] = item.Resource.FQDN
+		params["remaining_renewals"] = item.RemainingRenewals
+		params["renewal_limit"] = item.RenewalLimit
+
+		// generate the warning email from the warning template
+		body, err = ParseWarningTemplate(params, item.Resource.Tags)
+		notification.Subject = "Please renew your Spinup TryIT server"
+		notification.Text = warningText(params)
+	} else {
+		reportPolicyEvent(policy, fmt.Sprintf("Notifying %s for %d resources (%s)", owner, len(items), strings.Join(resourceIDs(resources), ", ")), report.INFO)
+
+		// generate the digest email from the digest template
+		body, err = ParseDigestTemplate(params, items)
+		notification.Subject = "Please renew your Spinup TryIT servers"
+		notification.Text = fmt.Sprintf("You have %d Spinup TryIT servers that will expire soon.  If you would like to keep them, please renew them.", len(items))
+	}
+
+	// rollback the tags and bail if we're unable to parse the template with the given data
+	if err != nil {
+		reportPolicyEvent(policy, fmt.Sprintf("FAILED to parse template for %s, not sending email", owner), report.ERROR)
+		logger.Errorf("FAILED to parse template.  Rolling back notified_at tags. %s", err.Error())
+		rollBackTags()
+		return nil, err
+	}
+	notification.Body = body
+
+	// the renewal link is left out of the other channels if the resource can't be renewed
+	for _, item := range items {
+		if item.RenewalLimit != "" {
+			item.Link = ""
+		}
+	}
+
+	// send the warning over each of the channels, the owner has been notified if any of the channels that reach them
+	// succeed.  Shared channels don't get the renewal links, so they don't count.
+	var failures []string
+	notified := false
+	for _, n := range notifiers() {
+		if err := n.Notify(notification); err != nil {
+			reportPolicyEvent(policy, fmt.Sprintf("FAILED to send %s notification to %s (%s)", n.Name(), owner, strings.Join(resourceIDs(resources), ", ")), report.ERROR)
+			logger.Errorf("Failed to notify %s over %s: %s", owner, n.Name(), err)
+			failures = append(failures, fmt.Sprintf("%s: %s", n.Name(), err))
+			continue
+		}
+
+		if !isShared(n) {
+			notified = true
+		}
+	}
+
+	// rollback the tags if we fail to notify the owner over every channel
+	if !notified {
+		logger.Errorf("Failed to notify.  Rolling back notified_at tags.")
+		rollBackTags()
+		return nil, fmt.Errorf("failed to notify %s over any channel, %s", owner, strings.Join(failures, "; "))
+	}
+
+	return resources, nil
+}
+
+// tagNotified tags the due resource with the new notification date and returns its item in the warning, along with a
+// function for rolling back the tag if the warning fails
+func tagNotified(d *dueResource, policy common.Policy, loc *time.Location) (*NotificationItem, func(), error) {
+	logger := log.WithField("policy", policy.Name)
+	resource := d.Resource
+
+	// get the date that the instance will expire
+	expireOn, err := resourceDecomAt(resource, policy)
+	if err != nil {
+		logger.Errorf("Unable to get the decomAt date for %s: %s", resource.ID, err)
+		return nil, nil, err
+	}
+
+	tagger, err := NewTagger(AppConfig.Tagging.Endpoint, AppConfig.Tagging.Token, resource.ID, resource.Org, AppConfig.Tagging.EncryptToken)
+	if err != nil {
+		msg := fmt.Sprintf("Unable to update tag for %s (%s)", resource.FQDN, resource.ID)
+		logger.Error(msg + ": " + err.Error())
+		reportPolicyEvent(policy, "FAILED"+msg, report.ERROR)
+		return nil, nil, err
+	}
+
+	err = tagger.Tag(map[string]string{
+		"yale:notified_at": time.Now().Format("2006/01/02 15:04:05"),
+	})
+
+	// if we can't tag, then leave this resource out of the warning
+	if err != nil {
+		msg := fmt.Sprintf("Unable to update tag for %s (%s)", resource.FQDN, resource.ID)
+		logger.Error(msg + ": " + err.Error())
+		reportPolicyEvent(policy, "FAILED"+msg, report.ERROR)
+		return nil, nil, err
+	}
+
+	// create a function for rolling back the tag if something fails
+	rollBackTag := func() {
+		err := tagger.Tag(map[string]string{
+			"yale:notified_at": resource.NotifiedAt,
+		})
+
+		if err != nil {
+			msg := fmt.Sprintf("Unable to roll back tag for %s (%s)", resource.FQDN, resource.ID)
+			reportPolicyEvent(policy, "FAILED "+msg, report.ERROR)
+			logger.Errorf(msg + ": " + err.Error())
+		}
+	}
+
+	item := &NotificationItem{
+		Resource:  resource,
+		Link:      d.Link,
+		ExpireOn:  expireOn.In(loc).Format("2006/01/02 15:04:05 MST"),
+		RenewedAt: d.RenewedAt.In(loc).Format("2006/01/02 15:04:05 MST"),
+	}
+
+	// let the owner know how many more times the resource can be renewed, or why it can't be
+	if _, err := renewalPolicy(resource, policy, time.Now()); errors.Is(err, ErrRenewalLimitReached) || errors.Is(err, ErrLifetimeReached) {
+		item.RenewalLimit = renewalLimitMessage(err)
+	} else if err != nil {
+		logger.Warnf("Unable to check the renewal limits for %s: %s", resource.ID, err)
+	} else if remaining, err := remainingRenewals(resource, policy); err == nil && remaining > 0 {
+		item.RemainingRenewals = strconv.Itoa(remaining)
+	}
+
+	return item, rollBackTag, nil
+}
+
+// resourceIDs returns the IDs of the resources
+func resourceIDs(resources []*search.Resource) []string {
+	ids := make([]string, 0, len(resources))
+	for _, r := range resources {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+// warningText returns the plain text warning sent over the channels that don't use the email template
+func warningText(params map[string]string) string {
+	text := fmt.Sprintf("Your Spinup TryIT server %s will expire on %s.", params["fqdn"], params["expire_on"])
+	switch {
+	case params["renewal_limit"] != "":
+		text = fmt.Sprintf("%s %s Please back up any data you would like to keep before it expires.", text, params["renewal_limit"])
+	case params["remaining_renewals"] != "":
+		text = fmt.Sprintf("%s If you would like to keep it, please renew it.  It can be renewed %s more time(s).", text, params["remaining_renewals"])
+	default:
+		text = fmt.Sprintf("%s If you would like to keep it, please renew it.", text)
+	}
+	return text
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+)
+
+func TestGroupByOwner(t *testing.T) {
+	due := []*dueResource{
+		{Resource: &search.Resource{ID: "i-1", SupportDepartmentContact: "abc123"}},
+		{Resource: &search.Resource{ID: "i-2", SupportDepartmentContact: "def456"}},
+		{Resource: &search.Resource{ID: "i-3", SupportDepartmentContact: "abc123"}},
+	}
+
+	groups := groupByOwner(due)
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 owners, got %d", len(groups))
+	}
+
+	if len(groups[0]) != 2 || groups[0][0].Resource.ID != "i-1" || groups[0][1].Resource.ID != "i-3" {
+		t.Errorf("Expected i-1 and i-3 for the first owner, got %+v", groups[0])
+	}
+
+	if len(groups[1]) != 1 || groups[1][0].Resource.ID != "i-2" {
+		t.Errorf("Expected i-2 for the second owner, got %+v", groups[1])
+	}
+
+	if groups := groupByOwner(nil); len(groups) != 0 {
+		t.Errorf("Expected no groups, got %+v", groups)
+	}
+}
+
+func TestSendNotificationDigest(t *testing.T) {
+	var notifications []map[string]interface{}
+	notifiedAt := map[string][]string{}
+	notifyStatus := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/users/"):
+			w.Write([]byte(`{"First": "Bob", "Email": "bob@example.com"}`))
+		case strings.HasSuffix(r.URL.Path, "/i-broken/tags"):
+			w.WriteHeader(http.StatusInternalServerError)
+		case strings.HasSuffix(r.URL.Path, "/tags"):
+			var tags map[string]map[string]string
+			json.NewDecoder(r.Body).Decode(&tags)
+			id := strings.Split(r.URL.Path, "/")[4]
+			notifiedAt[id] = append(notifiedAt[id], tags["tags"]["yale:notified_at"])
+		case r.URL.Path == "/notify":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			notifications = append(notifications, body)
+			w.WriteHeader(notifyStatus)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	withTestConfig(t, common.Config{
+		Notify: common.Notifier{
+			Age:      []string{"23d"},
+			Endpoint: server.URL + "/notify",
+			Channels: map[string]map[string]string{"http": {}},
+		},
+		Decommission:   common.Decommissioner{Age: "30d"},
+		Tagging:        common.Tagging{Endpoint: server.URL + "/v1/servers"},
+		UserDatasource: map[string]string{"type": "rest", "endpoint": server.URL + "/users", "token": "sekret"},
+	})
+
+	origNotifiers := Notifiers
+	Notifiers = nil
+	defer func() { Notifiers = origNotifiers }()
+
+	if err := configureNotifiers(); err != nil {
+		t.Fatalf("Expected nil error configuring notifiers, got %s", err)
+	}
+
+	policy := common.Policy{Name: "default", DecommissionAge: "30d"}
+	due := []*dueResource{}
+	for _, id := range []string{"i-123", "i-broken", "i-456"} {
+		due = append(due, &dueResource{
+			Resource:  &search.Resource{ID: id, Org: "fts", FQDN: id + ".yale.edu", SupportDepartmentContact: "abc123", RenewedAt: "2019/01/01 00:00:00", NotifiedAt: "2019/01/20 00:00:00"},
+			Link:      "https://reaper.example.com/renew/" + id,
+			RenewedAt: time.Now(),
+		})
+	}
+
+	// the owner gets a single digest, leaving out the resource that couldn't be tagged
+	notified, err := sendNotification(due, policy)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if len(notified) != 2 || notified[0].ID != "i-123" || notified[1].ID != "i-456" {
+		t.Errorf("Expected i-123 and i-456 to be notified, got %+v", notified)
+	}
+
+	if len(notifications) != 1 {
+		t.Fatalf("Expected a single notification, got %d", len(notifications))
+	}
+
+	if resources, _ := notifications[0]["resources"].([]interface{}); len(resources) != 2 || notifications[0]["subject"] != "Please renew your Spinup TryIT servers" {
+		t.Errorf("Expected a digest of 2 resources, got %+v", notifications[0])
+	}
+
+	if len(notifiedAt["i-123"]) != 1 || len(notifiedAt["i-456"]) != 1 {
+		t.Errorf("Expected notified_at to be tagged on each resource, got %v", notifiedAt)
+	}
+
+	// every tag in the digest is rolled back if the owner can't be notified
+	notifications, notifiedAt, notifyStatus = nil, map[string][]string{}, http.StatusInternalServerError
+	if notified, err := sendNotification(due, policy); err == nil || len(notified) != 0 {
+		t.Errorf("Expected error and no notified resources when every channel fails, got %v, %+v", err, notified)
+	}
+
+	for _, id := range []string{"i-123", "i-456"} {
+		if len(notifiedAt[id]) != 2 || notifiedAt[id][1] != "2019/01/20 00:00:00" {
+			t.Errorf("Expected notified_at to be rolled back for %s, got %v", id, notifiedAt[id])
+		}
+	}
+}
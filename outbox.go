@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultOutboxSize is the number of captured messages kept in the outbox
+const DefaultOutboxSize = 100
+
+// Outbox captures the emails sent with the capture transport
+var Outbox = NewCaptureTransport(DefaultOutboxSize)
+
+// CapturedMessage is an email captured by the capture transport
+type CapturedMessage struct {
+	ID      int       `json:"id"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Date    time.Time `json:"date"`
+	Text    string    `json:"text"`
+	HTML    string    `json:"html"`
+	Raw     string    `json:"raw"`
+}
+
+// CaptureTransport keeps the emails in memory instead of delivering them, for previewing them in development
+type CaptureTransport struct {
+	mu       sync.Mutex
+	size     int
+	next     int
+	messages []*CapturedMessage
+}
+
+// NewCaptureTransport creates a capture transport that keeps the latest size messages
+func NewCaptureTransport(size int) *CaptureTransport {
+	return &CaptureTransport{size: size, next: 1}
+}
+
+// Name returns the name of the transport
+func (c *CaptureTransport) Name() string {
+	return "capture"
+}
+
+// Send captures the message, dropping the oldest message once the outbox is full
+func (c *CaptureTransport) Send(from, to string, message []byte) error {
+	captured := &CapturedMessage{From: from, To: to, Raw: string(message)}
+
+	// the message is parsed for previewing, but it's still captured if it can't be
+	if err := captured.parse(message); err != nil {
+		log.Warnf("Unable to parse captured message to %s: %s", to, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	captured.ID = c.next
+	c.next++
+
+	c.messages = append(c.messages, captured)
+	if len(c.messages) > c.size {
+		c.messages = c.messages[len(c.messages)-c.size:]
+	}
+
+	log.Infof("Captured email %d to %s: %s", captured.ID, to, captured.Subject)
+	return nil
+}
+
+// Messages returns the captured messages, oldest first
+func (c *CaptureTransport) Messages() []*CapturedMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	messages := make([]*CapturedMessage, len(c.messages))
+	copy(messages, c.messages)
+	return messages
+}
+
+// parse fills in the subject, date and bodies of the captured message
+func (m *CapturedMessage) parse(message []byte) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		return err
+	}
+
+	if m.Subject, err = new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); err != nil {
+		m.Subject = msg.Header.Get("Subject")
+	}
+
+	if date, err := msg.Header.Date(); err == nil {
+		m.Date = date
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		body, err := io.ReadAll(msg.Body)
+		if err != nil {
+			return err
+		}
+		m.setBody(mediaType, string(body))
+		return nil
+	}
+
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// quoted-printable parts are decoded by the reader
+		body, err := io.ReadAll(p)
+		if err != nil {
+			return err
+		}
+
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		m.setBody(partType, string(body))
+	}
+}
+
+func (m *CapturedMessage) setBody(mediaType, body string) {
+	switch mediaType {
+	case "text/html":
+		m.HTML = body
+	case "text/plain":
+		m.Text = body
+	}
+}
+
+// OutboxHandler lists the emails captured by the capture transport as JSON, newest first
+func OutboxHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte{})
+		return
+	}
+
+	if !authenticateRequest(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if !strings.EqualFold(AppConfig.Email.Transport, "capture") {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("The outbox is only available with the capture email transport"))
+		return
+	}
+
+	messages := Outbox.Messages()
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	data, err := json.Marshal(struct {
+		Messages []*CapturedMessage `json:"messages"`
+	}{
+		Messages: messages,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte{})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/YaleSpinup/reaper/common"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCaptureTransport(t *testing.T) {
+	c := NewCaptureTransport(2)
+
+	for i := 1; i <= 3; i++ {
+		message, err := NewMessage("spinup@example.com", "bob@example.com", fmt.Sprintf("Message ☃ %d", i), "<p>Hello <b>bob</b></p>").Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Send("spinup@example.com", "bob@example.com", message); err != nil {
+			t.Fatalf("Expected nil error, got %s", err)
+		}
+	}
+
+	messages := c.Messages()
+	if len(messages) != 2 || messages[0].ID != 2 || messages[1].ID != 3 {
+		t.Fatalf("Expected the latest 2 messages, got %+v", messages)
+	}
+
+	m := messages[1]
+	if m.Subject != "Message ☃ 3" || m.HTML != "<p>Hello <b>bob</b></p>" || m.Text != "Hello bob\r\n" || m.Date.IsZero() || m.Raw == "" {
+		t.Errorf("Expected the parsed message, got %+v", m)
+	}
+
+	// messages that can't be parsed are still captured
+	if err := c.Send("spinup@example.com", "bob@example.com", []byte("garbage")); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if messages := c.Messages(); messages[1].Raw != "garbage" {
+		t.Errorf("Expected the raw message to be captured, got %+v", messages[1])
+	}
+}
+
+func TestOutboxHandler(t *testing.T) {
+	withTestConfig(t, common.Config{Token: "sekret", Email: common.Emailer{Transport: "capture", From: "spinup@example.com"}})
+
+	origOutbox := Outbox
+	Outbox = NewCaptureTransport(10)
+	defer func() { Outbox = origOutbox }()
+	withTestTransport(t, AppConfig.Email)
+
+	for _, to := range []string{"bob@example.com", "alice@example.com"} {
+		if err := SendMail(AppConfig.Email, to, "Please renew your Spinup TryIT server", "<p>Hello</p>"); err != nil {
+			t.Fatalf("Expected nil error, got %s", err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/reaper/dev/outbox", nil)
+	rr := httptest.NewRecorder()
+	OutboxHandler(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected forbidden without a token, got %d", rr.Code)
+	}
+
+	token, err := bcrypt.GenerateFromPassword([]byte("sekret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/reaper/dev/outbox", nil)
+	req.Header.Set("X-Auth-Token", string(token))
+	rr = httptest.NewRecorder()
+	OutboxHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected OK, got %d", rr.Code)
+	}
+
+	out := struct {
+		Messages []*CapturedMessage `json:"messages"`
+	}{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+		t.Fatalf("Expected valid JSON, got %s", err)
+	}
+
+	if len(out.Messages) != 2 || out.Messages[0].To != "alice@example.com" || out.Messages[1].To != "bob@example.com" {
+		t.Errorf("Expected the captured messages newest first, got %+v", out.Messages)
+	}
+
+	if out.Messages[0].Subject != "Please renew your Spinup TryIT server" || !strings.Contains(out.Messages[0].HTML, "Hello") {
+		t.Errorf("Unexpected captured message %+v", out.Messages[0])
+	}
+
+	AppConfig.Email.Transport = "smtp"
+	req = httptest.NewRequest(http.MethodGet, "/v1/reaper/dev/outbox", nil)
+	req.Header.Set("X-Auth-Token", string(token))
+	rr = httptest.NewRecorder()
+	OutboxHandler(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected not found without the capture transport, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"text/tabwriter"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+	log "github.com/sirupsen/logrus"
+)
+
+// Plan is the list of lifecycle actions that will be taken on the next run
+type Plan struct {
+	GeneratedAt time.Time    `json:"generated_at"`
+	DryRun      bool         `json:"dry_run"`
+	Resources   []*PlanEntry `json:"resources"`
+}
+
+// PlanEntry is a resource that has crossed a lifecycle threshold
+type PlanEntry struct {
+	ID             string    `json:"id"`
+	FQDN           string    `json:"fqdn,omitempty"`
+	Org            string    `json:"org"`
+	Owner          string    `json:"owner,omitempty"`
+	Policy         string    `json:"policy"`
+	Stage          string    `json:"stage"`
+	Threshold      string    `json:"threshold"`
+	RenewedAt      time.Time `json:"renewed_at"`
+	DecommissionAt time.Time `json:"decommission_at"`
+	DestroyAt      time.Time `json:"destroy_at"`
+}
+
+// planStage is a lifecycle stage and the function that decides if a resource returned by the stage query will be acted
+// on, given the start of its lifecycle
+type planStage struct {
+	name  string
+	query func(common.Policy) (*search.DateRangeQuery, error)
+	check func(resource *search.Resource, start time.Time, policy common.Policy) (threshold string, ok bool)
+}
+
+// planStages are evaluated in the same order as the batch routine
+func planStages(now time.Time) []planStage {
+	return []planStage{
+		{
+			name:  "destroy",
+			query: destroyQuery,
+			check: func(_ *search.Resource, start time.Time, policy common.Policy) (string, bool) {
+				return policy.DestroyAge, crossed(start, policy.DestroyAge, now)
+			},
+		},
+		{
+			name:  "decommission",
+			query: decommissionQuery,
+			check: func(_ *search.Resource, start time.Time, policy common.Policy) (string, bool) {
+				return policy.DecommissionAge, crossed(start, policy.DecommissionAge, now)
+			},
+		},
+		{
+			name: "notify",
+			query: func(policy common.Policy) (*search.DateRangeQuery, error) {
+				_, drq, err := notifyQuery(policy)
+				return drq, err
+			},
+			check: func(resource *search.Resource, start time.Time, policy common.Policy) (string, bool) {
+				ages, _, _ := notifyQuery(policy)
+				if resource.NotifiedAt == "" {
+					return ages[0], crossed(start, ages[0], now)
+				}
+
+				notifiedAt, err := time.Parse("2006/01/02 15:04:05", resource.NotifiedAt)
+				if err != nil {
+					log.Warnf("%s Couldn't parse notified_at (%s) as a time value. %s", resource.ID, resource.NotifiedAt, err)
+					return "", false
+				}
+
+				age, _, due, err := notifyThreshold(start, notifiedAt, ages, now)
+				if err != nil {
+					log.Warnf("%s Couldn't check the notification age thresholds. %s", resource.ID, err)
+					return "", false
+				}
+				return age, due
+			},
+		},
+	}
+}
+
+// crossed returns true if the age threshold from the start of the lifecycle has been crossed
+func crossed(start time.Time, age string, now time.Time) bool {
+	d, err := parseDuration(age)
+	return err == nil && !start.Add(d).After(now)
+}
+
+// computePlan runs the notify, decommission and destroy queries for each policy and returns the resources
+// that would be acted on, along with the threshold they crossed and their computed decommission and destroy dates
+func computePlan(finder search.ResourceSource, now time.Time) (*Plan, error) {
+	plan := &Plan{
+		GeneratedAt: now,
+		DryRun:      AppConfig.DryRun,
+		Resources:   []*PlanEntry{},
+	}
+
+	// a resource acted on by an earlier stage isn't acted on again in the same run, ie. a resource past the
+	// decommission age is decommissioned without being warned
+	planned := map[string]bool{}
+	for _, policy := range policies() {
+		for _, stage := range planStages(now) {
+			drq, err := stage.query(policy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter configuration for policy %s: %s", policy.Name, err)
+			}
+
+			resources, err := findResources(finder, drq)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, resource := range resources {
+				if !inPolicy(resource, policy) || resource.Org == "" || planned[resource.ID] {
+					continue
+				}
+
+				renewedAt, err := time.Parse("2006/01/02 15:04:05", resource.RenewedAt)
+				if err != nil {
+					log.Warnf("%s Couldn't parse renewed_at (%s) as a time value. %s", resource.ID, resource.RenewedAt, err)
+					continue
+				}
+
+				start, err := lifecycleStart(resource, policy)
+				if err != nil {
+					log.Warnf("%s Couldn't get the start of the lifecycle. %s", resource.ID, err)
+					continue
+				}
+
+				threshold, ok := stage.check(resource, start, policy)
+				if !ok {
+					continue
+				}
+
+				decomAge, err := parseDuration(policy.DecommissionAge)
+				if err != nil {
+					return nil, err
+				}
+
+				destroyAge, err := parseDuration(policy.DestroyAge)
+				if err != nil {
+					return nil, err
+				}
+
+				planned[resource.ID] = true
+				plan.Resources = append(plan.Resources, &PlanEntry{
+					ID:             resource.ID,
+					FQDN:           resource.FQDN,
+					Org:            resource.Org,
+					Owner:          resource.SupportDepartmentContact,
+					Policy:         policy.Name,
+					Stage:          stage.name,
+					Threshold:      threshold,
+					RenewedAt:      renewedAt,
+					DecommissionAt: start.Add(decomAge),
+					DestroyAt:      start.Add(destroyAge),
+				})
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// WriteTable writes the plan as a table
+func (p *Plan) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "POLICY\tSTAGE\tTHRESHOLD\tID\tFQDN\tOWNER\tRENEWED AT\tDECOMMISSION AT\tDESTROY AT")
+	for _, e := range p.Resources {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Policy, e.Stage, e.Threshold, e.ID, e.FQDN, e.Owner,
+			e.RenewedAt.Format("2006/01/02 15:04:05"),
+			e.DecommissionAt.Format("2006/01/02 15:04:05"),
+			e.DestroyAt.Format("2006/01/02 15:04:05"))
+	}
+	return tw.Flush()
+}
+
+// PlanHandler returns the lifecycle plan as JSON
+func PlanHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte{})
+		return
+	}
+
+	if !authenticateRequest(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	plan, err := computePlan(Finder, time.Now())
+	if errors.Is(err, search.ErrSourceUnavailable) {
+		log.Errorf("Couldn't compute the lifecycle plan, %s", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Failed connecting to elasticsearch"))
+		return
+	}
+
+	if err != nil {
+		log.Errorf("Couldn't compute the lifecycle plan, %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed computing the lifecycle plan"))
+		return
+	}
+
+	data, err := json.Marshal(plan)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte{})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// planCommand runs the 'plan' subcommand, writing the lifecycle plan as a table or JSON
+func planCommand(finder search.ResourceSource, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("plan", flag.ContinueOnError)
+	output := flags.String("o", "table", "Output format, table or json.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unknown output format %s", *output)
+	}
+
+	plan, err := computePlan(finder, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	}
+
+	return plan.WriteTable(out)
+}
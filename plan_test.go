@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testPlanConfig = common.Config{
+	Notify:       common.Notifier{Age: []string{"23d", "29d"}},
+	Decommission: common.Decommissioner{Age: "30d"},
+	Destroy:      common.Destroyer{Age: "44d"},
+	Token:        "sekret",
+}
+
+var testPlanSource = search.NewMemorySource([]*search.Document{
+	{ID: "i-notify", Source: map[string]interface{}{"yale:org": "fts", "status": "created", "yale:renewed_at": "2019/01/05 00:00:00"}},
+	{ID: "i-notified", Source: map[string]interface{}{"yale:org": "fts", "status": "created", "yale:renewed_at": "2019/01/05 00:00:00", "yale:notified_at": "2019/02/04 00:00:00"}},
+	{ID: "i-decom", Source: map[string]interface{}{"yale:org": "fts", "status": "created", "yale:renewed_at": "2019/01/01 00:00:00"}},
+	{ID: "i-destroy", Source: map[string]interface{}{"yale:org": "fts", "status": "decom", "yale:renewed_at": "2019/01/01 00:00:00"}},
+	{ID: "i-fresh", Source: map[string]interface{}{"yale:org": "fts", "status": "created", "yale:renewed_at": "2999/01/01 00:00:00"}},
+})
+
+func TestComputePlan(t *testing.T) {
+	withTestConfig(t, testPlanConfig)
+
+	// the memory source evaluates the query date math against the current time, so every old resource is returned by
+	// the stage queries and the thresholds are checked against now
+	now := time.Date(2019, time.February, 20, 12, 0, 0, 0, time.UTC)
+	plan, err := computePlan(testPlanSource, now)
+	if err != nil {
+		t.Fatalf("Expected nil error computing plan, got %s", err)
+	}
+
+	entries := map[string]*PlanEntry{}
+	for _, e := range plan.Resources {
+		if e.Policy != "default" {
+			t.Errorf("Expected default policy for %s, got %s", e.ID, e.Policy)
+		}
+		entries[e.ID+"/"+e.Stage] = e
+	}
+
+	expected := map[string]string{
+		"i-destroy/destroy":       "44d",
+		"i-decom/decommission":    "30d",
+		"i-notify/decommission":   "30d",
+		"i-notified/decommission": "30d",
+	}
+
+	for key, threshold := range expected {
+		e, ok := entries[key]
+		if !ok {
+			t.Errorf("Expected %s in the plan", key)
+			continue
+		}
+
+		if e.Threshold != threshold {
+			t.Errorf("Expected %s threshold to be %s, got %s", key, threshold, e.Threshold)
+		}
+	}
+
+	if len(entries) != len(expected) {
+		t.Errorf("Expected %d plan entries, got %d: %+v", len(expected), len(entries), plan.Resources)
+	}
+
+	e := entries["i-destroy/destroy"]
+	if e != nil && !e.DecommissionAt.Equal(time.Date(2019, time.January, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected decommission date for i-destroy %s", e.DecommissionAt)
+	}
+
+	if e != nil && !e.DestroyAt.Equal(time.Date(2019, time.February, 14, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected destroy date for i-destroy %s", e.DestroyAt)
+	}
+}
+
+func TestComputePlanOverdueDecommission(t *testing.T) {
+	withTestConfig(t, testPlanConfig)
+
+	// i-decom crossed the decommission age the day before and is decommissioned without being warned, i-notify has only
+	// crossed the first notification age
+	now := time.Date(2019, time.February, 1, 12, 0, 0, 0, time.UTC)
+	plan, err := computePlan(testPlanSource, now)
+	if err != nil {
+		t.Fatalf("Expected nil error computing plan, got %s", err)
+	}
+
+	stages := map[string][]string{}
+	for _, e := range plan.Resources {
+		stages[e.ID] = append(stages[e.ID], e.Stage)
+	}
+
+	if len(stages["i-decom"]) != 1 || stages["i-decom"][0] != "decommission" {
+		t.Errorf("Expected i-decom to only be decommissioned, got %v", stages["i-decom"])
+	}
+
+	if len(stages["i-notify"]) != 1 || stages["i-notify"][0] != "notify" {
+		t.Errorf("Expected i-notify to only be notified, got %v", stages["i-notify"])
+	}
+
+	if len(plan.Resources) != 2 {
+		t.Errorf("Expected 2 plan entries, got %d: %+v", len(plan.Resources), plan.Resources)
+	}
+}
+
+func TestNotifyThreshold(t *testing.T) {
+	ages := []string{"23d", "29d"}
+	renewedAt := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		notifiedAt time.Time
+		now        time.Time
+		age        string
+		due        bool
+	}{
+		{renewedAt, time.Date(2019, time.January, 20, 0, 0, 0, 0, time.UTC), "", false},
+		{renewedAt, time.Date(2019, time.January, 25, 0, 0, 0, 0, time.UTC), "23d", true},
+		{time.Date(2019, time.January, 25, 0, 0, 0, 0, time.UTC), time.Date(2019, time.January, 26, 0, 0, 0, 0, time.UTC), "", false},
+		{time.Date(2019, time.January, 25, 0, 0, 0, 0, time.UTC), time.Date(2019, time.January, 31, 0, 0, 0, 0, time.UTC), "29d", true},
+	}
+
+	for _, test := range tests {
+		age, _, due, err := notifyThreshold(renewedAt, test.notifiedAt, ages, test.now)
+		if err != nil {
+			t.Errorf("Expected nil error, got %s", err)
+		}
+
+		if age != test.age || due != test.due {
+			t.Errorf("Expected age %s and due %t at %s, got %s and %t", test.age, test.due, test.now, age, due)
+		}
+	}
+
+	if _, _, _, err := notifyThreshold(renewedAt, renewedAt, []string{"foo"}, time.Now()); err == nil {
+		t.Error("Expected error for bad age, got nil")
+	}
+}
+
+func TestPlanHandler(t *testing.T) {
+	withTestConfig(t, testPlanConfig)
+	orig := Finder
+	Finder = testPlanSource
+	defer func() { Finder = orig }()
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/reaper/plan", nil)
+	rr := httptest.NewRecorder()
+	PlanHandler(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected forbidden without a token, got %d", rr.Code)
+	}
+
+	token, err := bcrypt.GenerateFromPassword([]byte("sekret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/reaper/plan", nil)
+	req.Header.Set("X-Auth-Token", string(token))
+	rr = httptest.NewRecorder()
+	PlanHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected OK, got %d", rr.Code)
+	}
+
+	plan := Plan{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &plan); err != nil {
+		t.Fatalf("Expected valid JSON plan, got %s", err)
+	}
+
+	if len(plan.Resources) == 0 {
+		t.Error("Expected resources in the plan")
+	}
+}
+
+func TestPlanCommand(t *testing.T) {
+	withTestConfig(t, testPlanConfig)
+
+	out := new(bytes.Buffer)
+	if err := planCommand(testPlanSource, []string{}, out); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if !strings.HasPrefix(out.String(), "POLICY") || !strings.Contains(out.String(), "i-destroy") {
+		t.Errorf("Unexpected table output %s", out.String())
+	}
+
+	out.Reset()
+	if err := planCommand(testPlanSource, []string{"-o", "json"}, out); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if !json.Valid(out.Bytes()) {
+		t.Errorf("Expected valid JSON output, got %s", out.String())
+	}
+
+	if err := planCommand(testPlanSource, []string{"-o", "yaml"}, out); err == nil {
+		t.Error("Expected error for unknown output format, got nil")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+
+	report "github.com/YaleSpinup/eventreporter"
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+	log "github.com/sirupsen/logrus"
+)
+
+// policies returns the configured lifecycle policies
+func policies() []common.Policy {
+	return AppConfig.LifecyclePolicies()
+}
+
+// validatePolicies checks that the policies have unique names, valid filters and parseable ages
+func validatePolicies() error {
+	names := map[string]bool{}
+	for _, p := range policies() {
+		if p.Name == "" {
+			return fmt.Errorf("policy name is required")
+		}
+
+		if names[p.Name] {
+			return fmt.Errorf("duplicate policy name %s", p.Name)
+		}
+		names[p.Name] = true
+
+		if _, err := termFilters(p); err != nil {
+			return fmt.Errorf("invalid filter for policy %s: %s", p.Name, err)
+		}
+
+		if len(p.NotifyAge) == 0 {
+			return fmt.Errorf("at least one notify age is required for policy %s", p.Name)
+		}
+
+		for _, age := range append([]string{p.DecommissionAge, p.DestroyAge}, p.NotifyAge...) {
+			if _, err := parseDuration(age); err != nil {
+				return fmt.Errorf("invalid age '%s' for policy %s: %s", age, p.Name, err)
+			}
+		}
+
+		for _, extension := range append([]string{p.MaxExtension}, p.Extensions...) {
+			if extension == "" {
+				continue
+			}
+
+			if d, err := parseDuration(extension); err != nil || d <= 0 {
+				return fmt.Errorf("invalid extension '%s' for policy %s: %v", extension, p.Name, err)
+			}
+		}
+
+		if _, err := extensionOptions(p); err != nil {
+			return fmt.Errorf("invalid extensions for policy %s: %s", p.Name, err)
+		}
+
+		if p.MaxRenewals < 0 {
+			return fmt.Errorf("invalid max renewals %d for policy %s", p.MaxRenewals, p.Name)
+		}
+
+		if p.MaxLifetime != "" {
+			if d, err := parseDuration(p.MaxLifetime); err != nil || d <= 0 {
+				return fmt.Errorf("invalid max lifetime '%s' for policy %s: %v", p.MaxLifetime, p.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// termFilters returns the global and policy filters as term queries, followed by any additional term queries
+func termFilters(policy common.Policy, extra ...search.TermQuery) ([]search.TermQuery, error) {
+	var termfilter []search.TermQuery
+	for _, filters := range [][]common.Filter{AppConfig.Filters, policy.Filters} {
+		tqs, err := search.NewFilterQueryList(filters)
+		if err != nil {
+			return nil, err
+		}
+		termfilter = append(termfilter, tqs...)
+	}
+
+	termfilter = append(search.NewTermQueryList(AppConfig.Filter), termfilter...)
+	termfilter = append(termfilter, search.NewTermQueryList(policy.Filter)...)
+	return append(termfilter, extra...), nil
+}
+
+// policyFor returns the first policy whose filters match the resource.  If none of the policies match, the
+// first policy is returned and ok is false.
+func policyFor(resource *search.Resource) (policy common.Policy, ok bool) {
+	ps := policies()
+	for _, p := range ps {
+		termfilter, err := termFilters(p)
+		if err != nil {
+			log.Errorf("Invalid filter for policy %s: %s", p.Name, err)
+			continue
+		}
+
+		if resource.Matches(termfilter...) {
+			return p, true
+		}
+	}
+
+	return ps[0], false
+}
+
+// inPolicy checks if a resource found by the query for the policy should be processed by it.  The query already
+// applied the filters, so with a single policy the resource isn't matched again.  Otherwise the resource is skipped if
+// an earlier policy claims it, and a warning is logged if it doesn't match any of the policies since that means the
+// local matching disagrees with the search engine.
+func inPolicy(resource *search.Resource, policy common.Policy) bool {
+	if len(policies()) == 1 {
+		return true
+	}
+
+	p, ok := policyFor(resource)
+	switch {
+	case p.Name == policy.Name:
+		return true
+	case !ok:
+		log.Warnf("%s was found by the %s policy query but doesn't match its filters, skipping", resource.ID, policy.Name)
+	default:
+		log.Debugf("%s belongs to the %s policy, skipping", resource.ID, p.Name)
+	}
+
+	return false
+}
+
+// reportPolicyEvent reports an event prefixed with the name of the policy
+func reportPolicyEvent(policy common.Policy, msg string, level report.Level) {
+	reportEvent(fmt.Sprintf("[%s] %s", policy.Name, msg), level)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+var testPolicyConfig = common.Config{
+	Filter:       map[string]string{"yale:subsidized": "true"},
+	Notify:       common.Notifier{Age: []string{"23d", "29d"}},
+	Decommission: common.Decommissioner{Age: "30d"},
+	Destroy:      common.Destroyer{Age: "44d"},
+	Policies: []common.Policy{
+		{
+			Name:   "tryit",
+			Filter: map[string]string{"yale:program": "tryit"},
+		},
+		{
+			Name:            "courses",
+			Filters:         []common.Filter{{Field: "yale:program", Op: "terms", Values: []string{"lab", "course"}}},
+			NotifyAge:       []string{"110d"},
+			DecommissionAge: "120d",
+			DestroyAge:      "134d",
+		},
+	},
+}
+
+func withTestConfig(t *testing.T, config common.Config) {
+	orig, origLimiter := AppConfig, renewalLimiter
+	AppConfig = config
+	renewalLimiter, _ = newRateLimiter(config.RateLimit)
+	t.Cleanup(func() { AppConfig, renewalLimiter = orig, origLimiter })
+}
+
+func TestValidatePolicies(t *testing.T) {
+	withTestConfig(t, testPolicyConfig)
+	if err := validatePolicies(); err != nil {
+		t.Errorf("Expected nil error for valid policies, got %s", err)
+	}
+
+	bad := [][]common.Policy{
+		{{Name: ""}},
+		{{Name: "tryit"}, {Name: "tryit"}},
+		{{Name: "tryit", DecommissionAge: "thirty days"}},
+		{{Name: "tryit", Filters: []common.Filter{{Field: "foo", Op: "bogus"}}}},
+		{{Name: "tryit", Extensions: []string{"a week"}}},
+		{{Name: "tryit", MaxExtension: "0d"}},
+		{{Name: "tryit", MaxRenewals: -1}},
+		{{Name: "tryit", MaxLifetime: "forever"}},
+	}
+
+	for _, policies := range bad {
+		config := testPolicyConfig
+		config.Policies = policies
+		AppConfig = config
+
+		if err := validatePolicies(); err == nil {
+			t.Errorf("Expected error for invalid policies %+v, got nil", policies)
+		}
+	}
+}
+
+func TestTermFilters(t *testing.T) {
+	withTestConfig(t, testPolicyConfig)
+
+	tqs, err := termFilters(policies()[1], search.TermQuery{Term: "status", Value: "created"})
+	if err != nil {
+		t.Fatalf("Expected nil error for term filters, got %s", err)
+	}
+
+	if len(tqs) != 3 {
+		t.Fatalf("Expected global, policy and extra filters, got %+v", tqs)
+	}
+
+	if tqs[0].Term != "yale:subsidized" || tqs[1].Op != "terms" || tqs[2].Term != "status" {
+		t.Errorf("Unexpected term filters %+v", tqs)
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	withTestConfig(t, testPolicyConfig)
+
+	source := search.NewMemorySource([]*search.Document{
+		{ID: "i-tryit", Source: map[string]interface{}{"yale:subsidized": "true", "yale:program": "tryit"}},
+		{ID: "i-course", Source: map[string]interface{}{"yale:subsidized": "true", "yale:program": "course"}},
+		{ID: "i-other", Source: map[string]interface{}{"yale:subsidized": "true", "yale:program": "other"}},
+	})
+
+	tests := map[string]struct {
+		policy string
+		ok     bool
+	}{
+		"i-tryit":  {"tryit", true},
+		"i-course": {"courses", true},
+		"i-other":  {"tryit", false},
+	}
+
+	for id, expected := range tests {
+		resource, err := source.DoGet(id)
+		if err != nil {
+			t.Fatalf("Expected nil error getting %s, got %s", id, err)
+		}
+
+		policy, ok := policyFor(resource)
+		if policy.Name != expected.policy || ok != expected.ok {
+			t.Errorf("Expected %s to get policy %s (%t), got %s (%t)", id, expected.policy, expected.ok, policy.Name, ok)
+		}
+	}
+}
+
+func TestInPolicy(t *testing.T) {
+	withTestConfig(t, testPolicyConfig)
+	tryit, courses := policies()[0], policies()[1]
+
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	course := &search.Resource{ID: "i-course", Source: map[string]interface{}{"yale:subsidized": "true", "yale:program": "course"}}
+	other := &search.Resource{ID: "i-other", Source: map[string]interface{}{"yale:subsidized": "true", "yale:program": "other"}}
+
+	if !inPolicy(course, courses) || inPolicy(course, tryit) {
+		t.Error("Expected i-course to only be processed by the courses policy")
+	}
+
+	// a resource that doesn't match any policy stays with the first one
+	if !inPolicy(other, tryit) {
+		t.Error("Expected i-other to be processed by the first policy")
+	}
+
+	hook.Reset()
+	if inPolicy(other, courses) {
+		t.Error("Expected i-other to be skipped by the courses policy")
+	}
+
+	if e := hook.LastEntry(); e == nil || e.Level != log.WarnLevel {
+		t.Errorf("Expected a warning when a resource doesn't match the policy it was found by, got %+v", e)
+	}
+
+	// with a single policy the resources aren't matched again
+	withTestConfig(t, common.Config{
+		Filter:       map[string]string{"yale:subsidized": "true"},
+		Notify:       common.Notifier{Age: []string{"23d"}},
+		Decommission: common.Decommissioner{Age: "30d"},
+	})
+
+	if !inPolicy(&search.Resource{ID: "i-unsubsidized"}, policies()[0]) {
+		t.Error("Expected the resources found by the only policy to be processed")
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	report "github.com/YaleSpinup/eventreporter"
+	"github.com/YaleSpinup/reaper/common"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultRateLimitRequests is the number of renewal requests allowed per client IP within the window
+	DefaultRateLimitRequests = 10
+
+	// DefaultRateLimitResourceRequests is the number of renewal requests allowed per resource within the window
+	DefaultRateLimitResourceRequests = 20
+
+	// DefaultRateLimitWindow is the window the renewal requests are counted in
+	DefaultRateLimitWindow = time.Minute
+
+	// DefaultRateLimitMaxFailures is the number of invalid renewal tokens before a client or resource is locked out
+	DefaultRateLimitMaxFailures = 5
+
+	// DefaultRateLimitLockout is how long a client or resource is locked out for
+	DefaultRateLimitLockout = 15 * time.Minute
+)
+
+// renewalLimiter limits the requests to the public renewal endpoint
+var renewalLimiter, _ = newRateLimiter(common.RateLimiter{})
+
+// rateLimiter limits requests per client IP and per resource, locking them out after repeated failures
+type rateLimiter struct {
+	mu                sync.Mutex
+	requests          int
+	resourceRequests  int
+	window            time.Duration
+	maxFailures       int
+	lockout           time.Duration
+	trustForwardedFor bool
+	clients           map[string]*rateCounter
+	resources         map[string]*rateCounter
+	pruned            time.Time
+
+	// report is called with a message describing suspicious activity
+	report func(msg string)
+}
+
+// rateCounter counts the requests and failures of a client or resource
+type rateCounter struct {
+	windowStart time.Time
+	requests    int
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+
+	// reported is set once the current limit has been reported so repeated requests don't flood the event reporters
+	reported bool
+}
+
+// newRateLimiter creates a rate limiter from the configuration, falling back to the defaults for unset values
+func newRateLimiter(c common.RateLimiter) (*rateLimiter, error) {
+	l := &rateLimiter{
+		requests:          DefaultRateLimitRequests,
+		resourceRequests:  DefaultRateLimitResourceRequests,
+		window:            DefaultRateLimitWindow,
+		maxFailures:       DefaultRateLimitMaxFailures,
+		lockout:           DefaultRateLimitLockout,
+		trustForwardedFor: c.TrustForwardedFor,
+		clients:           map[string]*rateCounter{},
+		resources:         map[string]*rateCounter{},
+		report:            reportSuspiciousActivity,
+	}
+
+	for _, v := range []int{c.Requests, c.ResourceRequests, c.MaxFailures} {
+		if v < 0 {
+			return nil, fmt.Errorf("rate limits must not be negative")
+		}
+	}
+
+	if c.Requests > 0 {
+		l.requests = c.Requests
+	}
+
+	if c.ResourceRequests > 0 {
+		l.resourceRequests = c.ResourceRequests
+	}
+
+	if c.MaxFailures > 0 {
+		l.maxFailures = c.MaxFailures
+	}
+
+	for _, d := range []struct {
+		value string
+		dest  *time.Duration
+	}{{c.Window, &l.window}, {c.Lockout, &l.lockout}} {
+		if d.value == "" {
+			continue
+		}
+
+		duration, err := parseDuration(d.value)
+		if err != nil {
+			return nil, err
+		}
+
+		if duration <= 0 {
+			return nil, fmt.Errorf("rate limit durations must be positive, got %s", d.value)
+		}
+		*d.dest = duration
+	}
+
+	return l, nil
+}
+
+// allow counts a request from the client for the resource.  If the request isn't allowed, false is returned along with
+// how long the client should wait before retrying.
+func (l *rateLimiter) allow(client, resource string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+
+	for _, c := range []struct {
+		counter *rateCounter
+		limit   int
+		msg     string
+	}{
+		{l.counter(l.clients, client), l.requests, fmt.Sprintf("Rate limited renewal requests from %s", client)},
+		{l.counter(l.resources, resource), l.resourceRequests, fmt.Sprintf("Rate limited renewal requests for %s", resource)},
+	} {
+		if now.Before(c.counter.lockedUntil) {
+			return c.counter.lockedUntil.Sub(now), false
+		}
+
+		if now.Sub(c.counter.windowStart) >= l.window {
+			c.counter.windowStart, c.counter.requests, c.counter.reported = now, 0, false
+		}
+
+		c.counter.requests++
+		if c.counter.requests > c.limit {
+			if !c.counter.reported {
+				c.counter.reported = true
+				l.report(fmt.Sprintf("%s, more than %d requests in %s", c.msg, c.limit, l.window))
+			}
+			return c.counter.windowStart.Add(l.window).Sub(now), false
+		}
+	}
+
+	return 0, true
+}
+
+// fail counts an invalid renewal token from the client for the resource, locking them out after too many failures
+func (l *rateLimiter) fail(client, resource string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, c := range []struct {
+		counter *rateCounter
+		msg     string
+	}{
+		{l.counter(l.clients, client), fmt.Sprintf("Locked out %s from renewing", client)},
+		{l.counter(l.resources, resource), fmt.Sprintf("Locked out renewals for %s", resource)},
+	} {
+		// failures are forgotten once a client or resource has behaved for the lockout duration
+		if now.Sub(c.counter.lastFailure) >= l.lockout {
+			c.counter.failures = 0
+		}
+
+		c.counter.failures++
+		c.counter.lastFailure = now
+		if c.counter.failures >= l.maxFailures {
+			c.counter.failures = 0
+			c.counter.lockedUntil = now.Add(l.lockout)
+			l.report(fmt.Sprintf("%s for %s after %d invalid renewal tokens (client %s, resource %s)", c.msg, l.lockout, l.maxFailures, client, resource))
+		}
+	}
+}
+
+// counter returns the counter for the key, creating it if it doesn't exist
+func (l *rateLimiter) counter(counters map[string]*rateCounter, key string) *rateCounter {
+	c, ok := counters[key]
+	if !ok {
+		c = &rateCounter{}
+		counters[key] = c
+	}
+	return c
+}
+
+// prune forgets the counters that aren't limiting anything anymore, at most once per window
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < l.window {
+		return
+	}
+	l.pruned = now
+
+	for _, counters := range []map[string]*rateCounter{l.clients, l.resources} {
+		for k, c := range counters {
+			if now.Sub(c.windowStart) >= l.window && now.After(c.lockedUntil) && now.Sub(c.lastFailure) >= l.lockout {
+				delete(counters, k)
+			}
+		}
+	}
+}
+
+// clientIP returns the IP address of the client making the request.  The last address in the X-Forwarded-For header,
+// added by the proxy in front of the reaper, is used if the header is trusted.
+func (l *rateLimiter) clientIP(r *http.Request) string {
+	if l.trustForwardedFor {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			addrs := strings.Split(xff, ",")
+			return strings.TrimSpace(addrs[len(addrs)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// reportSuspiciousActivity logs and reports suspicious activity on the renewal endpoint
+func reportSuspiciousActivity(msg string) {
+	log.Warn(msg)
+	reportEvent(msg, report.ERROR)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	report "github.com/YaleSpinup/eventreporter"
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/gorilla/mux"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+	l, err := newRateLimiter(common.RateLimiter{})
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if l.requests != DefaultRateLimitRequests || l.window != DefaultRateLimitWindow || l.lockout != DefaultRateLimitLockout {
+		t.Errorf("Expected the default limits, got %+v", l)
+	}
+
+	l, err = newRateLimiter(common.RateLimiter{Requests: 3, Window: "30s", Lockout: "1h"})
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if l.requests != 3 || l.window != 30*time.Second || l.lockout != time.Hour || l.maxFailures != DefaultRateLimitMaxFailures {
+		t.Errorf("Expected the configured limits, got %+v", l)
+	}
+
+	for _, c := range []common.RateLimiter{{Requests: -1}, {Window: "soon"}, {Lockout: "0s"}} {
+		if _, err := newRateLimiter(c); err == nil {
+			t.Errorf("Expected error for %+v, got nil", c)
+		}
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	var reports []string
+	l, _ := newRateLimiter(common.RateLimiter{Requests: 2, ResourceRequests: 3})
+	l.report = func(msg string) { reports = append(reports, msg) }
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i, test := range []struct {
+		client   string
+		resource string
+		allowed  bool
+	}{
+		{"10.0.0.1", "i-123", true},
+		{"10.0.0.1", "i-123", true},
+		{"10.0.0.1", "i-123", false},
+		{"10.0.0.1", "i-456", false},
+		{"10.0.0.2", "i-123", true},
+		{"10.0.0.3", "i-123", false},
+		{"10.0.0.3", "i-456", true},
+	} {
+		if _, ok := l.allow(test.client, test.resource, now); ok != test.allowed {
+			t.Errorf("Expected request %d %+v allowed %t, got %t", i, test, test.allowed, ok)
+		}
+	}
+
+	if len(reports) != 2 {
+		t.Errorf("Expected the client and resource limits to be reported once, got %v", reports)
+	}
+
+	wait, ok := l.allow("10.0.0.1", "i-789", now.Add(30*time.Second))
+	if ok || wait != 30*time.Second {
+		t.Errorf("Expected to wait 30s for the window to end, got %s (%t)", wait, ok)
+	}
+
+	if _, ok := l.allow("10.0.0.1", "i-789", now.Add(time.Minute)); !ok {
+		t.Error("Expected requests to be allowed in the next window")
+	}
+}
+
+func TestRateLimiterLockout(t *testing.T) {
+	var reports []string
+	l, _ := newRateLimiter(common.RateLimiter{Requests: 100, ResourceRequests: 100, MaxFailures: 3, Lockout: "15m"})
+	l.report = func(msg string) { reports = append(reports, msg) }
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	l.fail("10.0.0.1", "i-123", now)
+	l.fail("10.0.0.1", "i-456", now)
+
+	if _, ok := l.allow("10.0.0.1", "i-789", now); !ok {
+		t.Error("Expected requests to be allowed before the lockout")
+	}
+
+	l.fail("10.0.0.1", "i-789", now)
+	if len(reports) != 1 || !strings.Contains(reports[0], "10.0.0.1") {
+		t.Errorf("Expected the client lockout to be reported, got %v", reports)
+	}
+
+	wait, ok := l.allow("10.0.0.1", "i-000", now.Add(time.Minute))
+	if ok || wait != 14*time.Minute {
+		t.Errorf("Expected the client to be locked out for 14 more minutes, got %s (%t)", wait, ok)
+	}
+
+	if _, ok := l.allow("10.0.0.2", "i-000", now.Add(time.Minute)); !ok {
+		t.Error("Expected other clients not to be locked out")
+	}
+
+	if _, ok := l.allow("10.0.0.1", "i-000", now.Add(15*time.Minute)); !ok {
+		t.Error("Expected the lockout to expire")
+	}
+
+	// failures are forgotten after the lockout duration
+	l.fail("10.0.0.4", "i-1", now)
+	l.fail("10.0.0.4", "i-2", now)
+	l.fail("10.0.0.4", "i-3", now.Add(20*time.Minute))
+	if _, ok := l.allow("10.0.0.4", "i-4", now.Add(20*time.Minute)); !ok {
+		t.Error("Expected old failures to be forgotten")
+	}
+
+	// resources are locked out after repeated failures from different clients
+	reports = nil
+	for i := 0; i < 3; i++ {
+		l.fail(fmt.Sprintf("10.0.1.%d", i), "i-target", now)
+	}
+
+	if _, ok := l.allow("10.0.0.9", "i-target", now); ok {
+		t.Error("Expected the resource to be locked out")
+	}
+
+	if len(reports) != 1 || !strings.Contains(reports[0], "i-target") {
+		t.Errorf("Expected the resource lockout to be reported, got %v", reports)
+	}
+
+	l.prune(now.Add(time.Hour))
+	if len(l.clients) != 0 || len(l.resources) != 0 {
+		t.Errorf("Expected idle counters to be pruned, got %d clients and %d resources", len(l.clients), len(l.resources))
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/v1/reaper/renew/i-123", nil)
+	req.RemoteAddr = "10.0.0.1:12345"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 192.168.1.1")
+
+	l, _ := newRateLimiter(common.RateLimiter{})
+	if ip := l.clientIP(req); ip != "10.0.0.1" {
+		t.Errorf("Expected the remote address, got %s", ip)
+	}
+
+	l, _ = newRateLimiter(common.RateLimiter{TrustForwardedFor: true})
+	if ip := l.clientIP(req); ip != "192.168.1.1" {
+		t.Errorf("Expected the address added by the proxy, got %s", ip)
+	}
+}
+
+func TestRenewalHanderRateLimit(t *testing.T) {
+	withTestConfig(t, common.Config{
+		Notify:           common.Notifier{Age: []string{"23d"}},
+		Decommission:     common.Decommissioner{Age: "30d"},
+		Destroy:          common.Destroyer{Age: "44d"},
+		EncryptionSecret: "sekret",
+		RateLimit:        common.RateLimiter{MaxFailures: 2},
+	})
+
+	reporter := &testReporter{}
+	origReporters := EventReporters
+	EventReporters = []report.Reporter{reporter}
+	defer func() { EventReporters = origReporters }()
+
+	orig := Finder
+	Finder = testSource
+	defer func() { Finder = orig }()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/reaper/renew/{id}", RenewalHander)
+
+	secret := &RenewalSecret{ResourceID: "i-expired", Action: RenewAction, RenewedAt: "2019/01/01 00:00:00", Secrets: []string{"sekret"}}
+	token, err := secret.GenerateRenewalToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/reaper/renew/i-expired?token="+url.QueryEscape(token), nil))
+		return rr
+	}
+
+	if rr := get(token); rr.Code != http.StatusOK {
+		t.Fatalf("Expected OK with a valid token, got %d", rr.Code)
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := get("foo.bar"); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected forbidden with an invalid token, got %d", rr.Code)
+		}
+	}
+
+	rr := get(token)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected the client to be locked out after invalid tokens, got %d", rr.Code)
+	}
+
+	if len(reporter.events) == 0 || !strings.Contains(reporter.events[0].Message, "Locked out") {
+		t.Errorf("Expected the lockout to be reported, got %+v", reporter.events)
+	}
+}
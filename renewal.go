@@ -1,52 +1,267 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
 )
 
-// RenewalSecret is the object used to generate the renewal token
+// DefaultRenewalTokenTTL is how long a renewal token is valid when the ttl isn't configured
+const DefaultRenewalTokenTTL = 7 * 24 * time.Hour
+
+// RenewAction is the action claimed by renewal tokens
+const RenewAction = "renew"
+
+var (
+	// ErrTokenInvalid is returned when a renewal token is malformed, has a bad signature or claims the wrong action
+	ErrTokenInvalid = errors.New("renewal token is invalid")
+
+	// ErrTokenExpired is returned when a renewal token has expired
+	ErrTokenExpired = errors.New("renewal token has expired")
+
+	// ErrTokenWrongResource is returned when a renewal token was issued for a different resource
+	ErrTokenWrongResource = errors.New("renewal token was issued for a different resource")
+
+	// ErrTokenStale is returned when the resource has been renewed since the renewal token was issued
+	ErrTokenStale = errors.New("resource has been renewed since the renewal token was issued")
+
+	// ErrTokenUsed is returned when a renewal token has already been used
+	ErrTokenUsed = errors.New("renewal token has already been used")
+)
+
+// usedRenewalTokens tracks the renewal tokens that have been used
+var usedRenewalTokens = newTokenStore()
+
+// RenewalSecret is the object used to generate and validate the renewal token for a resource.  The first
+// of the secrets signs new tokens, all of them are accepted when validating so secrets can be rotated.
 type RenewalSecret struct {
+	ResourceID string
+	Action     string
+	RenewedAt  string
+	Secrets    []string
+	TTL        time.Duration
+}
+
+// renewalClaims are the signed contents of a renewal token
+type renewalClaims struct {
+	ID        string `json:"id"`
+	Action    string `json:"action"`
 	RenewedAt string `json:"renewed_at"`
-	Secret    string `json:"secret"`
+	ExpiresAt int64  `json:"exp"`
+	Nonce     string `json:"nonce"`
 }
 
-// GenerateRenewalToken creates a base64 encoded renewal token
+// GenerateRenewalToken creates a signed renewal token.  The token is the base64 encoded JSON claims and the
+// base64 encoded HMAC-SHA256 signature of the claims, separated by a '.'
 func (r *RenewalSecret) GenerateRenewalToken() (string, error) {
-	str, err := json.Marshal(r)
-	if err != nil {
-		return "", err
+	if len(r.Secrets) == 0 {
+		return "", errors.New("a secret is required to sign renewal tokens")
 	}
 
-	log.Debugf("Marshalled secret JSON string %s", str)
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = DefaultRenewalTokenTTL
+	}
 
-	token, err := bcrypt.GenerateFromPassword(str, bcrypt.DefaultCost)
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(renewalClaims{
+		ID:        r.ResourceID,
+		Action:    r.Action,
+		RenewedAt: r.RenewedAt,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+		Nonce:     hex.EncodeToString(nonce),
+	})
 	if err != nil {
 		return "", err
 	}
-	log.Debugln("Secret hash:", string(token))
 
-	return base64.StdEncoding.EncodeToString(token), nil
+	log.Debugf("Marshalled renewal claims JSON string %s", claims)
+
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign(r.Secrets[0], payload)), nil
 }
 
-// ValidateRenewalToken validates a base64 encoded renewal token
+// ValidateRenewalToken validates a signed renewal token against the resource, action and renewed_at date
+// of the secret.  ErrTokenInvalid, ErrTokenExpired, ErrTokenWrongResource, ErrTokenStale or ErrTokenUsed
+// is returned if the token isn't valid.
 func (r *RenewalSecret) ValidateRenewalToken(token string) error {
-	decodedToken, err := base64.StdEncoding.DecodeString(token)
+	_, err := r.validate(token)
+	return err
+}
+
+// UseRenewalToken validates a signed renewal token and marks it as used so it can't be replayed.  The
+// returned function releases the token again, it should be called if the renewal fails.
+func (r *RenewalSecret) UseRenewalToken(token string) (func(), error) {
+	claims, err := r.validate(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := usedRenewalTokens.use(claims.Nonce, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		return nil, err
+	}
+
+	return func() { usedRenewalTokens.release(claims.Nonce) }, nil
+}
+
+// VerifyRenewalToken verifies the signature, action, resource and expiry of a signed renewal token.  Unlike
+// ValidateRenewalToken, the renewed_at date isn't needed so bad tokens can be refused before fetching the resource.
+func (r *RenewalSecret) VerifyRenewalToken(token string) error {
+	_, err := r.verify(token)
+	return err
+}
+
+func (r *RenewalSecret) validate(token string) (*renewalClaims, error) {
+	claims, err := r.verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case claims.RenewedAt != r.RenewedAt:
+		return nil, ErrTokenStale
+	case usedRenewalTokens.used(claims.Nonce):
+		return nil, ErrTokenUsed
+	}
+
+	return claims, nil
+}
+
+func (r *RenewalSecret) verify(token string) (*renewalClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrTokenInvalid
+	}
+
+	if r.keyVersion(token) < 0 {
+		return nil, ErrTokenInvalid
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
 	if err != nil {
-		log.Error("Failed to decode base64", err)
-		return err
+		log.Warnf("Failed to decode renewal token claims: %s", err)
+		return nil, ErrTokenInvalid
+	}
+
+	claims := &renewalClaims{}
+	if err := json.Unmarshal(data, claims); err != nil {
+		log.Warnf("Failed to unmarshal renewal token claims: %s", err)
+		return nil, ErrTokenInvalid
 	}
 
-	str, err := json.Marshal(r)
+	log.Debugf("Validating renewal token claims %+v", claims)
+
+	switch {
+	case claims.Action != r.Action:
+		return nil, ErrTokenInvalid
+	case claims.ID != r.ResourceID:
+		return nil, ErrTokenWrongResource
+	case time.Now().After(time.Unix(claims.ExpiresAt, 0)):
+		return nil, ErrTokenExpired
+	}
+
+	return claims, nil
+}
+
+// keyVersion returns the index of the secret the token was signed with, or -1 if none of the secrets signed it
+func (r *RenewalSecret) keyVersion(token string) int {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return -1
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
-		log.Error("Failed to marshall JSON", err)
-		return err
+		log.Warnf("Failed to decode renewal token signature: %s", err)
+		return -1
 	}
 
-	log.Debugf("Comparing decodedToken: %s with renewalSecret %s", decodedToken, string(str))
+	for i, secret := range r.Secrets {
+		if hmac.Equal(signature, sign(secret, parts[0])) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// logKeyVersion logs the version and fingerprint of the secret the token was signed with, so it's known when a
+// rotated secret can be dropped
+func (r *RenewalSecret) logKeyVersion(token string) {
+	if version := r.keyVersion(token); version >= 0 {
+		log.Infof("Renewal token for %s signed with key version %d (%s)", r.ResourceID, version, secretFingerprint(r.Secrets[version]))
+	}
+}
+
+func sign(secret, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// secretFingerprint returns a short fingerprint identifying a secret without revealing it
+func secretFingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return fmt.Sprintf("%x", sum[:4])
+}
+
+// tokenStore is an in-memory store of used tokens, tokens are forgotten once they expire
+type tokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+func newTokenStore() *tokenStore {
+	return &tokenStore{tokens: map[string]time.Time{}}
+}
+
+// use marks a token as used, returning ErrTokenUsed if it's already been used
+func (s *tokenStore) use(id string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, exp := range s.tokens {
+		if now.After(exp) {
+			delete(s.tokens, k)
+		}
+	}
+
+	if _, ok := s.tokens[id]; ok {
+		return ErrTokenUsed
+	}
+
+	s.tokens[id] = expiresAt
+	return nil
+}
+
+// used returns true if the token has been used
+func (s *tokenStore) used(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.tokens[id]
+	return ok
+}
+
+// release forgets a used token
+func (s *tokenStore) release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	return bcrypt.CompareHashAndPassword(decodedToken, str)
+	delete(s.tokens, id)
 }
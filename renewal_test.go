@@ -3,53 +3,230 @@ package main
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
-
-	"golang.org/x/crypto/bcrypt"
+	"time"
 )
 
+var testRenewalSecret = RenewalSecret{
+	ResourceID: "i-123",
+	Action:     RenewAction,
+	RenewedAt:  "2013/06/19 19:14:05",
+	Secrets:    []string{"54321"},
+}
+
 func TestGenerateRenewalToken(t *testing.T) {
-	renewalSecret := &RenewalSecret{RenewedAt: "2013/06/19 19:14:05", Secret: "54321"}
-	encodedToken, err := renewalSecret.GenerateRenewalToken()
+	renewalSecret := testRenewalSecret
+	renewalSecret.TTL = time.Hour
+
+	token, err := renewalSecret.GenerateRenewalToken()
 	if err != nil {
-		t.Error("Failed to generate renewal token:", err)
+		t.Fatal("Failed to generate renewal token:", err)
 	}
 
-	actualToken, err := base64.StdEncoding.DecodeString(encodedToken)
-	if err != nil {
-		t.Error("Failed to decode base64 encoded string", err)
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		t.Fatalf("Expected token with claims and signature, got %s", token)
 	}
 
-	str, err := json.Marshal(renewalSecret)
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
 	if err != nil {
-		t.Error("Failed to marshall JSON", err)
+		t.Fatal("Failed to decode base64 encoded claims", err)
 	}
 
-	err = bcrypt.CompareHashAndPassword(actualToken, str)
+	claims := renewalClaims{}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		t.Fatal("Failed to unmarshal claims", err)
+	}
+
+	if claims.ID != "i-123" || claims.Action != RenewAction || claims.RenewedAt != "2013/06/19 19:14:05" || claims.Nonce == "" {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+
+	if exp := time.Unix(claims.ExpiresAt, 0); exp.Before(time.Now().Add(59*time.Minute)) || exp.After(time.Now().Add(61*time.Minute)) {
+		t.Errorf("Expected token to expire in an hour, got %s", exp)
+	}
+
+	if strings.Contains(string(data), "54321") {
+		t.Error("Expected the secret not to be part of the token")
+	}
+
+	other, err := renewalSecret.GenerateRenewalToken()
 	if err != nil {
-		t.Errorf("Failed to compare generated hash %s and string token %s. %s", actualToken, string(str), err)
+		t.Fatal("Failed to generate renewal token:", err)
+	}
+
+	if token == other {
+		t.Error("Expected unique renewal tokens")
 	}
 }
 
 func TestValidateRenewalToken(t *testing.T) {
-	// Generate the test passed "password"
-	renewalSecret1 := &RenewalSecret{RenewedAt: "2013/06/19 19:14:05", Secret: "54321"}
-	str, err := json.Marshal(renewalSecret1)
+	renewalSecret := testRenewalSecret
+	token, err := renewalSecret.GenerateRenewalToken()
+	if err != nil {
+		t.Fatal("Failed to generate renewal token:", err)
+	}
+
+	if err := renewalSecret.ValidateRenewalToken(token); err != nil {
+		t.Errorf("Expected valid token to validate. Token: %s Secret: %+v. %s", token, renewalSecret, err)
+	}
+
+	expired := testRenewalSecret
+	expired.TTL = -time.Minute
+	expiredToken, err := expired.GenerateRenewalToken()
+	if err != nil {
+		t.Fatal("Failed to generate renewal token:", err)
+	}
+
+	parts := strings.Split(token, ".")
+	tampered := base64.RawURLEncoding.EncodeToString([]byte(`{"id":"i-123","action":"renew","renewed_at":"2013/06/19 19:14:05","exp":9999999999}`)) + "." + parts[1]
+
+	tests := []struct {
+		secret func(RenewalSecret) RenewalSecret
+		token  string
+		err    error
+	}{
+		{func(r RenewalSecret) RenewalSecret { r.ResourceID = "i-456"; return r }, token, ErrTokenWrongResource},
+		{func(r RenewalSecret) RenewalSecret { r.Action = "destroy"; return r }, token, ErrTokenInvalid},
+		{func(r RenewalSecret) RenewalSecret { r.RenewedAt = "2013/07/19 19:14:05"; return r }, token, ErrTokenStale},
+		{func(r RenewalSecret) RenewalSecret { r.Secrets = []string{"12345"}; return r }, token, ErrTokenInvalid},
+		{func(r RenewalSecret) RenewalSecret { r.Secrets = nil; return r }, token, ErrTokenInvalid},
+		{func(r RenewalSecret) RenewalSecret { return r }, expiredToken, ErrTokenExpired},
+		{func(r RenewalSecret) RenewalSecret { return r }, tampered, ErrTokenInvalid},
+		{func(r RenewalSecret) RenewalSecret { return r }, "foo", ErrTokenInvalid},
+		{func(r RenewalSecret) RenewalSecret { return r }, "foo.b@r", ErrTokenInvalid},
+	}
+
+	for _, test := range tests {
+		secret := test.secret(testRenewalSecret)
+		if err := secret.ValidateRenewalToken(test.token); !errors.Is(err, test.err) {
+			t.Errorf("Expected error %s for secret %+v, got %v", test.err, secret, err)
+		}
+	}
+}
+
+func TestVerifyRenewalToken(t *testing.T) {
+	renewalSecret := testRenewalSecret
+	token, err := renewalSecret.GenerateRenewalToken()
+	if err != nil {
+		t.Fatal("Failed to generate renewal token:", err)
+	}
+
+	// the renewed_at date isn't needed to verify the token
+	unknown := RenewalSecret{ResourceID: "i-123", Action: RenewAction, Secrets: []string{"54321"}}
+	if err := unknown.VerifyRenewalToken(token); err != nil {
+		t.Errorf("Expected token to verify without the renewed_at date, got %s", err)
+	}
+
+	unknown.ResourceID = "i-456"
+	if err := unknown.VerifyRenewalToken(token); !errors.Is(err, ErrTokenWrongResource) {
+		t.Errorf("Expected ErrTokenWrongResource, got %v", err)
+	}
+
+	unknown.ResourceID = "i-123"
+	unknown.Secrets = []string{"12345"}
+	if err := unknown.VerifyRenewalToken(token); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected ErrTokenInvalid, got %v", err)
+	}
+}
+
+func TestUseRenewalToken(t *testing.T) {
+	renewalSecret := testRenewalSecret
+	token, err := renewalSecret.GenerateRenewalToken()
+	if err != nil {
+		t.Fatal("Failed to generate renewal token:", err)
+	}
+
+	release, err := renewalSecret.UseRenewalToken(token)
+	if err != nil {
+		t.Fatalf("Expected nil error using token, got %s", err)
+	}
+
+	if err := renewalSecret.ValidateRenewalToken(token); !errors.Is(err, ErrTokenUsed) {
+		t.Errorf("Expected used token not to validate, got %v", err)
+	}
+
+	if _, err := renewalSecret.UseRenewalToken(token); !errors.Is(err, ErrTokenUsed) {
+		t.Errorf("Expected used token not to be used again, got %v", err)
+	}
+
+	release()
+	if _, err := renewalSecret.UseRenewalToken(token); err != nil {
+		t.Errorf("Expected released token to be usable, got %s", err)
+	}
+}
+
+func TestTokenStoreExpiry(t *testing.T) {
+	store := newTokenStore()
+	if err := store.use("old", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if err := store.use("new", time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if store.used("old") {
+		t.Error("Expected expired token to be forgotten")
+	}
+
+	if !store.used("new") {
+		t.Error("Expected unexpired token to be remembered")
+	}
+}
+
+func TestRenewalTokenSecretRotation(t *testing.T) {
+	old := testRenewalSecret
+	token, err := old.GenerateRenewalToken()
 	if err != nil {
-		t.Error("Failed to marshall JSON", err)
+		t.Fatal("Failed to generate renewal token:", err)
+	}
+
+	rotated := testRenewalSecret
+	rotated.Secrets = []string{"abcde", "54321"}
+	if err := rotated.ValidateRenewalToken(token); err != nil {
+		t.Errorf("Expected token signed with the old secret to validate, got %s", err)
 	}
 
-	ct, err := bcrypt.GenerateFromPassword(str, bcrypt.DefaultCost)
+	newToken, err := rotated.GenerateRenewalToken()
 	if err != nil {
-		t.Error("Failed to generate from password", err)
+		t.Fatal("Failed to generate renewal token:", err)
+	}
+
+	if err := old.ValidateRenewalToken(newToken); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected new token to be signed with the first secret, got %v", err)
+	}
+
+	if v := rotated.keyVersion(token); v != 1 {
+		t.Errorf("Expected token signed with key version 1, got %d", v)
 	}
 
-	password := base64.StdEncoding.EncodeToString(ct)
+	if v := rotated.keyVersion(newToken); v != 0 {
+		t.Errorf("Expected new token signed with key version 0, got %d", v)
+	}
+
+	retired := testRenewalSecret
+	retired.Secrets = []string{"abcde"}
+	if v := retired.keyVersion(token); v != -1 {
+		t.Errorf("Expected no key version for a retired secret, got %d", v)
+	}
+	if err := retired.ValidateRenewalToken(token); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected token signed with a retired secret not to validate, got %v", err)
+	}
+
+	if _, err := (&RenewalSecret{}).GenerateRenewalToken(); err == nil {
+		t.Error("Expected error generating a token without a secret, got nil")
+	}
+}
+
+func TestSecretFingerprint(t *testing.T) {
+	if secretFingerprint("54321") == secretFingerprint("abcde") {
+		t.Error("Expected different fingerprints for different secrets")
+	}
 
-	// Generate the renewal secret to compare
-	renewalSecret2 := &RenewalSecret{RenewedAt: "2013/06/19 19:14:05", Secret: "54321"}
-	t.Logf("Validating token %s against renewal secert %+v", password, renewalSecret2)
-	if err := renewalSecret2.ValidateRenewalToken(password); err != nil {
-		t.Errorf("Expected valid token to validate. Token: %s Secret: %s. %s", password, renewalSecret2, err.Error())
+	if fp := secretFingerprint("54321"); len(fp) != 8 || strings.Contains(fp, "54321") {
+		t.Errorf("Unexpected fingerprint %s", fp)
 	}
 }
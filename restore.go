@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	report "github.com/YaleSpinup/eventreporter"
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNotRestorable is returned when a resource can no longer be restored, it's past its destroy age or has been destroyed
+var ErrNotRestorable = errors.New("resource can no longer be restored")
+
+// restorable returns true if the resource has been decommissioned and is still within the resurrection window between
+// the decommission and destroy ages.  False is returned for resources that haven't been decommissioned and
+// ErrNotRestorable is returned for resources that can't be renewed anymore.
+func restorable(resource *search.Resource, policy common.Policy, now time.Time) (bool, error) {
+	switch resource.Status {
+	case "created":
+		return false, nil
+	case "decom":
+	default:
+		return false, ErrNotRestorable
+	}
+
+	crossed, err := crossedAge(resource, policy, policy.DestroyAge, now)
+	if err != nil {
+		return false, err
+	}
+
+	if crossed {
+		return false, ErrNotRestorable
+	}
+
+	return true, nil
+}
+
+// checkRenewal checks whether the resource can be renewed, returning the policy the renewal is bound by and whether the
+// resource needs to be restored.  ErrNotRestorable, ErrRenewalLimitReached or ErrLifetimeReached is returned if the
+// resource can't be renewed, renewalRefused tells them apart from other errors.
+func checkRenewal(resource *search.Resource, policy common.Policy, now time.Time) (common.Policy, bool, error) {
+	restore, err := restorable(resource, policy, now)
+	if err != nil {
+		return policy, false, err
+	}
+
+	renewal, err := renewalPolicy(resource, policy, now)
+	return renewal, restore, err
+}
+
+// renewalRefused returns true if the error is a reason the resource can't be renewed
+func renewalRefused(err error) bool {
+	return errors.Is(err, ErrNotRestorable) || errors.Is(err, ErrRenewalLimitReached) || errors.Is(err, ErrLifetimeReached)
+}
+
+// restoreResource restores a decommissioned resource by setting its status back to created and renewing it.  If the
+// renewal fails, the resource is decommissioned again so it isn't left with its old renewed_at date.  The restore is
+// reported and sent to the webhooks, the resource as it is after the restore is returned.
+func restoreResource(resource *search.Resource, policy common.Policy, actor, extension string) (*search.Resource, error) {
+	decommer, err := NewDecommissioner(AppConfig.Decommission.Endpoint, AppConfig.Decommission.Token, resource.ID, resource.Org, AppConfig.Decommission.EncryptToken)
+	if err != nil {
+		log.Errorf("Failed to restore resource %s, %s", resource.ID, err.Error())
+		return nil, err
+	}
+	decommer.User = actor
+
+	if err := decommer.Restore(); err != nil {
+		log.Errorf("Failed to restore resource %s, %s", resource.ID, err.Error())
+		reportPolicyEvent(policy, fmt.Sprintf("FAILED to restore %s (%s)", resource.FQDN, resource.ID), report.ERROR)
+		return nil, err
+	}
+
+	renewed, err := renewResource(resource, actor, extension)
+	if err != nil {
+		if err := decommer.SetStatus(); err != nil {
+			msg := fmt.Sprintf("Unable to roll back the restore of %s (%s)", resource.FQDN, resource.ID)
+			log.Errorf("%s: %s", msg, err)
+			reportPolicyEvent(policy, "FAILED "+msg, report.ERROR)
+		}
+		return nil, err
+	}
+	renewed.Status = "created"
+
+	msg := fmt.Sprintf("Restored %s (%s) created by %s", resource.FQDN, resource.ID, resource.SupportDepartmentContact)
+	if actor != "" {
+		msg = fmt.Sprintf("%s on behalf of %s", msg, actor)
+	}
+	log.Info(msg)
+	reportPolicyEvent(policy, msg, report.INFO)
+
+	sendWebhooks(&Event{
+		ID:     resource.ID,
+		Policy: policy.Name,
+		Tags:   renewed.Tags,
+		Action: "restore",
+	})
+
+	return renewed, nil
+}
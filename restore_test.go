@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRestorable(t *testing.T) {
+	policy := common.Policy{DecommissionAge: "30d", DestroyAge: "44d"}
+	now := time.Date(2019, time.February, 10, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		status    string
+		renewedAt string
+		restore   bool
+		err       error
+	}{
+		{"created", "2019/01/01 00:00:00", false, nil},
+		{"decom", "2019/01/01 00:00:00", true, nil},
+		{"decom", "2018/12/01 00:00:00", false, ErrNotRestorable},
+		{"deleted", "2019/01/01 00:00:00", false, ErrNotRestorable},
+	}
+
+	for _, test := range tests {
+		resource := &search.Resource{ID: "i-123", Status: test.status, RenewedAt: test.renewedAt}
+		restore, err := restorable(resource, policy, now)
+		if restore != test.restore || !errors.Is(err, test.err) {
+			t.Errorf("Expected %t (%v) for %+v, got %t (%v)", test.restore, test.err, test, restore, err)
+		}
+	}
+}
+
+func TestRestoreResource(t *testing.T) {
+	var status string
+	var tags map[string]map[string]string
+	var events []*Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/servers/fts/i-decom/status":
+			body := struct{ Status string }{}
+			json.NewDecoder(r.Body).Decode(&body)
+			status = body.Status
+		case "/v1/servers/fts/i-decom/tags":
+			json.NewDecoder(r.Body).Decode(&tags)
+		case "/webhook":
+			event := &Event{}
+			json.NewDecoder(r.Body).Decode(event)
+			events = append(events, event)
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	withTestConfig(t, common.Config{
+		Notify:           common.Notifier{Age: []string{"23d"}},
+		Decommission:     common.Decommissioner{Age: "30d", Endpoint: server.URL + "/v1/servers"},
+		Destroy:          common.Destroyer{Age: "44d"},
+		Tagging:          common.Tagging{Endpoint: server.URL + "/v1/servers"},
+		Token:            "sekret",
+		EncryptionSecret: "sekret",
+	})
+
+	origWebhooks := Webhooks
+	Webhooks = []Webhook{{Client: server.Client(), Endpoint: server.URL + "/webhook", Method: http.MethodPost, Actions: []string{"restore"}}}
+	defer func() { Webhooks = origWebhooks }()
+
+	orig := Finder
+	Finder = search.NewMemorySource([]*search.Document{
+		{ID: "i-decom", Source: map[string]interface{}{"yale:org": "fts", "status": "decom", "yale:renewed_at": time.Now().Add(-35 * 24 * time.Hour).Format("2006/01/02 15:04:05")}},
+	})
+	defer func() { Finder = orig }()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/reaper/resources/{id}/renew", ResourceRenewalHandler)
+	router.HandleFunc("/v1/reaper/renew/{id}", RenewalHander)
+
+	resource, err := Finder.DoGet("i-decom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := &RenewalSecret{ResourceID: "i-decom", Action: RenewAction, RenewedAt: resource.RenewedAt, Secrets: []string{"sekret"}}
+	renewalToken, err := secret.GenerateRenewalToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the renewal link offers to restore the decommissioned resource
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/reaper/renew/i-decom?token="+url.QueryEscape(renewalToken), nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `value="Restore"`) {
+		t.Errorf("Expected a page offering to restore the resource, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	token, err := bcrypt.GenerateFromPassword([]byte("sekret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/reaper/resources/i-decom/renew", nil)
+	req.Header.Set("X-Auth-Token", string(token))
+	req.Header.Set("X-Forwarded-User", "bob")
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected OK restoring, got %d", rr.Code)
+	}
+
+	if status != "created" {
+		t.Errorf("Expected the status to be restored to created, got %s", status)
+	}
+
+	if _, ok := tags["tags"]["yale:renewed_at"]; !ok {
+		t.Errorf("Expected renewed_at to be tagged, got %+v", tags)
+	}
+
+	if !strings.Contains(rr.Body.String(), `"status":"created"`) {
+		t.Errorf("Expected the schedule of the restored resource, got %s", rr.Body.String())
+	}
+
+	if len(events) != 1 || events[0].Action != "restore" || events[0].ID != "i-decom" {
+		t.Errorf("Expected a restore webhook, got %+v", events)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+	"github.com/YaleSpinup/reaper/search"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// Schedule is the lifecycle schedule of a resource
+type Schedule struct {
+	ID                 string     `json:"id"`
+	Policy             string     `json:"policy"`
+	Status             string     `json:"status"`
+	RenewedAt          time.Time  `json:"renewed_at"`
+	NotifiedAt         *time.Time `json:"notified_at,omitempty"`
+	NextNotificationAt *time.Time `json:"next_notification_at,omitempty"`
+	Extension          string     `json:"extension,omitempty"`
+	DecommissionAt     time.Time  `json:"decommission_at"`
+	DestroyAt          time.Time  `json:"destroy_at"`
+}
+
+// computeSchedule calculates the lifecycle schedule of a resource from the ages configured for its policy and the
+// extension it was last renewed with.  The next notification is the first notification age threshold that the
+// resource hasn't been notified for, it's only set for resources that haven't been decommissioned yet.
+func computeSchedule(resource *search.Resource, policy common.Policy) (*Schedule, error) {
+	renewedAt, err := time.Parse("2006/01/02 15:04:05", resource.RenewedAt)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse renewed_at (%s) as a time value: %s", resource.RenewedAt, err)
+	}
+
+	// the ages are relative to the start of the lifecycle, which honors the renewal extension
+	start, err := lifecycleStart(resource, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	decomAge, err := parseDuration(policy.DecommissionAge)
+	if err != nil {
+		return nil, err
+	}
+
+	destroyAge, err := parseDuration(policy.DestroyAge)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := &Schedule{
+		ID:             resource.ID,
+		Policy:         policy.Name,
+		Status:         resource.Status,
+		RenewedAt:      renewedAt,
+		Extension:      resource.Tag(RenewalExtensionTag),
+		DecommissionAt: start.Add(decomAge),
+		DestroyAt:      start.Add(destroyAge),
+	}
+
+	var notifiedAt time.Time
+	if resource.NotifiedAt != "" {
+		notifiedAt, err = time.Parse("2006/01/02 15:04:05", resource.NotifiedAt)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse notified_at (%s) as a time value: %s", resource.NotifiedAt, err)
+		}
+		schedule.NotifiedAt = &notifiedAt
+	}
+
+	if resource.Status != "created" {
+		return schedule, nil
+	}
+
+	ages := append([]string{}, policy.NotifyAge...)
+	sort.Sort(BySchedule(ages))
+	for _, age := range ages {
+		ageDuration, err := parseDuration(age)
+		if err != nil {
+			return nil, err
+		}
+
+		thresholdAt := start.Add(ageDuration)
+		if notifiedAt.Before(thresholdAt) {
+			schedule.NextNotificationAt = &thresholdAt
+			break
+		}
+	}
+
+	return schedule, nil
+}
+
+// ScheduleHandler returns the lifecycle schedule of a resource
+func ScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte{})
+		return
+	}
+
+	if !authenticateRequest(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte{})
+		return
+	}
+
+	resource, err := Finder.DoGet(id)
+	if errors.Is(err, search.ErrResourceNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Resource not found"))
+		return
+	}
+
+	if errors.Is(err, search.ErrSourceUnavailable) {
+		log.Errorf("Couldn't get the %s resource, %s", id, err.Error())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Failed connecting to elasticsearch"))
+		return
+	}
+
+	if err != nil {
+		log.Errorf("Couldn't get the %s resource from elasticsearch, %s", id, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed getting details about resource"))
+		return
+	}
+
+	policy, ok := policyFor(resource)
+	if !ok {
+		log.Warnf("%s doesn't match any policy, using the %s policy", id, policy.Name)
+	}
+
+	schedule, err := computeSchedule(resource, policy)
+	if err != nil {
+		log.Errorf("Couldn't compute the schedule for %s, %s", id, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed computing the resource schedule"))
+		return
+	}
+
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte{})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
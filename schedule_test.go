@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/reaper/search"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestComputeSchedule(t *testing.T) {
+	withTestConfig(t, testPlanConfig)
+	policy := policies()[0]
+
+	resource := &search.Resource{ID: "i-123", Status: "created", RenewedAt: "2019/01/01 00:00:00"}
+	schedule, err := computeSchedule(resource, policy)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if schedule.NotifiedAt != nil {
+		t.Errorf("Expected nil notified at, got %s", schedule.NotifiedAt)
+	}
+
+	if schedule.NextNotificationAt == nil || !schedule.NextNotificationAt.Equal(time.Date(2019, time.January, 24, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected next notification at 2019/01/24, got %v", schedule.NextNotificationAt)
+	}
+
+	if !schedule.DecommissionAt.Equal(time.Date(2019, time.January, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected decommission date %s", schedule.DecommissionAt)
+	}
+
+	if !schedule.DestroyAt.Equal(time.Date(2019, time.February, 14, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected destroy date %s", schedule.DestroyAt)
+	}
+
+	// notified after the first threshold, the next notification is the second threshold
+	resource.NotifiedAt = "2019/01/25 00:00:00"
+	schedule, err = computeSchedule(resource, policy)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if schedule.NextNotificationAt == nil || !schedule.NextNotificationAt.Equal(time.Date(2019, time.January, 30, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected next notification at 2019/01/30, got %v", schedule.NextNotificationAt)
+	}
+
+	// notified after all of the thresholds
+	resource.NotifiedAt = "2019/01/30 12:00:00"
+	if schedule, err = computeSchedule(resource, policy); err != nil || schedule.NextNotificationAt != nil {
+		t.Errorf("Expected no next notification, got %v (%v)", schedule, err)
+	}
+
+	// decommissioned resources aren't notified
+	resource.Status, resource.NotifiedAt = "decom", ""
+	if schedule, err = computeSchedule(resource, policy); err != nil || schedule.NextNotificationAt != nil {
+		t.Errorf("Expected no next notification for a decommissioned resource, got %v (%v)", schedule, err)
+	}
+
+	resource.RenewedAt = "yesterday"
+	if _, err = computeSchedule(resource, policy); err == nil {
+		t.Error("Expected error for bad renewed_at, got nil")
+	}
+}
+
+func TestScheduleHandler(t *testing.T) {
+	withTestConfig(t, testPlanConfig)
+	orig := Finder
+	Finder = testPlanSource
+	defer func() { Finder = orig }()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/reaper/resources/{id}/schedule", ScheduleHandler)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/reaper/resources/i-destroy/schedule", nil))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected forbidden without a token, got %d", rr.Code)
+	}
+
+	token, err := bcrypt.GenerateFromPassword([]byte("sekret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(id string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/v1/reaper/resources/"+id+"/schedule", nil)
+		req.Header.Set("X-Auth-Token", string(token))
+		return req
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, get("i-destroy"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected OK, got %d", rr.Code)
+	}
+
+	schedule := Schedule{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &schedule); err != nil {
+		t.Fatalf("Expected valid JSON schedule, got %s", err)
+	}
+
+	if schedule.ID != "i-destroy" || schedule.Status != "decom" || schedule.Policy != "default" {
+		t.Errorf("Unexpected schedule %+v", schedule)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, get("i-missing"))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected not found, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/reaper/resources/i-destroy/schedule", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,142 @@
+package search
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// connection is the connection and authentication configuration for the search engine
+type connection struct {
+	client              *http.Client
+	headers             http.Header
+	username            string
+	password            string
+	healthcheck         bool
+	healthcheckTimeout  time.Duration
+	healthcheckInterval time.Duration
+}
+
+// newConnection configures the http client, authentication and healthchecks from the searchEngine configuration
+func newConnection(config map[string]string) (*connection, error) {
+	conn := connection{
+		headers:     http.Header{},
+		username:    config["username"],
+		password:    config["password"],
+		healthcheck: true,
+	}
+
+	if apiKey, ok := config["apiKey"]; ok && apiKey != "" {
+		if conn.username != "" {
+			return nil, fmt.Errorf("only one of apiKey or username/password can be configured for the searchEngine")
+		}
+		conn.headers.Set("Authorization", "ApiKey "+apiKey)
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := 30 * time.Second
+	if t, ok := config["timeout"]; ok {
+		if timeout, err = time.ParseDuration(t); err != nil {
+			log.Errorf("Cannot parse timeout value '%s' as a duration, %s", t, err)
+			return nil, err
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	conn.client = &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+
+	if h, ok := config["healthcheck"]; ok {
+		if conn.healthcheck, err = strconv.ParseBool(h); err != nil {
+			log.Errorf("Cannot parse healthcheck value '%s' as boolean, %s", h, err)
+			return nil, err
+		}
+	}
+
+	if t, ok := config["healthcheckTimeout"]; ok {
+		if conn.healthcheckTimeout, err = time.ParseDuration(t); err != nil {
+			log.Errorf("Cannot parse healthcheckTimeout value '%s' as a duration, %s", t, err)
+			return nil, err
+		}
+	}
+
+	if i, ok := config["healthcheckInterval"]; ok {
+		if conn.healthcheckInterval, err = time.ParseDuration(i); err != nil {
+			log.Errorf("Cannot parse healthcheckInterval value '%s' as a duration, %s", i, err)
+			return nil, err
+		}
+	}
+
+	return &conn, nil
+}
+
+// newTLSConfig creates the TLS configuration with an optional private CA, client certificate for mTLS
+// and the option to skip verification of the server certificate
+func newTLSConfig(config map[string]string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile, ok := config["caFile"]; ok && caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read searchEngine caFile: %s", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in searchEngine caFile %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	certFile, keyFile := config["certFile"], config["keyFile"]
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("both certFile and keyFile are required for searchEngine client certificates")
+		}
+
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load searchEngine client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if insecure, ok := config["insecureSkipVerify"]; ok {
+		skip, err := strconv.ParseBool(insecure)
+		if err != nil {
+			log.Errorf("Cannot parse insecureSkipVerify value '%s' as boolean, %s", insecure, err)
+			return nil, err
+		}
+
+		if skip {
+			log.Warn("Skipping verification of the searchEngine TLS certificate")
+		}
+		tlsConfig.InsecureSkipVerify = skip
+	}
+
+	return tlsConfig, nil
+}
+
+// authorize sets the authentication headers on a request
+func (c *connection) authorize(req *http.Request) {
+	for k, v := range c.headers {
+		req.Header[k] = v
+	}
+
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
@@ -0,0 +1,96 @@
+package search
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/YaleSpinup/reaper/common"
+)
+
+func TestNewConnection(t *testing.T) {
+	bad := []map[string]string{
+		{"apiKey": "abc", "username": "bob"},
+		{"timeout": "forever"},
+		{"healthcheck": "maybe"},
+		{"healthcheckTimeout": "5"},
+		{"healthcheckInterval": "soon"},
+		{"insecureSkipVerify": "sure"},
+		{"certFile": "/tmp/cert.pem"},
+		{"caFile": "/does/not/exist.pem"},
+	}
+
+	for _, config := range bad {
+		if _, err := newConnection(config); err == nil {
+			t.Errorf("Expected error for connection config %+v, got nil", config)
+		}
+	}
+
+	conn, err := newConnection(map[string]string{"apiKey": "abc123", "timeout": "5s", "healthcheck": "false"})
+	if err != nil {
+		t.Fatalf("Expected nil error for valid connection config, got %s", err)
+	}
+
+	if conn.healthcheck {
+		t.Error("Expected healthcheck to be disabled")
+	}
+
+	if conn.client.Timeout.String() != "5s" {
+		t.Errorf("Expected client timeout to be 5s, got %s", conn.client.Timeout)
+	}
+
+	if conn.headers.Get("Authorization") != "ApiKey abc123" {
+		t.Errorf("Expected ApiKey authorization header, got %s", conn.headers.Get("Authorization"))
+	}
+}
+
+func TestRESTFinderAuthAndTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "reaper" || password != "sekret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"version": {"number": "8.11.0"}}`))
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatalf("Failed to write CA file: %s", err)
+	}
+
+	if _, err := NewRESTFinder(&common.Config{SearchEngine: map[string]string{"endpoint": server.URL}}); err == nil {
+		t.Error("Expected error connecting to server with an unknown CA, got nil")
+	}
+
+	if _, err := NewRESTFinder(&common.Config{SearchEngine: map[string]string{"endpoint": server.URL, "caFile": caFile}}); err == nil {
+		t.Error("Expected error connecting to server without credentials, got nil")
+	}
+
+	config := map[string]string{
+		"endpoint": server.URL,
+		"caFile":   caFile,
+		"username": "reaper",
+		"password": "sekret",
+	}
+	if _, err := NewRESTFinder(&common.Config{SearchEngine: config}); err != nil {
+		t.Errorf("Expected nil error connecting with credentials and CA, got %s", err)
+	}
+
+	insecure := map[string]string{
+		"endpoint":           server.URL,
+		"username":           "reaper",
+		"password":           "sekret",
+		"insecureSkipVerify": "true",
+	}
+	if _, err := NewRESTFinder(&common.Config{SearchEngine: insecure}); err != nil {
+		t.Errorf("Expected nil error connecting with insecureSkipVerify, got %s", err)
+	}
+}
@@ -0,0 +1,114 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// DefaultIndex is the elasticsearch index searched for resources
+	DefaultIndex = "resources"
+
+	// DefaultDocumentType is the elasticsearch mapping type of resources
+	DefaultDocumentType = "server"
+
+	// DefaultRenewedAtField is the field holding the time a resource was last renewed
+	DefaultRenewedAtField = "yale:renewed_at"
+
+	// DefaultCreatedAtField is the field holding the time a resource was created
+	DefaultCreatedAtField = "yale:created_at"
+
+	// DefaultStatusField is the field holding the status of a resource
+	DefaultStatusField = "status"
+
+	// DefaultOrgField is the field holding the org a resource belongs to
+	DefaultOrgField = "yale:org"
+)
+
+// Fields are the names of the document fields the reaper depends on
+type Fields struct {
+	RenewedAt string
+	CreatedAt string
+	Status    string
+	Org       string
+}
+
+// NewFields returns the field names from the searchEngine configuration, falling back to the defaults
+func NewFields(config map[string]string) Fields {
+	fields := Fields{
+		RenewedAt: DefaultRenewedAtField,
+		CreatedAt: DefaultCreatedAtField,
+		Status:    DefaultStatusField,
+		Org:       DefaultOrgField,
+	}
+
+	if f, ok := config["renewedAtField"]; ok && f != "" {
+		fields.RenewedAt = f
+	}
+
+	if f, ok := config["createdAtField"]; ok && f != "" {
+		fields.CreatedAt = f
+	}
+
+	if f, ok := config["statusField"]; ok && f != "" {
+		fields.Status = f
+	}
+
+	if f, ok := config["orgField"]; ok && f != "" {
+		fields.Org = f
+	}
+
+	return fields
+}
+
+// indexAndType returns the index and the (optional) document type from the searchEngine configuration
+func indexAndType(config map[string]string) (string, string) {
+	index := DefaultIndex
+	if i, ok := config["index"]; ok && i != "" {
+		index = i
+	}
+
+	rtype := DefaultDocumentType
+	if t, ok := config["documentType"]; ok {
+		rtype = t
+	}
+
+	return index, rtype
+}
+
+// decode deserializes a document source into a resource, reading the configured fields
+func (f Fields) decode(id string, data []byte) (*Resource, error) {
+	var r Resource
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+
+	var source map[string]interface{}
+	if err := json.Unmarshal(data, &source); err != nil {
+		return nil, err
+	}
+
+	r.ID = id
+	r.Source = source
+	r.Tags = Flatten(source)
+
+	for field, value := range map[string]*string{f.RenewedAt: &r.RenewedAt, f.CreatedAt: &r.CreatedAt, f.Status: &r.Status, f.Org: &r.Org} {
+		v, _ := lookup(source, field)
+		*value = stringValue(v)
+	}
+
+	return &r, nil
+}
+
+// stringValue returns the string representation of a document value, or an empty string if it's unset
+func stringValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,239 @@
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/YaleSpinup/reaper/common"
+	elastic "gopkg.in/olivere/elastic.v5"
+)
+
+// Filter operations supported by term queries
+const (
+	// TermOp matches the exact value of the field
+	TermOp = "term"
+	// TermsOp matches any one of a list of values
+	TermsOp = "terms"
+	// ExistsOp matches if the field has a value
+	ExistsOp = "exists"
+	// MissingOp matches if the field doesn't have a value
+	MissingOp = "missing"
+	// PrefixOp matches values starting with the value
+	PrefixOp = "prefix"
+	// WildcardOp matches values against a pattern where * matches any characters and ? matches a single character
+	WildcardOp = "wildcard"
+	// RangeOp matches numeric values within the gt, gte, lt and lte bounds
+	RangeOp = "range"
+)
+
+// NewFilterQueryList generates a list of term queries from the configured filters, validating each of them
+func NewFilterQueryList(filters []common.Filter) ([]TermQuery, error) {
+	var tqs []TermQuery
+	for _, f := range filters {
+		tq := TermQuery{
+			Term:   f.Field,
+			Value:  f.Value,
+			Op:     f.Op,
+			Values: f.Values,
+			Not:    f.Not,
+			Gt:     f.Gt,
+			Gte:    f.Gte,
+			Lt:     f.Lt,
+			Lte:    f.Lte,
+		}
+
+		if err := tq.Validate(); err != nil {
+			return nil, err
+		}
+		tqs = append(tqs, tq)
+	}
+	return tqs, nil
+}
+
+// op returns the operation of the term query, defaulting to an exact term match
+func (tq TermQuery) op() string {
+	if tq.Op == "" {
+		return TermOp
+	}
+	return strings.ToLower(tq.Op)
+}
+
+// Validate checks that the term query has what its operation needs
+func (tq TermQuery) Validate() error {
+	if tq.Term == "" {
+		return fmt.Errorf("field is required for %s filter", tq.op())
+	}
+
+	switch tq.op() {
+	case TermOp:
+	case PrefixOp, WildcardOp:
+		if tq.Value == "" {
+			return fmt.Errorf("value is required for %s filter on %s", tq.op(), tq.Term)
+		}
+	case TermsOp:
+		if len(tq.Values) == 0 {
+			return fmt.Errorf("values are required for terms filter on %s", tq.Term)
+		}
+	case RangeOp:
+		if tq.Gt == "" && tq.Gte == "" && tq.Lt == "" && tq.Lte == "" {
+			return fmt.Errorf("at least one of gt, gte, lt or lte is required for range filter on %s", tq.Term)
+		}
+
+		for _, b := range []string{tq.Gt, tq.Gte, tq.Lt, tq.Lte} {
+			if _, err := strconv.ParseFloat(b, 64); b != "" && err != nil {
+				return fmt.Errorf("range bound %s for filter on %s is not a number", b, tq.Term)
+			}
+		}
+	case ExistsOp, MissingOp:
+	default:
+		return fmt.Errorf("unsupported filter operation %s on %s", tq.Op, tq.Term)
+	}
+
+	return nil
+}
+
+// query converts the term query into an elasticsearch query and reports if it belongs in the must_not context.
+// String matches are done against the keyword field.
+func (tq TermQuery) query() (elastic.Query, bool, error) {
+	if err := tq.Validate(); err != nil {
+		return nil, false, err
+	}
+
+	keyword := fmt.Sprintf("%s.keyword", tq.Term)
+
+	switch tq.op() {
+	case TermsOp:
+		values := make([]interface{}, len(tq.Values))
+		for i, v := range tq.Values {
+			values[i] = v
+		}
+		return elastic.NewTermsQuery(keyword, values...), tq.Not, nil
+	case ExistsOp:
+		return elastic.NewExistsQuery(tq.Term), tq.Not, nil
+	case MissingOp:
+		return elastic.NewExistsQuery(tq.Term), !tq.Not, nil
+	case PrefixOp:
+		return elastic.NewPrefixQuery(keyword, tq.Value), tq.Not, nil
+	case WildcardOp:
+		return elastic.NewWildcardQuery(keyword, tq.Value), tq.Not, nil
+	case RangeOp:
+		rangeQuery := elastic.NewRangeQuery(tq.Term)
+		if tq.Gt != "" {
+			rangeQuery.Gt(tq.Gt)
+		}
+		if tq.Gte != "" {
+			rangeQuery.Gte(tq.Gte)
+		}
+		if tq.Lt != "" {
+			rangeQuery.Lt(tq.Lt)
+		}
+		if tq.Lte != "" {
+			rangeQuery.Lte(tq.Lte)
+		}
+		return rangeQuery, tq.Not, nil
+	}
+
+	return elastic.NewTermQuery(keyword, tq.Value), tq.Not, nil
+}
+
+// match evaluates the term query against the document source the same way elasticsearch would.  Multi-valued
+// fields match if any of their values match.
+func (tq TermQuery) match(source map[string]interface{}) bool {
+	v, _ := lookup(source, tq.Term)
+	values := fieldValues(v)
+
+	var matched bool
+	switch tq.op() {
+	case ExistsOp:
+		matched = len(values) > 0
+	case MissingOp:
+		matched = len(values) == 0
+	default:
+		for _, v := range values {
+			if tq.matchValue(v) {
+				matched = true
+				break
+			}
+		}
+	}
+
+	return matched != tq.Not
+}
+
+// matchValue checks a single field value against the term query
+func (tq TermQuery) matchValue(v string) bool {
+	switch tq.op() {
+	case TermsOp:
+		for _, value := range tq.Values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	case PrefixOp:
+		return strings.HasPrefix(v, tq.Value)
+	case WildcardOp:
+		pattern := regexp.QuoteMeta(tq.Value)
+		pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+		pattern = strings.ReplaceAll(pattern, `\?`, ".")
+		matched, err := regexp.MatchString("^"+pattern+"$", v)
+		return err == nil && matched
+	case RangeOp:
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return false
+		}
+
+		bounds := []struct {
+			bound string
+			ok    func(b float64) bool
+		}{
+			{tq.Gt, func(b float64) bool { return n > b }},
+			{tq.Gte, func(b float64) bool { return n >= b }},
+			{tq.Lt, func(b float64) bool { return n < b }},
+			{tq.Lte, func(b float64) bool { return n <= b }},
+		}
+
+		for _, b := range bounds {
+			if b.bound == "" {
+				continue
+			}
+
+			f, err := strconv.ParseFloat(b.bound, 64)
+			if err != nil || !b.ok(f) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return v == tq.Value
+}
+
+// fieldValues returns the string representations of a (possibly multi-valued) field value
+func fieldValues(v interface{}) []string {
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			values = append(values, fieldValues(item)...)
+		}
+		return values
+	}
+
+	return []string{stringValue(v)}
+}
+
+// Matches checks if the resource source matches all of the term queries
+func (r *Resource) Matches(tqs ...TermQuery) bool {
+	for _, tq := range tqs {
+		if !tq.match(r.Source) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package search
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/YaleSpinup/reaper/common"
+)
+
+var testFilterSource = map[string]interface{}{
+	"yale:org":      "fts",
+	"instance_type": "t3.small",
+	"cpus":          float64(2),
+	"tags":          []interface{}{"lab", "course"},
+}
+
+func TestNewFilterQueryList(t *testing.T) {
+	tqs, err := NewFilterQueryList([]common.Filter{
+		{Field: "yale:org", Op: "terms", Values: []string{"a", "b"}, Not: true},
+		{Field: "cpus", Op: "range", Gte: "2"},
+	})
+	if err != nil {
+		t.Fatalf("Expected nil error for valid filters, got %s", err)
+	}
+
+	if len(tqs) != 2 || !tqs[0].Not || tqs[1].Gte != "2" {
+		t.Errorf("Unexpected term queries %+v", tqs)
+	}
+
+	bad := []common.Filter{
+		{Op: "exists"},
+		{Field: "foo", Op: "terms"},
+		{Field: "foo", Op: "prefix"},
+		{Field: "foo", Op: "range"},
+		{Field: "foo", Op: "range", Lt: "ten"},
+		{Field: "foo", Op: "fuzzy", Value: "bar"},
+	}
+
+	for _, f := range bad {
+		if _, err := NewFilterQueryList([]common.Filter{f}); err == nil {
+			t.Errorf("Expected error for invalid filter %+v, got nil", f)
+		}
+	}
+}
+
+func TestTermQueryMatch(t *testing.T) {
+	tests := []struct {
+		tq       TermQuery
+		expected bool
+	}{
+		{TermQuery{Term: "yale:org", Value: "fts"}, true},
+		{TermQuery{Term: "yale:org", Value: "fts", Not: true}, false},
+		{TermQuery{Term: "yale:org", Op: "terms", Values: []string{"abc", "fts"}}, true},
+		{TermQuery{Term: "yale:org", Op: "terms", Values: []string{"abc", "def"}}, false},
+		{TermQuery{Term: "yale:org", Op: "terms", Values: []string{"abc", "fts"}, Not: true}, false},
+		{TermQuery{Term: "instance_type", Op: "exists"}, true},
+		{TermQuery{Term: "nope", Op: "exists"}, false},
+		{TermQuery{Term: "nope", Op: "missing"}, true},
+		{TermQuery{Term: "instance_type", Op: "prefix", Value: "t3."}, true},
+		{TermQuery{Term: "instance_type", Op: "prefix", Value: "m5."}, false},
+		{TermQuery{Term: "instance_type", Op: "wildcard", Value: "t?.*"}, true},
+		{TermQuery{Term: "instance_type", Op: "wildcard", Value: "t3.l*"}, false},
+		{TermQuery{Term: "cpus", Op: "range", Gte: "2", Lt: "8"}, true},
+		{TermQuery{Term: "cpus", Op: "range", Gt: "2"}, false},
+		{TermQuery{Term: "tags", Value: "course"}, true},
+	}
+
+	for _, test := range tests {
+		if actual := test.tq.match(testFilterSource); actual != test.expected {
+			t.Errorf("Expected %+v to match %t, got %t", test.tq, test.expected, actual)
+		}
+	}
+}
+
+func TestConstructBoolQueryFilters(t *testing.T) {
+	q, err := constructBoolQuery([]*DateRangeQuery{{
+		Field: "yale:renewed_at",
+		Lte:   "now-30d",
+		TermFilter: []TermQuery{
+			{Term: "status", Value: "created"},
+			{Term: "yale:org", Op: "terms", Values: []string{"a", "b"}, Not: true},
+			{Term: "yale:protected", Op: "missing"},
+			{Term: "instance_type", Op: "prefix", Value: "t3."},
+			{Term: "cpus", Op: "range", Lt: "8"},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("Expected nil error constructing query, got %s", err)
+	}
+
+	src, err := q.Source()
+	if err != nil {
+		t.Fatalf("Expected nil error getting query source, got %s", err)
+	}
+
+	data, err := json.Marshal(src)
+	if err != nil {
+		t.Fatalf("Expected nil error marshalling query, got %s", err)
+	}
+
+	query := string(data)
+	t.Logf("Got query %s", query)
+
+	for _, expected := range []string{
+		`"must_not":[{"terms":{"yale:org.keyword":["a","b"]}},{"exists":{"field":"yale:protected"}}]`,
+		`{"term":{"status.keyword":"created"}}`,
+		`{"prefix":{"instance_type.keyword":"t3."}}`,
+		`{"range":{"cpus":{"from":null,"include_lower":true,"include_upper":false,"to":"8"}}}`,
+	} {
+		if !strings.Contains(query, expected) {
+			t.Errorf("Expected query to contain %s", expected)
+		}
+	}
+
+	if _, err := constructBoolQuery([]*DateRangeQuery{{TermFilter: []TermQuery{{Term: "foo", Op: "bogus"}}}}); err == nil {
+		t.Error("Expected error constructing query with an invalid filter, got nil")
+	}
+}
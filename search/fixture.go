@@ -0,0 +1,314 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Document is a single resource document in the shape elasticsearch returns it
+type Document struct {
+	Index  string                 `json:"_index,omitempty"`
+	Type   string                 `json:"_type,omitempty"`
+	ID     string                 `json:"_id"`
+	Source map[string]interface{} `json:"_source"`
+}
+
+// MemorySource is an in-memory resource source.  It evaluates the same date range and term queries
+// as elasticsearch against a list of documents and is mostly useful for local development and testing.
+type MemorySource struct {
+	Documents []*Document
+	Index     string
+	Type      string
+	Fields    Fields
+	mu        sync.RWMutex
+}
+
+// NewMemorySource creates a new in-memory resource source from a list of documents
+func NewMemorySource(docs []*Document) *MemorySource {
+	return &MemorySource{
+		Documents: docs,
+		Index:     DefaultIndex,
+		Type:      DefaultDocumentType,
+		Fields:    NewFields(nil),
+	}
+}
+
+// NewFixtureSource creates a new in-memory resource source from a JSON file containing a list of documents, ie.
+//
+//	[
+//	  {"_id": "i-abc123", "_source": {"yale:org": "fts", "status": "created", "yale:renewed_at": "2019/01/01 00:00:00"}}
+//	]
+func NewFixtureSource(file string) (*MemorySource, error) {
+	log.Debugf("Loading resource fixtures from %s", file)
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []*Document
+	if err := json.Unmarshal(data, &docs); err != nil {
+		return nil, fmt.Errorf("unable to decode resource fixtures from %s: %s", file, err)
+	}
+
+	log.Infof("Loaded %d resource fixtures from %s", len(docs), file)
+
+	return NewMemorySource(docs), nil
+}
+
+// DoGet gets a resource by id
+func (m *MemorySource) DoGet(id string) (*Resource, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, doc := range m.Documents {
+		if doc.ID == id && doc.matchesIndex(m.Index, m.Type) {
+			return doc.resource(m.Fields)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s in %s/%s", ErrResourceNotFound, id, m.Index, m.Type)
+}
+
+// DoDateRangeQuery returns every resource matching all of the date range queries
+func (m *MemorySource) DoDateRangeQuery(drqs ...*DateRangeQuery) ([]*Resource, error) {
+	var resourceList []*Resource
+	_, err := m.ForEachDateRangeQuery(func(r *Resource) error {
+		resourceList = append(resourceList, r)
+		return nil
+	}, drqs...)
+	if err != nil {
+		return nil, err
+	}
+
+	return resourceList, nil
+}
+
+// ForEachDateRangeQuery calls fn for each resource matching all of the date range queries
+func (m *MemorySource) ForEachDateRangeQuery(fn ResourceFunc, drqs ...*DateRangeQuery) (*QueryStats, error) {
+	stats := &QueryStats{}
+	now := time.Now()
+
+	m.mu.RLock()
+	var matches []*Document
+	for _, doc := range m.Documents {
+		if !doc.matchesIndex(m.Index, m.Type) {
+			continue
+		}
+
+		match, err := doc.matches(now, drqs)
+		if err != nil {
+			m.mu.RUnlock()
+			return stats, err
+		}
+
+		if match {
+			matches = append(matches, doc)
+		}
+	}
+	m.mu.RUnlock()
+
+	stats.TotalHits = int64(len(matches))
+	for _, doc := range matches {
+		r, err := doc.resource(m.Fields)
+		if err != nil {
+			log.Errorln("Couldn't deserialize fixture into resource", err)
+			continue
+		}
+
+		if err := fn(r); err != nil {
+			return stats, err
+		}
+		stats.Processed++
+	}
+
+	return stats, nil
+}
+
+// matchesIndex checks if the document belongs to the index and type, unset values match anything
+func (d *Document) matchesIndex(index, rtype string) bool {
+	if d.Index != "" && index != "" && d.Index != index {
+		return false
+	}
+
+	if d.Type != "" && rtype != "" && d.Type != rtype {
+		return false
+	}
+
+	return true
+}
+
+// matches evaluates the date range queries and their term filters against the document source
+func (d *Document) matches(now time.Time, drqs []*DateRangeQuery) (bool, error) {
+	for _, drq := range drqs {
+		ok, err := drq.match(now, d.Source)
+		if err != nil || !ok {
+			return false, err
+		}
+
+		for _, tq := range drq.TermFilter {
+			if !tq.match(d.Source) {
+				return false, nil
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// resource converts the document into a resource
+func (d *Document) resource(fields Fields) (*Resource, error) {
+	data, err := json.Marshal(d.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	return fields.decode(d.ID, data)
+}
+
+// match evaluates the date range query against the value of the field in the source
+func (drq *DateRangeQuery) match(now time.Time, source map[string]interface{}) (bool, error) {
+	layouts := dateLayouts(drq.Format)
+
+	field, _ := lookup(source, drq.Field)
+	v, ok := field.(string)
+	if !ok || v == "" {
+		return false, nil
+	}
+
+	value, err := parseDate(v, layouts)
+	if err != nil {
+		log.Debugf("Couldn't parse %s value '%s' as a date, %s", drq.Field, v, err)
+		return false, nil
+	}
+
+	bounds := []struct {
+		expr string
+		ok   func(t time.Time) bool
+	}{
+		{drq.Gt, func(t time.Time) bool { return value.After(t) }},
+		{drq.Gte, func(t time.Time) bool { return !value.Before(t) }},
+		{drq.From, func(t time.Time) bool { return !value.Before(t) }},
+		{drq.Lt, func(t time.Time) bool { return value.Before(t) }},
+		{drq.Lte, func(t time.Time) bool { return !value.After(t) }},
+		{drq.To, func(t time.Time) bool { return !value.After(t) }},
+	}
+
+	for _, b := range bounds {
+		if b.expr == "" {
+			continue
+		}
+
+		t, err := parseDateMath(b.expr, now, layouts)
+		if err != nil {
+			return false, err
+		}
+
+		if !b.ok(t) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// dateLayouts converts an elasticsearch (joda) date format into go time layouts.  Multiple
+// formats can be separated with '||' just like in elasticsearch.
+func dateLayouts(format string) []string {
+	if format == "" {
+		return []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+	}
+
+	replacer := strings.NewReplacer(
+		"yyyy", "2006",
+		"YYYY", "2006",
+		"MM", "01",
+		"dd", "02",
+		"HH", "15",
+		"mm", "04",
+		"ss", "05",
+	)
+
+	var layouts []string
+	for _, f := range strings.Split(format, "||") {
+		layouts = append(layouts, replacer.Replace(f))
+	}
+	return layouts
+}
+
+// parseDate parses a date with the first matching layout
+func parseDate(value string, layouts []string) (time.Time, error) {
+	var err error
+	for _, layout := range layouts {
+		var t time.Time
+		if t, err = time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// parseDateMath parses simple elasticsearch date math expressions like 'now', 'now-30d' or 'now+1h'.  Anything
+// that doesn't start with 'now' is parsed as a date in one of the given layouts.  Rounding isn't supported.
+func parseDateMath(expr string, now time.Time, layouts []string) (time.Time, error) {
+	if !strings.HasPrefix(expr, "now") {
+		return parseDate(expr, layouts)
+	}
+
+	t := now
+	rest := strings.TrimPrefix(expr, "now")
+	for rest != "" {
+		op := rest[0]
+		if op != '+' && op != '-' {
+			return t, fmt.Errorf("unsupported date math expression %s", expr)
+		}
+		rest = rest[1:]
+
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+
+		if i == 0 || i == len(rest) {
+			return t, fmt.Errorf("unsupported date math expression %s", expr)
+		}
+
+		num, err := strconv.Atoi(rest[:i])
+		if err != nil {
+			return t, err
+		}
+
+		if op == '-' {
+			num = -num
+		}
+
+		switch rest[i] {
+		case 'y':
+			t = t.AddDate(num, 0, 0)
+		case 'M':
+			t = t.AddDate(0, num, 0)
+		case 'w':
+			t = t.AddDate(0, 0, num*7)
+		case 'd':
+			t = t.AddDate(0, 0, num)
+		case 'h', 'H':
+			t = t.Add(time.Duration(num) * time.Hour)
+		case 'm':
+			t = t.Add(time.Duration(num) * time.Minute)
+		case 's':
+			t = t.Add(time.Duration(num) * time.Second)
+		default:
+			return t, fmt.Errorf("unsupported date math unit in expression %s", expr)
+		}
+		rest = rest[i+1:]
+	}
+
+	return t, nil
+}
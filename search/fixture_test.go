@@ -0,0 +1,139 @@
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var testFixtures = []byte(`[
+	{"_index": "resources", "_type": "server", "_id": "i-old", "_source": {"yale:org": "fts", "status": "created", "yale:subsidized": "true", "yale:renewed_at": "2019/01/01 00:00:00"}},
+	{"_id": "i-new", "_source": {"yale:org": "fts", "status": "created", "yale:subsidized": "true", "yale:renewed_at": "2999/01/01 00:00:00"}},
+	{"_id": "i-decom", "_source": {"yale:org": "fts", "status": "decom", "yale:subsidized": "true", "yale:renewed_at": "2019/01/01 00:00:00"}},
+	{"_id": "i-unsubsidized", "_source": {"yale:org": "fts", "status": "created", "yale:subsidized": false, "yale:renewed_at": "2019/01/01 00:00:00"}},
+	{"_index": "other", "_id": "i-other", "_source": {"yale:org": "fts", "status": "created", "yale:subsidized": "true", "yale:renewed_at": "2019/01/01 00:00:00"}}
+]`)
+
+func newTestFixtureSource(t *testing.T) *MemorySource {
+	file := filepath.Join(t.TempDir(), "resources.json")
+	if err := os.WriteFile(file, testFixtures, 0600); err != nil {
+		t.Fatalf("Failed to write test fixtures: %s", err)
+	}
+
+	source, err := NewFixtureSource(file)
+	if err != nil {
+		t.Fatalf("Expected nil error loading fixtures, got %s", err)
+	}
+
+	return source
+}
+
+func TestFixtureDoGet(t *testing.T) {
+	source := newTestFixtureSource(t)
+
+	r, err := source.DoGet("i-old")
+	if err != nil {
+		t.Fatalf("Expected nil error getting resource, got %s", err)
+	}
+
+	expected := &Resource{ID: "i-old", Org: "fts", Status: "created", RenewedAt: "2019/01/01 00:00:00"}
+	r.Source, r.Tags = nil, nil
+	if !reflect.DeepEqual(expected, r) {
+		t.Errorf("Expected %+v, got %+v", expected, r)
+	}
+
+	if _, err := source.DoGet("i-missing"); err == nil {
+		t.Error("Expected error getting missing resource, got nil")
+	}
+}
+
+func TestFixtureDoDateRangeQuery(t *testing.T) {
+	source := newTestFixtureSource(t)
+
+	resources, err := source.DoDateRangeQuery(&DateRangeQuery{
+		Field:  "yale:renewed_at",
+		Format: "YYYY/MM/dd HH:mm:ss",
+		Lte:    "now-30d",
+		TermFilter: []TermQuery{
+			{Term: "yale:subsidized", Value: "true"},
+			{Term: "status", Value: "created"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected nil error from query, got %s", err)
+	}
+
+	var ids []string
+	for _, r := range resources {
+		ids = append(ids, r.ID)
+	}
+	sort.Strings(ids)
+
+	expected := []string{"i-old"}
+	if !reflect.DeepEqual(expected, ids) {
+		t.Errorf("Expected resources %v, got %v", expected, ids)
+	}
+}
+
+func TestParseDateMath(t *testing.T) {
+	now := time.Date(2020, 3, 15, 12, 0, 0, 0, time.UTC)
+	layouts := dateLayouts("YYYY/MM/dd HH:mm:ss")
+
+	tests := map[string]time.Time{
+		"now":                 now,
+		"now-30d":             now.AddDate(0, 0, -30),
+		"now+1h":              now.Add(time.Hour),
+		"now-1M-2w":           now.AddDate(0, -1, -14),
+		"now-90s":             now.Add(-90 * time.Second),
+		"2019/01/02 03:04:05": time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	for expr, expected := range tests {
+		actual, err := parseDateMath(expr, now, layouts)
+		if err != nil {
+			t.Errorf("Expected nil error parsing %s, got %s", expr, err)
+			continue
+		}
+
+		if !expected.Equal(actual) {
+			t.Errorf("Expected %s to be %s, got %s", expr, expected, actual)
+		}
+	}
+
+	for _, expr := range []string{"now-", "now-1x", "now/d", "yesterday"} {
+		if _, err := parseDateMath(expr, now, layouts); err == nil {
+			t.Errorf("Expected error parsing %s, got nil", expr)
+		}
+	}
+}
+
+func TestFixtureConfiguredFields(t *testing.T) {
+	source := NewMemorySource([]*Document{
+		{Index: "servers", ID: "i-1", Source: map[string]interface{}{"org": "fts", "state": "created", "renewed": "2019/01/01 00:00:00", "created": "2018/12/01 00:00:00"}},
+		{Index: "resources", ID: "i-2", Source: map[string]interface{}{"org": "fts", "state": "created", "renewed": "2019/01/01 00:00:00"}},
+	})
+	source.Index, source.Type = indexAndType(map[string]string{"index": "servers", "documentType": ""})
+	source.Fields = NewFields(map[string]string{"renewedAtField": "renewed", "createdAtField": "created", "statusField": "state", "orgField": "org"})
+
+	resources, err := source.DoDateRangeQuery(&DateRangeQuery{
+		Field:      source.Fields.RenewedAt,
+		Format:     "YYYY/MM/dd HH:mm:ss",
+		Lte:        "now-30d",
+		TermFilter: []TermQuery{{Term: source.Fields.Status, Value: "created"}},
+	})
+	if err != nil {
+		t.Fatalf("Expected nil error from query, got %s", err)
+	}
+
+	if len(resources) != 1 {
+		t.Fatalf("Expected 1 resource from the servers index, got %d", len(resources))
+	}
+
+	r := resources[0]
+	if r.ID != "i-1" || r.Org != "fts" || r.Status != "created" || r.RenewedAt != "2019/01/01 00:00:00" || r.CreatedAt != "2018/12/01 00:00:00" {
+		t.Errorf("Expected configured fields to be decoded into the resource, got %+v", r)
+	}
+}
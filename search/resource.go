@@ -1,5 +1,11 @@
 package search
 
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
 // Resource is the resource object returned from elasticsearch
 type Resource struct {
 	Account                  string
@@ -10,7 +16,94 @@ type Resource struct {
 	Provider                 string
 	Status                   string
 	RenewedAt                string `json:"yale:renewed_at,omitempty"`
+	CreatedAt                string `json:"yale:created_at,omitempty"`
 	NotifiedAt               string `json:"yale:notified_at,omitempty"`
 	FQDN                     string `json:"yale:fqdn,omitempty"`
 	Org                      string `json:"yale:org,omitempty"`
+
+	// Source is the full document source
+	Source map[string]interface{} `json:"-"`
+
+	// Tags is the document source flattened into a map of strings, nested fields are joined with a '.'
+	Tags map[string]string `json:"-"`
+}
+
+// Get returns the value of a field in the document source.  Nested fields can be referenced with a
+// dotted path (ie. 'meta.project') just like in elasticsearch.
+func (r *Resource) Get(field string) (interface{}, bool) {
+	return lookup(r.Source, field)
+}
+
+// Tag returns the string value of a field from the flattened source, or an empty string if it's not set
+func (r *Resource) Tag(key string) string {
+	return r.Tags[key]
+}
+
+// TagKeys returns the sorted list of flattened source keys
+func (r *Resource) TagKeys() []string {
+	keys := make([]string, 0, len(r.Tags))
+	for k := range r.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Flatten flattens a document source into a map of strings.  Nested objects are joined with a '.', lists of
+// values are joined with a ',' and lists of objects are indexed (ie. 'disks.0.size').
+func Flatten(source map[string]interface{}) map[string]string {
+	tags := map[string]string{}
+	flatten(tags, "", source)
+	return tags
+}
+
+func flatten(tags map[string]string, prefix string, v interface{}) {
+	switch value := v.(type) {
+	case nil:
+		return
+	case map[string]interface{}:
+		for k, item := range value {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flatten(tags, key, item)
+		}
+	case []interface{}:
+		var scalars []string
+		for i, item := range value {
+			switch item.(type) {
+			case map[string]interface{}, []interface{}:
+				flatten(tags, prefix+"."+strconv.Itoa(i), item)
+			case nil:
+			default:
+				scalars = append(scalars, stringValue(item))
+			}
+		}
+
+		if len(scalars) > 0 {
+			tags[prefix] = strings.Join(scalars, ",")
+		}
+	default:
+		tags[prefix] = stringValue(value)
+	}
+}
+
+// lookup finds a field in a document source, first by its full name and then by walking the dotted path
+func lookup(source map[string]interface{}, field string) (interface{}, bool) {
+	if v, ok := source[field]; ok {
+		return v, true
+	}
+
+	parts := strings.SplitN(field, ".", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+
+	nested, ok := source[parts[0]].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	return lookup(nested, parts[1])
 }
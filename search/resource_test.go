@@ -1,9 +1,119 @@
 package search
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
 )
 
-func TestResource(t *testing.T) {
-	t.Log("No tests")
+var testResourceDocument = []byte(`{
+	"id": "i-0123456789",
+	"yale:org": "spinup",
+	"status": "created",
+	"instance_type": "t3.small",
+	"meta": {
+		"project": "moonshot",
+		"cost": {"center": "CC1234"}
+	},
+	"security_groups": ["sg-1", "sg-2"],
+	"disks": [{"size": 8}, {"size": 100}],
+	"terminated_at": null
+}`)
+
+func TestDecodeResourceSource(t *testing.T) {
+	r, err := NewFields(nil).decode("i-0123456789", testResourceDocument)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if r.Source == nil {
+		t.Fatal("expected resource source to be set")
+	}
+
+	if r.Status != "created" || r.Org != "spinup" {
+		t.Errorf("expected status and org to be decoded, got %s and %s", r.Status, r.Org)
+	}
+
+	if v := r.Tag("meta.cost.center"); v != "CC1234" {
+		t.Errorf("expected tag meta.cost.center to be CC1234, got %s", v)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	var source map[string]interface{}
+	if err := json.Unmarshal(testResourceDocument, &source); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"id":               "i-0123456789",
+		"yale:org":         "spinup",
+		"status":           "created",
+		"instance_type":    "t3.small",
+		"meta.project":     "moonshot",
+		"meta.cost.center": "CC1234",
+		"security_groups":  "sg-1,sg-2",
+		"disks.0.size":     "8",
+		"disks.1.size":     "100",
+	}
+
+	if out := Flatten(source); !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %+v, got %+v", expected, out)
+	}
+}
+
+func TestResourceGet(t *testing.T) {
+	r := Resource{
+		Source: map[string]interface{}{
+			"meta.flat": "dotted",
+			"meta": map[string]interface{}{
+				"project": "moonshot",
+			},
+		},
+	}
+
+	if v, ok := r.Get("meta.project"); !ok || v != "moonshot" {
+		t.Errorf("expected meta.project to be moonshot, got %v (%t)", v, ok)
+	}
+
+	if v, ok := r.Get("meta.flat"); !ok || v != "dotted" {
+		t.Errorf("expected meta.flat to be dotted, got %v (%t)", v, ok)
+	}
+
+	if _, ok := r.Get("meta.missing"); ok {
+		t.Error("expected meta.missing not to be found")
+	}
+
+	if _, ok := (&Resource{}).Get("meta"); ok {
+		t.Error("expected lookup on an empty resource not to be found")
+	}
+}
+
+func TestResourceTagKeys(t *testing.T) {
+	r := Resource{Tags: map[string]string{"b": "2", "a": "1", "c": "3"}}
+	if keys := r.TagKeys(); !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+		t.Errorf("expected sorted keys, got %v", keys)
+	}
+
+	if r.Tag("missing") != "" {
+		t.Error("expected missing tag to be empty")
+	}
+}
+
+func TestResourceMatchesNestedField(t *testing.T) {
+	r := Resource{
+		Source: map[string]interface{}{
+			"meta": map[string]interface{}{
+				"project": "moonshot",
+			},
+		},
+	}
+
+	if !r.Matches(TermQuery{Term: "meta.project", Value: "moonshot"}) {
+		t.Error("expected resource to match nested field")
+	}
+
+	if r.Matches(TermQuery{Term: "meta.project", Value: "other"}) {
+		t.Error("expected resource not to match nested field with a different value")
+	}
 }
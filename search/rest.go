@@ -0,0 +1,321 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/YaleSpinup/reaper/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// RESTFinder finds resources using the typeless REST api of elasticsearch 7/8 and opensearch
+type RESTFinder struct {
+	Client    *http.Client
+	Endpoint  string
+	conn      *connection
+	Index     string
+	Fields    Fields
+	PageSize  int
+	KeepAlive string
+}
+
+// errNotFound is returned by requests to the REST api that respond with a 404
+var errNotFound = errors.New("not found")
+
+// restHit is a single document hit in a search or get response
+type restHit struct {
+	ID     string          `json:"_id"`
+	Found  *bool           `json:"found,omitempty"`
+	Source json.RawMessage `json:"_source"`
+}
+
+// restSearchResult is the response from the search and scroll apis
+type restSearchResult struct {
+	ScrollID string `json:"_scroll_id"`
+	Took     int64  `json:"took"`
+	Hits     struct {
+		Total json.RawMessage `json:"total"`
+		Hits  []restHit       `json:"hits"`
+	} `json:"hits"`
+}
+
+// NewRESTFinder creates a new finder for the typeless elasticsearch 7/8 and opensearch REST apis
+func NewRESTFinder(config *common.Config) (*RESTFinder, error) {
+	log.Debugf("Configuring REST Finder with endpoint %s", config.SearchEngine["endpoint"])
+
+	conn, err := newConnection(config.SearchEngine)
+	if err != nil {
+		log.Errorln("Couldn't configure the elasticsearch connection", err)
+		return nil, err
+	}
+
+	finder := RESTFinder{
+		Client:    conn.client,
+		conn:      conn,
+		Endpoint:  "http://127.0.0.1:9200",
+		PageSize:  DefaultPageSize,
+		KeepAlive: DefaultKeepAlive,
+	}
+
+	if endpoint, ok := config.SearchEngine["endpoint"]; ok {
+		finder.Endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+	log.Debugf("Setting elasticsearch URL to %s", finder.Endpoint)
+
+	// mapping types don't exist in the typeless apis, only the index is used
+	finder.Index, _ = indexAndType(config.SearchEngine)
+	finder.Fields = NewFields(config.SearchEngine)
+
+	if pageSize, ok := config.SearchEngine["pageSize"]; ok {
+		p, err := strconv.Atoi(pageSize)
+		if err != nil || p <= 0 {
+			log.Errorf("Cannot parse pageSize value '%s' as a positive integer, %s", pageSize, err)
+			return nil, fmt.Errorf("invalid pageSize '%s'", pageSize)
+		}
+		finder.PageSize = p
+	}
+
+	if keepAlive, ok := config.SearchEngine["keepAlive"]; ok {
+		finder.KeepAlive = keepAlive
+	}
+
+	if conn.healthcheck {
+		if err := finder.Ping(); err != nil {
+			log.Errorln("Couldn't connect to elasticsearch", err)
+			return nil, err
+		}
+	}
+
+	return &finder, nil
+}
+
+// Ping checks that the search engine is reachable, giving up after the configured healthcheck timeout
+func (f *RESTFinder) Ping() error {
+	ctx := context.Background()
+	if f.conn != nil && f.conn.healthcheckTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.conn.healthcheckTimeout)
+		defer cancel()
+	}
+
+	var info struct {
+		Version struct {
+			Distribution string `json:"distribution"`
+			Number       string `json:"number"`
+		} `json:"version"`
+	}
+	if err := f.do(ctx, http.MethodGet, "/", nil, &info); err != nil {
+		return err
+	}
+
+	log.Debugf("Connected to %s %s at %s", info.Version.Distribution, info.Version.Number, f.Endpoint)
+	return nil
+}
+
+// DoGet does the get of an ID and returns a resource
+func (f *RESTFinder) DoGet(id string) (*Resource, error) {
+	path := fmt.Sprintf("/%s/_doc/%s", url.PathEscape(f.Index), url.PathEscape(id))
+
+	var hit restHit
+	err := f.do(context.Background(), http.MethodGet, path, nil, &hit)
+	if err == errNotFound || (err == nil && hit.Found != nil && !*hit.Found) {
+		return nil, fmt.Errorf("%w: %s in %s", ErrResourceNotFound, id, f.Index)
+	}
+
+	if err != nil {
+		log.Errorln("Failed to execute fetch from elasticsearch", err)
+		return nil, err
+	}
+
+	r, err := f.Fields.decode(hit.ID, hit.Source)
+	if err != nil {
+		log.Errorln("Couldn't deserialize response from elasticsearch into resource", err)
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// DoDateRangeQuery searches elasticsearch for a variable number of date range queries and returns
+// every matching resource.  The full result set is paged through, see ForEachDateRangeQuery.
+func (f *RESTFinder) DoDateRangeQuery(drqs ...*DateRangeQuery) ([]*Resource, error) {
+	var resourceList []*Resource
+	_, err := f.ForEachDateRangeQuery(func(r *Resource) error {
+		resourceList = append(resourceList, r)
+		return nil
+	}, drqs...)
+	if err != nil {
+		return nil, err
+	}
+
+	return resourceList, nil
+}
+
+// ForEachDateRangeQuery searches elasticsearch for a variable number of date range queries and calls fn
+// for each matching resource, scrolling through the result set a page at a time.
+func (f *RESTFinder) ForEachDateRangeQuery(fn ResourceFunc, drqs ...*DateRangeQuery) (*QueryStats, error) {
+	stats := &QueryStats{}
+
+	q, err := constructBoolQuery(drqs)
+	if err != nil {
+		log.Errorln("Failed to construct date range query", err)
+		return stats, err
+	}
+
+	src, err := q.Source()
+	if err != nil {
+		log.Errorln("Failed to construct date range query", err)
+		return stats, err
+	}
+
+	ctx := context.Background()
+	params := url.Values{"scroll": []string{f.KeepAlive}}
+	path := fmt.Sprintf("/%s/_search?%s", url.PathEscape(f.Index), params.Encode())
+
+	var result restSearchResult
+	err = f.do(ctx, http.MethodPost, path, map[string]interface{}{
+		"query":            src,
+		"size":             f.PageSize,
+		"sort":             []string{"_doc"},
+		"track_total_hits": true,
+	}, &result)
+	if err != nil {
+		log.Errorln("Failed to execute search", err)
+		return stats, err
+	}
+
+	defer func() {
+		if result.ScrollID == "" {
+			return
+		}
+
+		body := map[string]interface{}{"scroll_id": result.ScrollID}
+		if err := f.do(ctx, http.MethodDelete, "/_search/scroll", body, nil); err != nil {
+			log.Warnf("Failed to clear scroll context, %s", err)
+		}
+	}()
+
+	stats.TotalHits = totalHits(result.Hits.Total)
+	log.Debugf("Found a total of %d resources", stats.TotalHits)
+
+	for page := 1; len(result.Hits.Hits) > 0; page++ {
+		log.Debugf("Query page %d took %d milliseconds", page, result.Took)
+
+		for _, hit := range result.Hits.Hits {
+			log.Debugf("Hit source: %s", hit.Source)
+
+			r, err := f.Fields.decode(hit.ID, hit.Source)
+			if err != nil {
+				log.Errorln("Couldn't deserialize response from elasticsearch into resource", err)
+				continue
+			}
+
+			if err := fn(r); err != nil {
+				return stats, err
+			}
+			stats.Processed++
+		}
+
+		if result.ScrollID == "" {
+			break
+		}
+
+		// fetch the next page of results
+		scrollID := result.ScrollID
+		result = restSearchResult{}
+		err := f.do(ctx, http.MethodPost, "/_search/scroll", map[string]interface{}{
+			"scroll":    f.KeepAlive,
+			"scroll_id": scrollID,
+		}, &result)
+		if err != nil {
+			log.Errorln("Failed to execute search", err)
+			return stats, err
+		}
+
+		if result.ScrollID == "" {
+			result.ScrollID = scrollID
+		}
+	}
+
+	if stats.TotalHits == 0 {
+		log.Debugf("Found no resources")
+	} else if stats.Processed < stats.TotalHits {
+		log.Warnf("Processed %d of %d resources returned by the query", stats.Processed, stats.TotalHits)
+	}
+
+	return stats, nil
+}
+
+// do executes a request against the elasticsearch api, JSON encoding the body and decoding the response into out
+func (f *RESTFinder) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		log.Debugf("Elasticsearch request %s %s: %s", method, path, string(data))
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, f.Endpoint+path, reader)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if f.conn != nil {
+		f.conn.authorize(req)
+	}
+
+	res, err := f.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+
+	if res.StatusCode > 299 {
+		resBody, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("Got a non-success http response from http %s to %s, %d %s", method, path, res.StatusCode, resBody)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// totalHits reads the total hit count from a search response.  Elasticsearch 7+ returns an object
+// with the value and relation, older versions return the number.
+func totalHits(raw json.RawMessage) int64 {
+	var total struct {
+		Value int64 `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &total); err == nil {
+		return total.Value
+	}
+
+	var n int64
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n
+	}
+
+	return 0
+}
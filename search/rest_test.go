@@ -0,0 +1,131 @@
+package search
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/YaleSpinup/reaper/common"
+)
+
+// newTestRESTServer returns a fake elasticsearch 8 server that serves the given pages of hits through the scroll api
+func newTestRESTServer(t *testing.T, total int, pages [][]string) *httptest.Server {
+	page := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		t.Logf("serving %s %s", r.Method, r.URL)
+
+		switch {
+		case r.URL.Path == "/":
+			w.Write([]byte(`{"version": {"number": "8.11.0"}}`))
+			return
+		case r.Method == http.MethodDelete && r.URL.Path == "/_search/scroll":
+			w.Write([]byte(`{"succeeded": true}`))
+			return
+		case r.Method == http.MethodGet && r.URL.Path == "/resources/_doc/i-1":
+			w.Write([]byte(`{"_id": "i-1", "found": true, "_source": {"yale:org": "testorg", "status": "created"}}`))
+			return
+		case r.Method == http.MethodGet:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"_id": "i-missing", "found": false}`))
+			return
+		case r.URL.Path == "/resources/_search":
+			var body map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("Failed to decode search body: %s", err)
+			}
+
+			if _, ok := body["query"]; !ok {
+				t.Errorf("Expected query in search body, got %+v", body)
+			}
+
+			if r.URL.Query().Get("scroll") == "" {
+				t.Errorf("Expected scroll parameter in search request")
+			}
+		case r.URL.Path != "/_search/scroll":
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var hits []string
+		if page < len(pages) {
+			for _, id := range pages[page] {
+				hits = append(hits, fmt.Sprintf(`{"_id": "%s", "_source": {"yale:org": "testorg", "status": "created"}}`, id))
+			}
+		}
+		page++
+
+		fmt.Fprintf(w, `{"_scroll_id": "abc123", "took": 1, "hits": {"total": {"value": %d, "relation": "eq"}, "hits": [%s]}}`, total, strings.Join(hits, ","))
+	}))
+}
+
+func TestRESTFinderForEachDateRangeQuery(t *testing.T) {
+	server := newTestRESTServer(t, 3, [][]string{{"i-1", "i-2"}, {"i-3"}})
+	defer server.Close()
+
+	finder, err := NewRESTFinder(&common.Config{SearchEngine: map[string]string{"endpoint": server.URL, "version": "8", "pageSize": "2"}})
+	if err != nil {
+		t.Fatalf("Expected nil error creating finder, got %s", err)
+	}
+
+	var ids []string
+	stats, err := finder.ForEachDateRangeQuery(func(r *Resource) error {
+		ids = append(ids, r.ID)
+		return nil
+	}, &DateRangeQuery{Field: "yale:renewed_at", Lte: "now-1d", TermFilter: []TermQuery{{Term: "status", Value: "created"}}})
+	if err != nil {
+		t.Fatalf("Expected nil error from query, got %s", err)
+	}
+
+	expected := []string{"i-1", "i-2", "i-3"}
+	if !reflect.DeepEqual(expected, ids) {
+		t.Errorf("Expected resources %v, got %v", expected, ids)
+	}
+
+	if stats.TotalHits != 3 || stats.Processed != 3 {
+		t.Errorf("Expected 3 total hits and 3 processed, got %+v", stats)
+	}
+}
+
+func TestRESTFinderDoGet(t *testing.T) {
+	server := newTestRESTServer(t, 0, nil)
+	defer server.Close()
+
+	finder, err := NewRESTFinder(&common.Config{SearchEngine: map[string]string{"endpoint": server.URL, "version": "8"}})
+	if err != nil {
+		t.Fatalf("Expected nil error creating finder, got %s", err)
+	}
+
+	r, err := finder.DoGet("i-1")
+	if err != nil {
+		t.Fatalf("Expected nil error getting resource, got %s", err)
+	}
+
+	if r.ID != "i-1" || r.Org != "testorg" || r.Status != "created" {
+		t.Errorf("Unexpected resource %+v", r)
+	}
+
+	if _, err := finder.DoGet("i-missing"); !errors.Is(err, ErrResourceNotFound) {
+		t.Errorf("Expected ErrResourceNotFound for missing resource, got %v", err)
+	}
+}
+
+func TestTotalHits(t *testing.T) {
+	tests := map[string]int64{
+		`{"value": 42, "relation": "eq"}`: 42,
+		`17`:                              17,
+		`null`:                            0,
+	}
+
+	for raw, expected := range tests {
+		if actual := totalHits(json.RawMessage(raw)); actual != expected {
+			t.Errorf("Expected total hits for %s to be %d, got %d", raw, expected, actual)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"strconv"
 
 	"github.com/YaleSpinup/reaper/common"
@@ -11,9 +12,31 @@ import (
 	elastic "gopkg.in/olivere/elastic.v5"
 )
 
+const (
+	// DefaultPageSize is the number of hits fetched per page when scrolling through a result set
+	DefaultPageSize = 500
+
+	// DefaultKeepAlive is how long elasticsearch keeps the scroll context alive between pages
+	DefaultKeepAlive = "5m"
+)
+
 // Finder is the connection to elasticsearch
 type Finder struct {
-	Client *elastic.Client
+	Client    *elastic.Client
+	Index     string
+	Type      string
+	Fields    Fields
+	PageSize  int
+	KeepAlive string
+}
+
+// ResourceFunc is called for each resource found by a query.  Returning an error stops the iteration.
+type ResourceFunc func(*Resource) error
+
+// QueryStats summarizes how much of a result set was processed
+type QueryStats struct {
+	TotalHits int64
+	Processed int64
 }
 
 // DateRangeQuery is the properties required for a date range query in the resource finder
@@ -29,10 +52,19 @@ type DateRangeQuery struct {
 	TermFilter []TermQuery
 }
 
-// TermQuery is the properties required for a term query in the resource finder
+// TermQuery is the properties required for a term query in the resource finder.  By default it's an exact
+// match of the Term field against the Value, Op selects another kind of clause (see the Op constants) and
+// Not excludes the resources matching the clause instead.
 type TermQuery struct {
-	Term  string
-	Value string
+	Term   string
+	Value  string
+	Op     string
+	Values []string
+	Not    bool
+	Gt     string
+	Gte    string
+	Lt     string
+	Lte    string
 }
 
 // NewTermQueryList generates a new list of term queries from a map of strings to strings
@@ -48,7 +80,7 @@ func NewTermQueryList(filters map[string]string) []TermQuery {
 // NewFinder creates a new elasticsearch finder.  It doesn't currently allow for
 // all possible elasticsearch settings, but only the ones we need.
 func NewFinder(config *common.Config) (*Finder, error) {
-	log.Debugf("Configuring Finder with %+v", *config)
+	log.Debugf("Configuring Finder with endpoint %s", config.SearchEngine["endpoint"])
 
 	var finder Finder
 	var options []elastic.ClientOptionFunc
@@ -72,6 +104,49 @@ func NewFinder(config *common.Config) (*Finder, error) {
 		options = append(options, elastic.SetSniff(s))
 	}
 
+	finder.Index, finder.Type = indexAndType(config.SearchEngine)
+	finder.Fields = NewFields(config.SearchEngine)
+	log.Debugf("Searching index '%s' type '%s' with fields %+v", finder.Index, finder.Type, finder.Fields)
+
+	finder.PageSize = DefaultPageSize
+	if pageSize, ok := config.SearchEngine["pageSize"]; ok {
+		p, err := strconv.Atoi(pageSize)
+		if err != nil || p <= 0 {
+			log.Errorf("Cannot parse pageSize value '%s' as a positive integer, %s", pageSize, err)
+			return &finder, fmt.Errorf("invalid pageSize '%s'", pageSize)
+		}
+		finder.PageSize = p
+	}
+
+	finder.KeepAlive = DefaultKeepAlive
+	if keepAlive, ok := config.SearchEngine["keepAlive"]; ok {
+		finder.KeepAlive = keepAlive
+	}
+
+	conn, err := newConnection(config.SearchEngine)
+	if err != nil {
+		log.Errorln("Couldn't configure the elasticsearch connection", err)
+		return &finder, err
+	}
+
+	options = append(options, elastic.SetHttpClient(conn.client), elastic.SetHealthcheck(conn.healthcheck))
+
+	if conn.username != "" {
+		options = append(options, elastic.SetBasicAuth(conn.username, conn.password))
+	}
+
+	if len(conn.headers) > 0 {
+		options = append(options, elastic.SetHeaders(conn.headers))
+	}
+
+	if conn.healthcheckTimeout > 0 {
+		options = append(options, elastic.SetHealthcheckTimeout(conn.healthcheckTimeout), elastic.SetHealthcheckTimeoutStartup(conn.healthcheckTimeout))
+	}
+
+	if conn.healthcheckInterval > 0 {
+		options = append(options, elastic.SetHealthcheckInterval(conn.healthcheckInterval))
+	}
+
 	client, err := elastic.NewClient(options...)
 	if err != nil {
 		log.Errorln("Couldn't create new elasticsearch client", err)
@@ -82,68 +157,140 @@ func NewFinder(config *common.Config) (*Finder, error) {
 	return &finder, nil
 }
 
+// Stop stops the sniffer and healthcheck goroutines of the elasticsearch client
+func (f *Finder) Stop() {
+	if f.Client != nil {
+		f.Client.Stop()
+	}
+}
+
 // DoGet does the get of an ID and returns a resource
-func (f *Finder) DoGet(index, rtype, id string) (*Resource, error) {
+func (f *Finder) DoGet(id string) (*Resource, error) {
 	// Do the needful get document
-	doc, err := elastic.NewGetService(f.Client).Index(index).Type(rtype).Id(id).Do(context.Background())
+	get := elastic.NewGetService(f.Client).Index(f.Index).Id(id)
+	if f.Type != "" {
+		get = get.Type(f.Type)
+	}
+
+	doc, err := get.Do(context.Background())
+	if elastic.IsNotFound(err) || (err == nil && (!doc.Found || doc.Source == nil)) {
+		return nil, fmt.Errorf("%w: %s in %s/%s", ErrResourceNotFound, id, f.Index, f.Type)
+	}
+
 	if err != nil {
 		log.Errorln("Failed to execute fetch from elasticsearch", err)
 		return nil, err
 	}
 
 	// Deserialize doc.Source into a Resource
-	var r Resource
-	err = json.Unmarshal(*doc.Source, &r)
+	r, err := f.Fields.decode(id, *doc.Source)
 	if err != nil {
 		log.Errorln("Couldn't deserialize response from elasticsearch into resource", err)
 		return nil, err
 	}
 
-	return &r, nil
+	return r, nil
 }
 
-// DoDateRangeQuery searches elasticsearch for a variable number of date range queries
-func (f *Finder) DoDateRangeQuery(index, rtype string, drqs ...*DateRangeQuery) ([]*Resource, error) {
+// DoDateRangeQuery searches elasticsearch for a variable number of date range queries and returns
+// every matching resource.  The full result set is paged through, see ForEachDateRangeQuery.
+func (f *Finder) DoDateRangeQuery(drqs ...*DateRangeQuery) ([]*Resource, error) {
 	var resourceList []*Resource
+	_, err := f.ForEachDateRangeQuery(func(r *Resource) error {
+		resourceList = append(resourceList, r)
+		return nil
+	}, drqs...)
+	if err != nil {
+		return nil, err
+	}
+
+	return resourceList, nil
+}
+
+// ForEachDateRangeQuery searches elasticsearch for a variable number of date range queries and calls fn
+// for each matching resource.  Results are scrolled through a page at a time so that result sets larger
+// than a single page aren't truncated.  The returned stats report the total number of hits and the number
+// of resources that were actually processed.
+func (f *Finder) ForEachDateRangeQuery(fn ResourceFunc, drqs ...*DateRangeQuery) (*QueryStats, error) {
+	stats := &QueryStats{}
 	log.Debugf("Client status: %s", f.Client.String())
 
 	q, err := constructBoolQuery(drqs)
 	if err != nil {
 		log.Errorln("Failed to construct date range query", err)
-		return nil, err
+		return stats, err
 	}
 
-	// execute search on index
-	searchResult, err := f.Client.Search().Index(index).Query(q).Type(rtype).Size(1000).Do(context.Background())
-	if err != nil {
-		log.Errorln("Failed to execute search", err)
-		return nil, err
+	pageSize := f.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
 	}
 
-	log.Debugf("Query took %d milliseconds", searchResult.TookInMillis)
+	keepAlive := f.KeepAlive
+	if keepAlive == "" {
+		keepAlive = DefaultKeepAlive
+	}
+
+	ctx := context.Background()
+	scroll := f.Client.Scroll(f.Index).Query(q).Size(pageSize).KeepAlive(keepAlive)
+	if f.Type != "" {
+		scroll = scroll.Type(f.Type)
+	}
+
+	defer func() {
+		if err := scroll.Clear(ctx); err != nil {
+			log.Warnf("Failed to clear scroll context, %s", err)
+		}
+	}()
+
+	for page := 1; ; page++ {
+		// execute search on index, fetching the next page of results
+		searchResult, err := scroll.Do(ctx)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			log.Errorln("Failed to execute search", err)
+			return stats, err
+		}
+
+		log.Debugf("Query page %d took %d milliseconds", page, searchResult.TookInMillis)
 
-	if searchResult.Hits.TotalHits > 0 {
-		log.Debugf("Found a total of %d resources", searchResult.Hits.TotalHits)
+		if searchResult.Hits == nil || len(searchResult.Hits.Hits) == 0 {
+			break
+		}
+
+		if page == 1 {
+			stats.TotalHits = searchResult.Hits.TotalHits
+			log.Debugf("Found a total of %d resources", stats.TotalHits)
+		}
 
 		// Iterate through results
 		for _, hit := range searchResult.Hits.Hits {
 			log.Debugf("Hit source: %s", *hit.Source)
 
-			// Deserialize hit.Source into a Resource (could also be just a map[string]interface{}).
-			var r Resource
-			err := json.Unmarshal(*hit.Source, &r)
+			// Deserialize hit.Source into a Resource
+			r, err := f.Fields.decode(hit.Id, *hit.Source)
 			if err != nil {
 				log.Errorln("Couldn't deserialize response from elasticsearch into resource", err)
 				continue
 			}
-			r.ID = hit.Id
-			resourceList = append(resourceList, &r)
+
+			if err := fn(r); err != nil {
+				return stats, err
+			}
+			stats.Processed++
 		}
-	} else {
+	}
+
+	if stats.TotalHits == 0 {
 		log.Debugf("Found no resources")
+	} else if stats.Processed < stats.TotalHits {
+		log.Warnf("Processed %d of %d resources returned by the query", stats.Processed, stats.TotalHits)
 	}
 
-	return resourceList, nil
+	return stats, nil
 }
 
 // constructRangeQuery puts the query together from the given properties
@@ -194,9 +341,20 @@ func constructBoolQuery(drqs []*DateRangeQuery) (elastic.Query, error) {
 		boolQuery.Must(rangeQuery)
 
 		for _, tq := range drq.TermFilter {
-			log.Debugf("Adding term filter (%s:%s) to boolean query", tq.Term, tq.Value)
-			keyword := fmt.Sprintf("%s.keyword", tq.Term)
-			boolQuery.Filter(elastic.NewTermQuery(keyword, tq.Value))
+			q, not, err := tq.query()
+			if err != nil {
+				log.Errorln("Failed to construct filter", err)
+				return nil, err
+			}
+
+			if not {
+				log.Debugf("Adding %s must_not filter (%s:%s) to boolean query", tq.op(), tq.Term, tq.Value)
+				boolQuery.MustNot(q)
+				continue
+			}
+
+			log.Debugf("Adding %s filter (%s:%s) to boolean query", tq.op(), tq.Term, tq.Value)
+			boolQuery.Filter(q)
 		}
 	}
 
@@ -1,8 +1,14 @@
 package search
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/YaleSpinup/reaper/common"
 )
 
 var testFilters = map[string]string{
@@ -44,3 +50,99 @@ LOOP:
 func TestFinder(t *testing.T) {
 	t.Log("No tests")
 }
+
+// newTestScrollServer returns a fake elasticsearch server that serves the given pages of hits through the scroll api
+func newTestScrollServer(t *testing.T, total int, pages [][]string) *httptest.Server {
+	page := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.Method == http.MethodHead || r.URL.Path == "/":
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{}`))
+			return
+		case r.Method == http.MethodDelete:
+			w.Write([]byte(`{"succeeded": true}`))
+			return
+		}
+
+		var hits []string
+		if page < len(pages) {
+			for _, id := range pages[page] {
+				hits = append(hits, fmt.Sprintf(`{"_id": "%s", "_source": {"yale:org": "testorg", "status": "created"}}`, id))
+			}
+		}
+		page++
+
+		t.Logf("serving scroll page %d for %s %s", page, r.Method, r.URL)
+		fmt.Fprintf(w, `{"_scroll_id": "abc123", "took": 1, "hits": {"total": %d, "hits": [%s]}}`, total, strings.Join(hits, ","))
+	}))
+}
+
+func TestForEachDateRangeQuery(t *testing.T) {
+	server := newTestScrollServer(t, 5, [][]string{{"i-1", "i-2"}, {"i-3", "i-4"}, {"i-5"}})
+	defer server.Close()
+
+	finder, err := NewFinder(&common.Config{SearchEngine: map[string]string{"endpoint": server.URL, "pageSize": "2"}})
+	if err != nil {
+		t.Fatalf("Expected nil error creating finder, got %s", err)
+	}
+
+	var ids []string
+	stats, err := finder.ForEachDateRangeQuery(func(r *Resource) error {
+		ids = append(ids, r.ID)
+		return nil
+	}, &DateRangeQuery{Field: "yale:renewed_at", Lte: "now-1d"})
+	if err != nil {
+		t.Fatalf("Expected nil error from query, got %s", err)
+	}
+
+	expected := []string{"i-1", "i-2", "i-3", "i-4", "i-5"}
+	if !reflect.DeepEqual(expected, ids) {
+		t.Errorf("Expected resources %v, got %v", expected, ids)
+	}
+
+	if stats.TotalHits != 5 || stats.Processed != 5 {
+		t.Errorf("Expected 5 total hits and 5 processed, got %+v", stats)
+	}
+}
+
+func TestForEachDateRangeQueryIncomplete(t *testing.T) {
+	server := newTestScrollServer(t, 3, [][]string{{"i-1", "i-2"}})
+	defer server.Close()
+
+	finder, err := NewFinder(&common.Config{SearchEngine: map[string]string{"endpoint": server.URL, "pageSize": "2"}})
+	if err != nil {
+		t.Fatalf("Expected nil error creating finder, got %s", err)
+	}
+
+	stats, err := finder.ForEachDateRangeQuery(func(r *Resource) error { return nil }, &DateRangeQuery{Field: "yale:renewed_at"})
+	if err != nil {
+		t.Fatalf("Expected nil error from query, got %s", err)
+	}
+
+	if stats.TotalHits != 3 || stats.Processed != 2 {
+		t.Errorf("Expected 3 total hits and 2 processed, got %+v", stats)
+	}
+}
+
+func TestNewFields(t *testing.T) {
+	defaults := Fields{RenewedAt: "yale:renewed_at", CreatedAt: "yale:created_at", Status: "status", Org: "yale:org"}
+	if actual := NewFields(nil); actual != defaults {
+		t.Errorf("Expected default fields %+v, got %+v", defaults, actual)
+	}
+
+	expected := Fields{RenewedAt: "renewed", CreatedAt: "created", Status: "status", Org: "owner"}
+	if actual := NewFields(map[string]string{"renewedAtField": "renewed", "createdAtField": "created", "orgField": "owner"}); actual != expected {
+		t.Errorf("Expected fields %+v, got %+v", expected, actual)
+	}
+
+	if index, rtype := indexAndType(nil); index != "resources" || rtype != "server" {
+		t.Errorf("Expected default index and type resources/server, got %s/%s", index, rtype)
+	}
+
+	if index, rtype := indexAndType(map[string]string{"index": "resources-dev", "documentType": ""}); index != "resources-dev" || rtype != "" {
+		t.Errorf("Expected typeless resources-dev index, got %s/%s", index, rtype)
+	}
+}
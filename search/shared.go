@@ -0,0 +1,193 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+	log "github.com/sirupsen/logrus"
+	elastic "gopkg.in/olivere/elastic.v5"
+)
+
+const (
+	// DefaultMinBackoff is the initial wait before reconnecting to the search engine after a failure
+	DefaultMinBackoff = 1 * time.Second
+
+	// DefaultMaxBackoff is the longest wait between attempts to reconnect to the search engine
+	DefaultMaxBackoff = 2 * time.Minute
+)
+
+// ErrSourceUnavailable is returned when the resource source can't be connected to
+var ErrSourceUnavailable = errors.New("resource source unavailable")
+
+// SharedSource is a long-lived resource source meant to be shared by the batch loop and the http handlers.
+// The underlying source is connected on first use and reconnected when a connection error is returned.
+// Failed connection attempts back off exponentially between MinBackoff and MaxBackoff.
+type SharedSource struct {
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	connect  func() (ResourceSource, error)
+	mu       sync.Mutex
+	source   ResourceSource
+	failures int
+	retryAt  time.Time
+}
+
+// NewSharedSource creates a new shared resource source from the configuration
+func NewSharedSource(config *common.Config) *SharedSource {
+	return newSharedSource(func() (ResourceSource, error) {
+		return NewResourceSource(config)
+	})
+}
+
+func newSharedSource(connect func() (ResourceSource, error)) *SharedSource {
+	return &SharedSource{
+		MinBackoff: DefaultMinBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+		connect:    connect,
+	}
+}
+
+// Connect returns the current connection to the resource source, connecting if there isn't one and
+// the backoff from the last failed attempt has passed
+func (s *SharedSource) Connect() (ResourceSource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.source != nil {
+		return s.source, nil
+	}
+
+	now := time.Now()
+	if now.Before(s.retryAt) {
+		return nil, fmt.Errorf("%w, retrying connection in %s", ErrSourceUnavailable, s.retryAt.Sub(now).Round(time.Second))
+	}
+
+	source, err := s.connect()
+	if err != nil {
+		s.failures++
+
+		backoff := s.MinBackoff
+		for i := 1; i < s.failures && backoff < s.MaxBackoff; i++ {
+			backoff *= 2
+		}
+
+		if backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+		s.retryAt = now.Add(backoff)
+
+		log.Errorf("Failed to connect to the resource source (attempt %d), retrying in %s: %s", s.failures, backoff, err)
+		return nil, fmt.Errorf("%w: %s", ErrSourceUnavailable, err)
+	}
+
+	if s.failures > 0 {
+		log.Infof("Reconnected to the resource source after %d failed attempts", s.failures)
+	}
+
+	s.source = source
+	s.failures = 0
+	s.retryAt = time.Time{}
+
+	return source, nil
+}
+
+// disconnect drops the given connection so that the next call reconnects.  The connection is stopped
+// first if it runs in the background.
+func (s *SharedSource) disconnect(source ResourceSource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.source != source {
+		return
+	}
+
+	if st, ok := source.(stopper); ok {
+		st.Stop()
+	}
+	s.source = nil
+}
+
+// do runs fn against the current connection.  If fn fails with a connection error and retry allows it,
+// the connection is dropped and fn is tried once more against a new connection.
+func (s *SharedSource) do(fn func(ResourceSource) error, retry func() bool) error {
+	for attempt := 1; ; attempt++ {
+		source, err := s.Connect()
+		if err != nil {
+			return err
+		}
+
+		err = fn(source)
+		if !isConnectionError(err) {
+			return err
+		}
+
+		log.Warnf("Lost connection to the resource source: %s", err)
+		s.disconnect(source)
+
+		if attempt > 1 || !retry() {
+			return err
+		}
+	}
+}
+
+// DoGet gets a resource by id from the shared source
+func (s *SharedSource) DoGet(id string) (*Resource, error) {
+	var r *Resource
+	err := s.do(func(source ResourceSource) error {
+		var err error
+		r, err = source.DoGet(id)
+		return err
+	}, func() bool { return true })
+
+	return r, err
+}
+
+// DoDateRangeQuery returns every resource matching the date range queries from the shared source
+func (s *SharedSource) DoDateRangeQuery(drqs ...*DateRangeQuery) ([]*Resource, error) {
+	var resourceList []*Resource
+	_, err := s.ForEachDateRangeQuery(func(r *Resource) error {
+		resourceList = append(resourceList, r)
+		return nil
+	}, drqs...)
+	if err != nil {
+		return nil, err
+	}
+
+	return resourceList, nil
+}
+
+// ForEachDateRangeQuery calls fn for each resource matching the date range queries from the shared source.  The
+// query is only retried after a connection error if no resources were processed yet.
+func (s *SharedSource) ForEachDateRangeQuery(fn ResourceFunc, drqs ...*DateRangeQuery) (*QueryStats, error) {
+	stats := &QueryStats{}
+	err := s.do(func(source ResourceSource) error {
+		var err error
+		stats, err = source.ForEachDateRangeQuery(fn, drqs...)
+		return err
+	}, func() bool { return stats == nil || stats.Processed == 0 })
+
+	if stats == nil {
+		stats = &QueryStats{}
+	}
+
+	return stats, err
+}
+
+// isConnectionError checks if the error is caused by a failed connection to the search engine
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, elastic.ErrNoClient) || elastic.IsConnErr(err) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
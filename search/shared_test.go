@@ -0,0 +1,85 @@
+package search
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// flakySource fails queries with a connection error a number of times before succeeding
+type flakySource struct {
+	*MemorySource
+	failures int
+	stopped  int
+}
+
+func (f *flakySource) Stop() {
+	f.stopped++
+}
+
+func (f *flakySource) DoGet(id string) (*Resource, error) {
+	if f.failures > 0 {
+		f.failures--
+		return nil, &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	}
+	return f.MemorySource.DoGet(id)
+}
+
+func TestSharedSourceBackoff(t *testing.T) {
+	attempts := 0
+	shared := newSharedSource(func() (ResourceSource, error) {
+		attempts++
+		return nil, errors.New("boom")
+	})
+	shared.MinBackoff = time.Hour
+
+	for i := 0; i < 3; i++ {
+		if _, err := shared.DoGet("i-1"); !errors.Is(err, ErrSourceUnavailable) {
+			t.Errorf("Expected ErrSourceUnavailable, got %v", err)
+		}
+	}
+
+	if attempts != 1 {
+		t.Errorf("Expected 1 connection attempt during the backoff, got %d", attempts)
+	}
+}
+
+func TestSharedSourceReconnect(t *testing.T) {
+	memory := NewMemorySource([]*Document{{ID: "i-1", Source: map[string]interface{}{"status": "created"}}})
+
+	flaky := &flakySource{MemorySource: memory, failures: 1}
+	connects := 0
+	shared := newSharedSource(func() (ResourceSource, error) {
+		connects++
+		if connects == 1 {
+			return flaky, nil
+		}
+		return memory, nil
+	})
+
+	r, err := shared.DoGet("i-1")
+	if err != nil {
+		t.Fatalf("Expected the shared source to reconnect after a connection error, got %s", err)
+	}
+
+	if r.ID != "i-1" {
+		t.Errorf("Expected resource i-1, got %+v", r)
+	}
+
+	if connects != 2 {
+		t.Errorf("Expected 2 connections, got %d", connects)
+	}
+
+	if flaky.stopped != 1 {
+		t.Errorf("Expected the dropped connection to be stopped once, got %d", flaky.stopped)
+	}
+
+	if _, err := shared.DoGet("i-2"); !errors.Is(err, ErrResourceNotFound) {
+		t.Errorf("Expected ErrResourceNotFound, got %v", err)
+	}
+
+	if connects != 2 {
+		t.Errorf("Expected not found errors to keep the connection, got %d connections", connects)
+	}
+}
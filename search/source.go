@@ -0,0 +1,56 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/YaleSpinup/reaper/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrResourceNotFound is returned when getting a resource by an id that doesn't exist
+var ErrResourceNotFound = errors.New("resource not found")
+
+// ResourceSource is the interface for finding resources by age and filters and getting them by id.  The
+// index, document type and field names are part of the source configuration.
+type ResourceSource interface {
+	DoGet(id string) (*Resource, error)
+	DoDateRangeQuery(drqs ...*DateRangeQuery) ([]*Resource, error)
+	ForEachDateRangeQuery(fn ResourceFunc, drqs ...*DateRangeQuery) (*QueryStats, error)
+}
+
+// stopper is implemented by the resource sources that run background work which needs to be stopped when the
+// source is dropped
+type stopper interface {
+	Stop()
+}
+
+// NewResourceSource creates a new resource source based on the configured searchEngine type.  If the
+// type is unset, elasticsearch is used.  The elasticsearch api is chosen by the configured version, the
+// typeless api is used for elasticsearch 7, 8 and opensearch, otherwise the elasticsearch 5 client is used.
+func NewResourceSource(config *common.Config) (ResourceSource, error) {
+	log.Debugf("Creating a new resource source with config %+v", config.SearchEngine)
+	switch config.SearchEngine["type"] {
+	case "", "elasticsearch":
+		switch config.SearchEngine["version"] {
+		case "", "5":
+			return NewFinder(config)
+		case "7", "8", "opensearch":
+			return NewRESTFinder(config)
+		}
+		return nil, fmt.Errorf("Unsupported elasticsearch version %s", config.SearchEngine["version"])
+	case "fixture":
+		file, ok := config.SearchEngine["file"]
+		if !ok {
+			return nil, fmt.Errorf("file required and not found in fixture searchEngine configuration")
+		}
+		source, err := NewFixtureSource(file)
+		if err != nil {
+			return nil, err
+		}
+		source.Index, source.Type = indexAndType(config.SearchEngine)
+		source.Fields = NewFields(config.SearchEngine)
+		return source, nil
+	}
+	return nil, fmt.Errorf("Couldn't find appropriate resource source to create for %s", config.SearchEngine["type"])
+}
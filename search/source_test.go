@@ -0,0 +1,22 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/YaleSpinup/reaper/common"
+)
+
+func TestNewResourceSource(t *testing.T) {
+	if _, err := NewResourceSource(&common.Config{SearchEngine: map[string]string{"type": "fixture"}}); err == nil {
+		t.Error("Expected error for fixture source without a file, got nil")
+	}
+
+	if _, err := NewResourceSource(&common.Config{SearchEngine: map[string]string{"type": "foobar"}}); err == nil {
+		t.Error("Expected error for unknown source type, got nil")
+	}
+
+	source, err := NewResourceSource(&common.Config{SearchEngine: map[string]string{"type": "fixture", "file": "/does/not/exist.json"}})
+	if err == nil {
+		t.Errorf("Expected error for missing fixture file, got source %+v", source)
+	}
+}
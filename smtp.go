@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// TLSNone never secures the connection to the mail server
+	TLSNone = "none"
+
+	// TLSStartTLS requires the connection to be upgraded with STARTTLS
+	TLSStartTLS = "starttls"
+
+	// TLSImplicit connects to the mail server over TLS, usually on port 465
+	TLSImplicit = "implicit"
+)
+
+// DefaultMailTimeout is how long the whole conversation with the mail server may take
+const DefaultMailTimeout = 30 * time.Second
+
+// MailError is an error sending mail, along with the step of the SMTP conversation that failed
+type MailError struct {
+	Step   string
+	Server string
+	Err    error
+}
+
+// Error returns the error message
+func (e *MailError) Error() string {
+	return fmt.Sprintf("smtp %s with %s failed: %s", e.Step, e.Server, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *MailError) Unwrap() error {
+	return e.Err
+}
+
+// SMTPTransport delivers email through an SMTP server
+type SMTPTransport struct {
+	Address  string
+	Host     string
+	Username string
+	Password string
+	TLS      string
+	Auth     string
+	Timeout  time.Duration
+
+	// TLSConfig verifies the mail server when the connection is secured
+	TLSConfig *tls.Config
+}
+
+// NewSMTPTransport creates an SMTP transport from the email configuration
+func NewSMTPTransport(c common.Emailer) (*SMTPTransport, error) {
+	host, _, err := net.SplitHostPort(c.Mailserver)
+	if err != nil || host == "" {
+		return nil, fmt.Errorf("The given mail server value (%s) seems invalid, it should be the form foo.bar.com:25", c.Mailserver)
+	}
+
+	m := &SMTPTransport{
+		Address:   c.Mailserver,
+		Host:      host,
+		Username:  c.Username,
+		Password:  c.Password,
+		TLS:       strings.ToLower(c.TLS),
+		Auth:      strings.ToLower(c.Auth),
+		Timeout:   DefaultMailTimeout,
+		TLSConfig: &tls.Config{ServerName: host},
+	}
+
+	switch m.TLS {
+	case "", TLSNone, TLSStartTLS, TLSImplicit:
+	default:
+		return nil, fmt.Errorf("unknown email tls mode %s, expected none, starttls or implicit", c.TLS)
+	}
+
+	switch m.Auth {
+	case "":
+		if m.Username != "" && m.Password != "" {
+			m.Auth = "plain"
+		}
+	case "none":
+		m.Auth = ""
+	case "plain", "login", "cram-md5":
+		if m.Username == "" || m.Password == "" {
+			return nil, fmt.Errorf("username and password required for %s email auth", m.Auth)
+		}
+	default:
+		return nil, fmt.Errorf("unknown email auth mechanism %s, expected none, plain, login or cram-md5", c.Auth)
+	}
+
+	if c.CACert != "" {
+		pem, err := os.ReadFile(c.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read email ca certificate: %s", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in email ca certificate %s", c.CACert)
+		}
+		m.TLSConfig.RootCAs = pool
+	}
+
+	return m, nil
+}
+
+// Name returns the name of the transport
+func (m *SMTPTransport) Name() string {
+	return "smtp"
+}
+
+// Send delivers the message from the sender to a single recipient
+func (m *SMTPTransport) Send(from, to string, message []byte) error {
+	log.Debugf("Sending mail to %s from %s via %s (tls: %s, auth: %s)", to, from, m.Address, m.TLS, m.Auth)
+
+	c, err := m.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Hello(localName()); err != nil {
+		return m.error("hello", err)
+	}
+
+	if err := m.startTLS(c); err != nil {
+		return err
+	}
+
+	if err := m.authenticate(c); err != nil {
+		return err
+	}
+
+	if err := c.Mail(from); err != nil {
+		return m.error("sender "+from, err)
+	}
+
+	if err := c.Rcpt(to); err != nil {
+		return m.error("recipient "+to, err)
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return m.error("data", err)
+	}
+
+	if _, err := w.Write(message); err != nil {
+		return m.error("data", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return m.error("data", err)
+	}
+
+	if err := c.Quit(); err != nil {
+		return m.error("quit", err)
+	}
+
+	return nil
+}
+
+// dial connects to the mail server, over TLS in implicit mode
+func (m *SMTPTransport) dial() (*smtp.Client, error) {
+	dialer := &net.Dialer{Timeout: m.Timeout}
+	conn, err := dialer.Dial("tcp", m.Address)
+	if err != nil {
+		return nil, m.error("connect", err)
+	}
+	conn.SetDeadline(time.Now().Add(m.Timeout))
+
+	if m.TLS == TLSImplicit {
+		tlsConn := tls.Client(conn, m.TLSConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, m.error("tls handshake", err)
+		}
+		conn = tlsConn
+	}
+
+	c, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return nil, m.error("greeting", err)
+	}
+
+	return c, nil
+}
+
+// startTLS upgrades the connection with STARTTLS when it's required, or offered and the tls mode isn't set
+func (m *SMTPTransport) startTLS(c *smtp.Client) error {
+	if m.TLS == TLSNone || m.TLS == TLSImplicit {
+		return nil
+	}
+
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		if m.TLS == TLSStartTLS {
+			return m.error("starttls", errors.New("the server doesn't support STARTTLS"))
+		}
+		return nil
+	}
+
+	if err := c.StartTLS(m.TLSConfig); err != nil {
+		return m.error("starttls", err)
+	}
+
+	return nil
+}
+
+// authenticate authenticates with the configured mechanism
+func (m *SMTPTransport) authenticate(c *smtp.Client) error {
+	if m.Auth == "" {
+		return nil
+	}
+
+	step := "auth " + m.Auth
+	ok, mechanisms := c.Extension("AUTH")
+	if !ok {
+		return m.error(step, errors.New("the server doesn't support authentication"))
+	}
+
+	if !strings.Contains(" "+strings.ToUpper(mechanisms)+" ", " "+strings.ToUpper(m.Auth)+" ") {
+		return m.error(step, fmt.Errorf("the server only supports %s", mechanisms))
+	}
+
+	var auth smtp.Auth
+	switch m.Auth {
+	case "plain":
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	case "login":
+		auth = &loginAuth{username: m.Username, password: m.Password, host: m.Host}
+	case "cram-md5":
+		auth = smtp.CRAMMD5Auth(m.Username, m.Password)
+	}
+
+	if err := c.Auth(auth); err != nil {
+		return m.error(step, err)
+	}
+
+	return nil
+}
+
+func (m *SMTPTransport) error(step string, err error) error {
+	return &MailError{Step: step, Server: m.Address, Err: err}
+}
+
+// loginAuth implements the LOGIN authentication mechanism, which net/smtp doesn't provide
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+// Start begins the LOGIN authentication, refusing to send the credentials over an unencrypted connection to a remote server
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+
+	return "LOGIN", nil, nil
+}
+
+// Next answers the username and password challenges
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSuffix(string(fromServer), ":")) {
+	case "username", "user name":
+		return []byte(a.username), nil
+	case "password":
+		return []byte(a.password), nil
+	}
+
+	return nil, fmt.Errorf("unexpected LOGIN challenge %s", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// localName returns the name the reaper introduces itself to the mail server with
+func localName() string {
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return "localhost"
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+)
+
+// testSMTPServer is a minimal SMTP server for testing the smtp transport
+type testSMTPServer struct {
+	listener net.Listener
+	tls      *tls.Config
+	implicit bool
+	starttls bool
+	auth     string
+	username string
+	password string
+
+	mu       sync.Mutex
+	from     string
+	messages []string
+	authed   string
+}
+
+// newTestSMTPServer starts an SMTP server, returning it along with the path to the PEM encoded CA certificate it uses
+func newTestSMTPServer(t *testing.T, implicit, starttls bool, auth string) (*testSMTPServer, string) {
+	cert, caFile := testCertificate(t)
+	s := &testSMTPServer{
+		tls:      &tls.Config{Certificates: []tls.Certificate{cert}},
+		implicit: implicit,
+		starttls: starttls,
+		auth:     auth,
+		username: "bob",
+		password: "sekret",
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	s.listener = l
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s, caFile
+}
+
+func (s *testSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	isTLS := false
+	if s.implicit {
+		conn = tls.Server(conn, s.tls)
+		isTLS = true
+	}
+
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 test ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO":
+			lines := []string{"test"}
+			if s.starttls && !isTLS {
+				lines = append(lines, "STARTTLS")
+			}
+			if s.auth != "" {
+				lines = append(lines, "AUTH "+s.auth)
+			}
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				tp.PrintfLine("250%s%s", sep, l)
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 ready")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, isTLS = tlsConn, true
+			tp = textproto.NewConn(conn)
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			var username, password string
+			switch strings.ToUpper(mechanism) {
+			case "PLAIN":
+				b, _ := base64.StdEncoding.DecodeString(initial)
+				if parts := strings.Split(string(b), "\x00"); len(parts) == 3 {
+					username, password = parts[1], parts[2]
+				}
+			case "LOGIN":
+				tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Username:")))
+				username = readBase64(tp)
+				tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Password:")))
+				password = readBase64(tp)
+			case "CRAM-MD5":
+				challenge := "<123.456@test>"
+				tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(challenge)))
+				d := hmac.New(md5.New, []byte(s.password))
+				d.Write([]byte(challenge))
+				if readBase64(tp) == fmt.Sprintf("%s %s", s.username, hex.EncodeToString(d.Sum(nil))) {
+					username, password = s.username, s.password
+				}
+			}
+
+			if username != s.username || password != s.password {
+				tp.PrintfLine("535 authentication failed")
+				continue
+			}
+
+			s.mu.Lock()
+			s.authed = strings.ToUpper(mechanism)
+			s.mu.Unlock()
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			s.mu.Lock()
+			s.from = arg
+			s.mu.Unlock()
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			b, _ := io.ReadAll(tp.DotReader())
+			s.mu.Lock()
+			s.messages = append(s.messages, string(b))
+			s.mu.Unlock()
+			tp.PrintfLine("250 ok")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 unknown command")
+		}
+	}
+}
+
+func readBase64(tp *textproto.Conn) string {
+	line, _ := tp.ReadLine()
+	b, _ := base64.StdEncoding.DecodeString(line)
+	return string(b)
+}
+
+// testCertificate creates a self signed certificate for 127.0.0.1 and writes it to a temporary CA file
+func testCertificate(t *testing.T) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write ca certificate: %s", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+func TestNewSMTPTransport(t *testing.T) {
+	_, caFile := testCertificate(t)
+
+	tests := []struct {
+		config common.Emailer
+		err    bool
+	}{
+		{common.Emailer{Mailserver: "mail.example.com:25", From: "reaper@example.com"}, false},
+		{common.Emailer{Mailserver: "mail.example.com:465", From: "Reaper <reaper@example.com>", TLS: "implicit", Auth: "LOGIN", Username: "bob", Password: "sekret", CACert: caFile}, false},
+		{common.Emailer{Mailserver: "mail.example.com", From: "reaper@example.com"}, true},
+		{common.Emailer{Mailserver: "mail.example.com:25", From: "reaper@example.com", TLS: "ssl"}, true},
+		{common.Emailer{Mailserver: "mail.example.com:25", From: "reaper@example.com", Auth: "xoauth2", Username: "bob", Password: "sekret"}, true},
+		{common.Emailer{Mailserver: "mail.example.com:25", From: "reaper@example.com", Auth: "login"}, true},
+		{common.Emailer{Mailserver: "mail.example.com:25", From: "reaper@example.com", CACert: "/does/not/exist.pem"}, true},
+		{common.Emailer{Mailserver: "mail.example.com:25", From: "reaper@example.com", CACert: "smtp_test.go"}, true},
+	}
+
+	for _, test := range tests {
+		if _, err := NewSMTPTransport(test.config); test.err != (err != nil) {
+			t.Errorf("Expected error %t for %+v, got %v", test.err, test.config, err)
+		}
+	}
+
+	m, err := NewSMTPTransport(common.Emailer{Mailserver: "mail.example.com:25", From: "reaper@example.com", Username: "bob", Password: "sekret"})
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if m.Auth != "plain" || m.TLS != "" || m.Host != "mail.example.com" {
+		t.Errorf("Expected plain auth with opportunistic tls, got %+v", m)
+	}
+}
+
+func TestSMTPTransportSend(t *testing.T) {
+	tests := []struct {
+		name     string
+		implicit bool
+		starttls bool
+		auth     string
+		config   common.Emailer
+		ca       bool
+		authed   string
+		step     string
+	}{
+		{name: "unauthenticated relay", config: common.Emailer{TLS: "none"}},
+		{name: "opportunistic starttls", starttls: true, ca: true},
+		{name: "starttls not offered", config: common.Emailer{TLS: "starttls"}, step: "starttls"},
+		{name: "starttls login", starttls: true, auth: "PLAIN LOGIN", config: common.Emailer{TLS: "starttls", Auth: "login", Username: "bob", Password: "sekret"}, ca: true, authed: "LOGIN"},
+		{name: "starttls untrusted", starttls: true, config: common.Emailer{TLS: "starttls"}, step: "starttls"},
+		{name: "implicit cram-md5", implicit: true, auth: "CRAM-MD5", config: common.Emailer{TLS: "implicit", Auth: "cram-md5", Username: "bob", Password: "sekret"}, ca: true, authed: "CRAM-MD5"},
+		{name: "implicit untrusted", implicit: true, config: common.Emailer{TLS: "implicit"}, step: "tls handshake"},
+		{name: "plain", auth: "PLAIN", config: common.Emailer{Username: "bob", Password: "sekret"}, authed: "PLAIN"},
+		{name: "wrong password", auth: "PLAIN", config: common.Emailer{Username: "bob", Password: "wrong"}, step: "auth plain"},
+		{name: "mechanism not offered", auth: "PLAIN", config: common.Emailer{Auth: "login", Username: "bob", Password: "sekret"}, step: "auth login"},
+		{name: "auth not offered", config: common.Emailer{Username: "bob", Password: "sekret"}, step: "auth plain"},
+	}
+
+	for _, test := range tests {
+		server, caFile := newTestSMTPServer(t, test.implicit, test.starttls, test.auth)
+
+		config := test.config
+		config.Mailserver = server.listener.Addr().String()
+		config.From = "Spinup <spinup@example.com>"
+		if test.ca {
+			config.CACert = caFile
+		}
+		withTestTransport(t, config)
+
+		err := SendMail(config, "bob@example.com", "Please renew your Spinup TryIT server", "<p>Hello bob</p>")
+		if test.step != "" {
+			var mailErr *MailError
+			if !errors.As(err, &mailErr) || mailErr.Step != test.step {
+				t.Errorf("%s: Expected the %s step to fail, got %v", test.name, test.step, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: Expected nil error, got %s", test.name, err)
+			continue
+		}
+
+		server.mu.Lock()
+		if len(server.messages) != 1 || !strings.Contains(server.messages[0], "Subject: Please renew your Spinup TryIT server") {
+			t.Errorf("%s: Expected the message to be delivered, got %v", test.name, server.messages)
+		}
+
+		if server.from != "FROM:<spinup@example.com>" || server.authed != test.authed {
+			t.Errorf("%s: Expected envelope sender spinup@example.com and %q auth, got %s and %q", test.name, test.authed, server.from, server.authed)
+		}
+		server.mu.Unlock()
+	}
+}
+
+func TestSMTPTransportConnectError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	config := common.Emailer{Mailserver: address, From: "spinup@example.com"}
+	withTestTransport(t, config)
+	err = SendMail(config, "bob@example.com", "subject", "<p>body</p>")
+
+	var mailErr *MailError
+	if !errors.As(err, &mailErr) || mailErr.Step != "connect" || !strings.Contains(err.Error(), "smtp connect with "+address+" failed") {
+		t.Errorf("Expected a connect error, got %v", err)
+	}
+}
+
+func TestLoginAuth(t *testing.T) {
+	a := &loginAuth{username: "bob", password: "sekret", host: "mail.example.com"}
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "mail.example.com"}); err == nil {
+		t.Error("Expected error for unencrypted connection, got nil")
+	}
+
+	if mechanism, _, err := a.Start(&smtp.ServerInfo{Name: "mail.example.com", TLS: true}); err != nil || mechanism != "LOGIN" {
+		t.Errorf("Expected LOGIN, got %s (%v)", mechanism, err)
+	}
+
+	for challenge, expected := range map[string]string{"Username:": "bob", "Password:": "sekret"} {
+		if out, err := a.Next([]byte(challenge), true); err != nil || string(out) != expected {
+			t.Errorf("Expected %s for %s, got %s (%v)", expected, challenge, out, err)
+		}
+	}
+
+	if _, err := a.Next([]byte("Token:"), true); err == nil {
+		t.Error("Expected error for unexpected challenge, got nil")
+	}
+}
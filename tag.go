@@ -18,6 +18,9 @@ type Tagger struct {
 	ResourceID string
 	Org        string
 	Client     HTTPClient
+
+	// User is the acting user sent to the tagging endpoint, it defaults to 'reaper'
+	User string
 }
 
 // NewTagger creates a new tagging object
@@ -66,7 +69,12 @@ func (t Tagger) Tag(tags map[string]string) error {
 		return err
 	}
 
-	req.Header.Set("X-Forwarded-User", "reaper")
+	user := t.User
+	if user == "" {
+		user = "reaper"
+	}
+
+	req.Header.Set("X-Forwarded-User", user)
 	req.Header.Set("X-Auth-token", t.Token)
 	req.Header.Set("Content-Type", "application/json")
 	res, err := t.Client.Do(req)
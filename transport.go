@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/YaleSpinup/reaper/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultSendmail is the path to the sendmail binary
+const DefaultSendmail = "/usr/sbin/sendmail"
+
+// Transport delivers a built email message from the sender to a single recipient
+type Transport interface {
+	Name() string
+	Send(from, to string, message []byte) error
+}
+
+// MailTransport is the email transport created from the email configuration when the config is loaded.  It's
+// shared by every email that's sent, so a transport that writes to a file can serialize the writes.
+var MailTransport Transport
+
+// NewTransport creates an email transport from the email configuration
+func NewTransport(c common.Emailer) (Transport, error) {
+	name := strings.ToLower(c.Transport)
+	log.Debugf("Creating a new %s email transport", name)
+
+	switch name {
+	case "", "smtp":
+		return NewSMTPTransport(c)
+	case "sendmail":
+		path := DefaultSendmail
+		if c.Sendmail != "" {
+			path = c.Sendmail
+		}
+		return &SendmailTransport{Path: path}, nil
+	case "maildir":
+		if c.Path == "" {
+			return nil, fmt.Errorf("path required and not found in the maildir email transport configuration")
+		}
+		return &MaildirTransport{Path: c.Path}, nil
+	case "mbox":
+		if c.Path == "" {
+			return nil, fmt.Errorf("path required and not found in the mbox email transport configuration")
+		}
+		return &MboxTransport{Path: c.Path}, nil
+	case "capture":
+		return Outbox, nil
+	}
+
+	return nil, fmt.Errorf("Unknown email transport name, %s", c.Transport)
+}
+
+// SendmailTransport delivers email by piping it to a sendmail compatible binary
+type SendmailTransport struct {
+	Path string
+}
+
+// Name returns the name of the transport
+func (s *SendmailTransport) Name() string {
+	return "sendmail"
+}
+
+// Send pipes the message to sendmail with the envelope sender and recipient
+func (s *SendmailTransport) Send(from, to string, message []byte) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(s.Path, "-i", "-f", from, "--", to)
+	cmd.Stdin = bytes.NewReader(unixLineEndings(message))
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to send mail with %s: %s %s", s.Path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// MaildirTransport delivers each email as a file in the new directory of a maildir
+type MaildirTransport struct {
+	Path string
+}
+
+// maildirCount keeps the maildir file names unique within the process
+var maildirCount uint64
+
+// Name returns the name of the transport
+func (m *MaildirTransport) Name() string {
+	return "maildir"
+}
+
+// Send writes the message to the tmp directory of the maildir and moves it to the new directory once it's complete
+func (m *MaildirTransport) Send(from, to string, message []byte) error {
+	for _, dir := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.Path, dir), 0700); err != nil {
+			return fmt.Errorf("failed to create maildir %s: %s", m.Path, err)
+		}
+	}
+
+	name := fmt.Sprintf("%d.%d_%d.%s", time.Now().Unix(), os.Getpid(), atomic.AddUint64(&maildirCount, 1), localName())
+	tmp := filepath.Join(m.Path, "tmp", name)
+	if err := os.WriteFile(tmp, unixLineEndings(message), 0600); err != nil {
+		return fmt.Errorf("failed to write to maildir %s: %s", m.Path, err)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(m.Path, "new", name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to deliver to maildir %s: %s", m.Path, err)
+	}
+
+	return nil
+}
+
+// MboxTransport appends each email to an mbox file
+type MboxTransport struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// mboxFromRe matches the lines that need to be quoted in an mbox so they aren't taken for the start of a message
+var mboxFromRe = regexp.MustCompile(`^>*From `)
+
+// Name returns the name of the transport
+func (m *MboxTransport) Name() string {
+	return "mbox"
+}
+
+// Send appends the message to the mbox after a From line, quoting the lines in the message that start with From
+func (m *MboxTransport) Send(from, to string, message []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	buffer := new(bytes.Buffer)
+	fmt.Fprintf(buffer, "From %s %s\n", from, time.Now().UTC().Format(time.ANSIC))
+
+	scanner := bufio.NewScanner(bytes.NewReader(unixLineEndings(message)))
+	scanner.Buffer(make([]byte, 64*1024), len(message)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if mboxFromRe.MatchString(line) {
+			line = ">" + line
+		}
+		buffer.WriteString(line + "\n")
+	}
+	buffer.WriteString("\n")
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(m.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open mbox %s: %s", m.Path, err)
+	}
+
+	if _, err := f.Write(buffer.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write to mbox %s: %s", m.Path, err)
+	}
+
+	return f.Close()
+}
+
+// unixLineEndings converts the CRLF line endings of a message to the LF line endings used by local delivery
+func unixLineEndings(message []byte) []byte {
+	return bytes.ReplaceAll(message, []byte("\r\n"), []byte("\n"))
+}
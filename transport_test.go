@@ -0,0 +1,220 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/YaleSpinup/reaper/common"
+)
+
+var testMessage = []byte("From: <spinup@example.com>\r\nTo: <bob@example.com>\r\nSubject: renew\r\n\r\nHello bob\r\nFrom the Spinup Team\r\n")
+
+func TestNewTransport(t *testing.T) {
+	tests := []struct {
+		config common.Emailer
+		name   string
+		err    bool
+	}{
+		{common.Emailer{Mailserver: "mail.example.com:25"}, "smtp", false},
+		{common.Emailer{Transport: "SMTP", Mailserver: "mail.example.com:25"}, "smtp", false},
+		{common.Emailer{Transport: "smtp"}, "", true},
+		{common.Emailer{Transport: "sendmail"}, "sendmail", false},
+		{common.Emailer{Transport: "maildir", Path: "/tmp/maildir"}, "maildir", false},
+		{common.Emailer{Transport: "maildir"}, "", true},
+		{common.Emailer{Transport: "mbox", Path: "/tmp/mbox"}, "mbox", false},
+		{common.Emailer{Transport: "mbox"}, "", true},
+		{common.Emailer{Transport: "capture"}, "capture", false},
+		{common.Emailer{Transport: "pigeon"}, "", true},
+	}
+
+	for _, test := range tests {
+		tr, err := NewTransport(test.config)
+		if test.err != (err != nil) {
+			t.Errorf("Expected error %t for %+v, got %v", test.err, test.config, err)
+			continue
+		}
+
+		if err == nil && tr.Name() != test.name {
+			t.Errorf("Expected %s transport, got %s", test.name, tr.Name())
+		}
+	}
+
+	tr, _ := NewTransport(common.Emailer{Transport: "sendmail"})
+	if s := tr.(*SendmailTransport); s.Path != DefaultSendmail {
+		t.Errorf("Expected the default sendmail path, got %s", s.Path)
+	}
+}
+
+func TestSendmailTransport(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	sendmail := filepath.Join(dir, "sendmail")
+	script := "#!/bin/sh\necho \"$@\" > " + out + ".args\ncat > " + out + "\n"
+	if err := os.WriteFile(sendmail, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := &SendmailTransport{Path: sendmail}
+	if err := tr.Send("spinup@example.com", "bob@example.com", testMessage); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	args, _ := os.ReadFile(out + ".args")
+	if strings.TrimSpace(string(args)) != "-i -f spinup@example.com -- bob@example.com" {
+		t.Errorf("Unexpected sendmail arguments %s", args)
+	}
+
+	message, _ := os.ReadFile(out)
+	if string(message) != strings.ReplaceAll(string(testMessage), "\r\n", "\n") {
+		t.Errorf("Expected the message with unix line endings, got %q", message)
+	}
+
+	failing := filepath.Join(dir, "failing")
+	if err := os.WriteFile(failing, []byte("#!/bin/sh\necho 'no such user' >&2\nexit 67\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	tr.Path = failing
+	if err := tr.Send("spinup@example.com", "bob@example.com", testMessage); err == nil || !strings.Contains(err.Error(), "no such user") {
+		t.Errorf("Expected the sendmail error, got %v", err)
+	}
+}
+
+func TestMaildirTransport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Maildir")
+	tr := &MaildirTransport{Path: dir}
+
+	for i := 0; i < 2; i++ {
+		if err := tr.Send("spinup@example.com", "bob@example.com", testMessage); err != nil {
+			t.Fatalf("Expected nil error, got %s", err)
+		}
+	}
+
+	files, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("Expected 2 messages in new, got %d (%v)", len(files), err)
+	}
+
+	if tmp, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(tmp) != 0 {
+		t.Errorf("Expected tmp to be empty, got %d files", len(tmp))
+	}
+
+	message, _ := os.ReadFile(filepath.Join(dir, "new", files[0].Name()))
+	if !strings.HasPrefix(string(message), "From: <spinup@example.com>\nTo:") {
+		t.Errorf("Unexpected maildir message %q", message)
+	}
+}
+
+func TestMboxTransport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	tr := &MboxTransport{Path: path}
+
+	for i := 0; i < 2; i++ {
+		if err := tr.Send("spinup@example.com", "bob@example.com", testMessage); err != nil {
+			t.Fatalf("Expected nil error, got %s", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mbox := string(data)
+
+	if strings.Count(mbox, "\nFrom spinup@example.com ") != 1 || !strings.HasPrefix(mbox, "From spinup@example.com ") {
+		t.Errorf("Expected 2 messages in the mbox, got %s", mbox)
+	}
+
+	if strings.Count(mbox, "\n>From the Spinup Team\n") != 2 || strings.Contains(mbox, "\r") {
+		t.Errorf("Expected the From lines in the body to be quoted, got %q", mbox)
+	}
+}
+
+// withTestTransport sets the MailTransport created from the email configuration for the duration of the test
+func withTestTransport(t *testing.T, config common.Emailer) {
+	tr, err := NewTransport(config)
+	if err != nil {
+		t.Fatalf("Failed to create the %s email transport: %s", config.Transport, err)
+	}
+
+	orig := MailTransport
+	MailTransport = tr
+	t.Cleanup(func() { MailTransport = orig })
+}
+
+func TestSendMailTransport(t *testing.T) {
+	origOutbox := Outbox
+	Outbox = NewCaptureTransport(10)
+	defer func() { Outbox = origOutbox }()
+
+	config := common.Emailer{Transport: "capture", From: "Spinup <spinup@example.com>"}
+	withTestTransport(t, config)
+	if err := SendMail(config, "Bob <bob@example.com>", "Please renew", "<p>Hello bob</p>"); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	messages := Outbox.Messages()
+	if len(messages) != 1 || messages[0].From != "spinup@example.com" || messages[0].To != "bob@example.com" {
+		t.Errorf("Expected the envelope addresses to be captured, got %+v", messages)
+	}
+
+	for _, test := range []struct{ from, to string }{{"spinup", "bob@example.com"}, {"spinup@example.com", "bob"}} {
+		config.From = test.from
+		if err := SendMail(config, test.to, "Please renew", "<p>Hello bob</p>"); err == nil {
+			t.Errorf("Expected error for invalid address %+v, got nil", test)
+		}
+	}
+}
+
+func TestSendMailSharedTransport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	config := common.Emailer{Transport: "mbox", Path: path, From: "spinup@example.com"}
+
+	orig := MailTransport
+	MailTransport = nil
+	defer func() { MailTransport = orig }()
+
+	if err := SendMail(config, "bob@example.com", "Please renew", "<p>Hello bob</p>"); err == nil {
+		t.Error("Expected error without a configured transport, got nil")
+	}
+
+	withTestTransport(t, config)
+
+	// the sends share the transport, so the appends to the mbox don't interleave
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- SendMail(config, "bob@example.com", "Please renew", "<p>Hello bob</p>")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Expected nil error, got %s", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := strings.Split(string(data), "\n\nFrom spinup@example.com ")
+	if len(messages) != 20 {
+		t.Fatalf("Expected 20 messages in the mbox, got %d", len(messages))
+	}
+
+	for _, m := range messages {
+		if strings.Count(m, "Subject: Please renew") != 1 {
+			t.Errorf("Expected each message to be appended whole, got %q", m)
+		}
+	}
+}